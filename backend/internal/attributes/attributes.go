@@ -0,0 +1,210 @@
+package attributes
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ecom-backend/internal/models"
+)
+
+const maxStringLength = 500
+
+// Keys become document field paths, so they are restricted to identifiers
+var keyPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
+// FieldError reports one attribute that failed validation
+type FieldError struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError collects every problem with a set of attributes so the
+// client can fix them in one go
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, len(e))
+	for i, fe := range e {
+		reasons[i] = fe.Key + ": " + fe.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// ValidateSchema checks the attribute definitions an admin submits for a
+// category
+func ValidateSchema(defs []models.AttributeDefinition) error {
+	var errs ValidationError
+	seen := map[string]bool{}
+	for _, def := range defs {
+		if !keyPattern.MatchString(def.Key) {
+			errs = append(errs, FieldError{def.Key, "key must start with a letter and contain only letters, digits and underscores"})
+			continue
+		}
+		if seen[def.Key] {
+			errs = append(errs, FieldError{def.Key, "duplicate key"})
+			continue
+		}
+		seen[def.Key] = true
+
+		switch def.Type {
+		case models.AttributeString, models.AttributeBoolean, models.AttributeNumber:
+		case models.AttributeEnum:
+			if len(def.Options) == 0 {
+				errs = append(errs, FieldError{def.Key, "enum attributes need options"})
+			}
+		case models.AttributeUnit:
+			if def.Unit == "" {
+				errs = append(errs, FieldError{def.Key, "unit attributes need a unit"})
+			}
+		default:
+			errs = append(errs, FieldError{def.Key, fmt.Sprintf("unknown type %q", def.Type)})
+		}
+
+		if def.Min != nil && def.Max != nil && *def.Min > *def.Max {
+			errs = append(errs, FieldError{def.Key, "min is greater than max"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Validate checks product attribute values against a category's
+// definitions and returns them normalised for storage: numbers as float64
+// and unit values as {value, unit} converted to the definition's unit.
+func Validate(defs []models.AttributeDefinition, values map[string]interface{}) (map[string]interface{}, error) {
+	var errs ValidationError
+	normalised := map[string]interface{}{}
+
+	byKey := map[string]models.AttributeDefinition{}
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+
+	for _, key := range sortedKeys(values) {
+		def, ok := byKey[key]
+		if !ok {
+			errs = append(errs, FieldError{key, "not defined for this category"})
+			continue
+		}
+		if values[key] == nil {
+			continue // null clears an optional attribute
+		}
+		value, reason := normalise(def, values[key])
+		if reason != "" {
+			errs = append(errs, FieldError{key, reason})
+			continue
+		}
+		normalised[key] = value
+	}
+
+	for _, def := range defs {
+		if value, ok := values[def.Key]; def.Required && (!ok || value == nil) {
+			errs = append(errs, FieldError{def.Key, "required"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return normalised, nil
+}
+
+func normalise(def models.AttributeDefinition, value interface{}) (interface{}, string) {
+	switch def.Type {
+	case models.AttributeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, "must be a string"
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, "must not be empty"
+		}
+		if len(s) > maxStringLength {
+			return nil, fmt.Sprintf("must be at most %d characters", maxStringLength)
+		}
+		return s, ""
+
+	case models.AttributeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return nil, "must be a string"
+		}
+		for _, option := range def.Options {
+			if s == option {
+				return s, ""
+			}
+		}
+		return nil, "must be one of " + strings.Join(def.Options, ", ")
+
+	case models.AttributeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, "must be true or false"
+		}
+		return b, ""
+
+	case models.AttributeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return nil, "must be a number"
+		}
+		if reason := checkRange(def, n); reason != "" {
+			return nil, reason
+		}
+		return n, ""
+
+	case models.AttributeUnit:
+		// A bare number is taken to be in the definition's unit
+		n, unit := 0.0, def.Unit
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case map[string]interface{}:
+			var ok bool
+			if n, ok = v["value"].(float64); !ok {
+				return nil, "value must be a number"
+			}
+			if u, ok := v["unit"].(string); ok && u != "" {
+				unit = u
+			}
+		default:
+			return nil, `must be a number or {"value": number, "unit": string}`
+		}
+
+		converted, ok := Convert(n, unit, def.Unit)
+		if !ok {
+			return nil, fmt.Sprintf("cannot convert %s to %s", unit, def.Unit)
+		}
+		if reason := checkRange(def, converted); reason != "" {
+			return nil, reason
+		}
+		return map[string]interface{}{"value": converted, "unit": def.Unit}, ""
+	}
+
+	return nil, fmt.Sprintf("unknown type %q", def.Type)
+}
+
+func checkRange(def models.AttributeDefinition, n float64) string {
+	if def.Min != nil && n < *def.Min {
+		return fmt.Sprintf("must be at least %g", *def.Min)
+	}
+	if def.Max != nil && n > *def.Max {
+		return fmt.Sprintf("must be at most %g", *def.Max)
+	}
+	return ""
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,236 @@
+package attributes
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"ecom-backend/internal/models"
+)
+
+func bound(n float64) *float64 {
+	return &n
+}
+
+var testDefs = []models.AttributeDefinition{
+	{Key: "brand", Type: models.AttributeString, Required: true},
+	{Key: "color", Type: models.AttributeEnum, Options: []string{"red", "blue"}},
+	{Key: "waterproof", Type: models.AttributeBoolean},
+	{Key: "pages", Type: models.AttributeNumber, Min: bound(1), Max: bound(2000)},
+	{Key: "weight", Type: models.AttributeUnit, Unit: "kg", Max: bound(50)},
+}
+
+// reasons maps each failing key to its reason
+func reasons(t *testing.T, err error) map[string]string {
+	t.Helper()
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want a ValidationError", err)
+	}
+	got := map[string]string{}
+	for _, fe := range verr {
+		got[fe.Key] = fe.Reason
+	}
+	return got
+}
+
+func TestValidateSchema(t *testing.T) {
+	if err := ValidateSchema(testDefs); err != nil {
+		t.Fatalf("valid schema: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		def  models.AttributeDefinition
+	}{
+		{name: "unknown type", def: models.AttributeDefinition{Key: "size", Type: "date"}},
+		{name: "empty type", def: models.AttributeDefinition{Key: "size"}},
+		{name: "enum without options", def: models.AttributeDefinition{Key: "size", Type: models.AttributeEnum}},
+		{name: "unit without a unit", def: models.AttributeDefinition{Key: "size", Type: models.AttributeUnit}},
+		{name: "min above max", def: models.AttributeDefinition{Key: "size", Type: models.AttributeNumber, Min: bound(5), Max: bound(1)}},
+		{name: "key with a dot", def: models.AttributeDefinition{Key: "size.cm", Type: models.AttributeNumber}},
+		{name: "key starting with a digit", def: models.AttributeDefinition{Key: "3d", Type: models.AttributeBoolean}},
+		{name: "operator key", def: models.AttributeDefinition{Key: "$where", Type: models.AttributeString}},
+	}
+	for _, tc := range cases {
+		if err := ValidateSchema([]models.AttributeDefinition{tc.def}); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+
+	duplicate := []models.AttributeDefinition{testDefs[0], testDefs[0]}
+	if got := reasons(t, ValidateSchema(duplicate)); got["brand"] != "duplicate key" {
+		t.Errorf("duplicate keys: %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	got, err := Validate(testDefs, map[string]interface{}{
+		"brand":      "  Acme ",
+		"color":      "red",
+		"waterproof": true,
+		"pages":      float64(300),
+		"weight":     map[string]interface{}{"value": float64(500), "unit": "g"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"brand":      "Acme",
+		"color":      "red",
+		"waterproof": true,
+		"pages":      float64(300),
+		"weight":     map[string]interface{}{"value": 0.5, "unit": "kg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalised = %v, want %v", got, want)
+	}
+
+	// A bare number is in the definition's unit and null clears an attribute
+	got, err = Validate(testDefs, map[string]interface{}{"brand": "Acme", "weight": float64(2), "color": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got["weight"], map[string]interface{}{"value": float64(2), "unit": "kg"}) {
+		t.Errorf("bare weight = %v", got["weight"])
+	}
+	if _, ok := got["color"]; ok {
+		t.Error("null color was kept")
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	cases := []struct {
+		name   string
+		values map[string]interface{}
+		key    string
+	}{
+		{name: "missing required", values: map[string]interface{}{"color": "red"}, key: "brand"},
+		{name: "null required", values: map[string]interface{}{"brand": nil}, key: "brand"},
+		{name: "blank string", values: map[string]interface{}{"brand": "   "}, key: "brand"},
+		{name: "number for a string", values: map[string]interface{}{"brand": float64(1)}, key: "brand"},
+		{name: "enum outside the options", values: map[string]interface{}{"brand": "Acme", "color": "green"}, key: "color"},
+		{name: "string for a boolean", values: map[string]interface{}{"brand": "Acme", "waterproof": "yes"}, key: "waterproof"},
+		{name: "string for a number", values: map[string]interface{}{"brand": "Acme", "pages": "300"}, key: "pages"},
+		{name: "below min", values: map[string]interface{}{"brand": "Acme", "pages": float64(0)}, key: "pages"},
+		{name: "above max", values: map[string]interface{}{"brand": "Acme", "pages": float64(2001)}, key: "pages"},
+		{name: "above max after conversion", values: map[string]interface{}{"brand": "Acme", "weight": map[string]interface{}{"value": float64(120), "unit": "lb"}}, key: "weight"},
+		{name: "unit of another dimension", values: map[string]interface{}{"brand": "Acme", "weight": map[string]interface{}{"value": float64(1), "unit": "cm"}}, key: "weight"},
+		{name: "unit value not a number", values: map[string]interface{}{"brand": "Acme", "weight": map[string]interface{}{"value": "1"}}, key: "weight"},
+		{name: "unit as a string", values: map[string]interface{}{"brand": "Acme", "weight": "1kg"}, key: "weight"},
+		{name: "undefined key", values: map[string]interface{}{"brand": "Acme", "isbn": "123"}, key: "isbn"},
+	}
+	for _, tc := range cases {
+		got, err := Validate(testDefs, tc.values)
+		if err == nil {
+			t.Errorf("%s: accepted as %v", tc.name, got)
+			continue
+		}
+		if _, ok := reasons(t, err)[tc.key]; !ok {
+			t.Errorf("%s: no error for %s in %v", tc.name, tc.key, err)
+		}
+	}
+
+	// Every problem is reported at once
+	_, err := Validate(testDefs, map[string]interface{}{"color": "green", "pages": float64(0)})
+	if got := reasons(t, err); len(got) != 3 {
+		t.Errorf("reported %v, want brand, color and pages", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	got, err := Filter(testDefs, map[string]string{
+		"attr.brand":      "Acme",
+		"attr.color":      "red,blue",
+		"attr.waterproof": "true",
+		"attr.pages.min":  "100",
+		"attr.weight.min": "1",
+		"attr.weight.max": "5",
+		"search":          "ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"attributes.brand":        "Acme",
+		"attributes.color":        bson.M{"$in": []string{"red", "blue"}},
+		"attributes.waterproof":   true,
+		"attributes.pages":        bson.M{"$gte": float64(100)},
+		"attributes.weight.value": bson.M{"$gte": float64(1), "$lte": float64(5)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+
+	cases := []struct {
+		name  string
+		query map[string]string
+		key   string
+	}{
+		{name: "undefined key", query: map[string]string{"attr.isbn": "123"}, key: "isbn"},
+		{name: "empty key", query: map[string]string{"attr.": "x"}, key: ""},
+		{name: "range on a string", query: map[string]string{"attr.brand.min": "a"}, key: "brand"},
+		{name: "range on an enum", query: map[string]string{"attr.color.max": "red"}, key: "color"},
+		{name: "range bound not a number", query: map[string]string{"attr.pages.min": "ten"}, key: "pages"},
+		{name: "unknown bound", query: map[string]string{"attr.pages.avg": "10"}, key: "pages"},
+		{name: "nested bound", query: map[string]string{"attr.pages.min.max": "10"}, key: "pages"},
+		{name: "boolean not a boolean", query: map[string]string{"attr.waterproof": "maybe"}, key: "waterproof"},
+		{name: "number not a number", query: map[string]string{"attr.pages": "many"}, key: "pages"},
+	}
+	for _, tc := range cases {
+		got, err := Filter(testDefs, tc.query)
+		if err == nil {
+			t.Errorf("%s: accepted as %v", tc.name, got)
+			continue
+		}
+		if _, ok := reasons(t, err)[tc.key]; !ok {
+			t.Errorf("%s: no error for %q in %v", tc.name, tc.key, err)
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for from, f := range units {
+		for to, u := range units {
+			converted, ok := Convert(12.5, from, to)
+			if ok != (f.dimension == u.dimension) {
+				t.Errorf("Convert %s to %s: ok = %v", from, to, ok)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			back, _ := Convert(converted, to, from)
+			if math.Abs(back-12.5) > 1e-9 {
+				t.Errorf("%s to %s and back = %v, want 12.5", from, to, back)
+			}
+		}
+	}
+
+	cases := []struct {
+		n        float64
+		from, to string
+		want     float64
+	}{
+		{n: 1, from: "kg", to: "g", want: 1000},
+		{n: 1, from: "lb", to: "kg", want: 0.45359237},
+		{n: 12, from: "in", to: "ft", want: 1},
+		{n: 1, from: "l", to: "ml", want: 1000},
+	}
+	for _, tc := range cases {
+		if got, ok := Convert(tc.n, tc.from, tc.to); !ok || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Convert(%v, %s, %s) = %v, %v; want %v", tc.n, tc.from, tc.to, got, ok, tc.want)
+		}
+	}
+
+	// Units outside the table only convert to themselves
+	if got, ok := Convert(3, "parsec", "parsec"); !ok || got != 3 {
+		t.Errorf("parsec to itself = %v, %v", got, ok)
+	}
+	if _, ok := Convert(3, "parsec", "m"); ok {
+		t.Error("converted an unknown unit")
+	}
+}
@@ -0,0 +1,120 @@
+package attributes
+
+import (
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"ecom-backend/internal/models"
+)
+
+// QueryPrefix marks query parameters that filter on attributes
+const QueryPrefix = "attr."
+
+// HasFilters reports whether the query contains any attribute filters
+func HasFilters(query map[string]string) bool {
+	for param := range query {
+		if strings.HasPrefix(param, QueryPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter turns attribute query parameters into product filter conditions,
+// interpreting them through the category's definitions:
+//
+//	attr.brand=Acme                      string, enum or boolean equality
+//	attr.color=red,blue                  any of several enum values
+//	attr.weight.min=1&attr.weight.max=5  number or unit range, in the definition's unit
+func Filter(defs []models.AttributeDefinition, query map[string]string) (bson.M, error) {
+	byKey := map[string]models.AttributeDefinition{}
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+
+	var errs ValidationError
+	filter := bson.M{}
+	for param, raw := range query {
+		if !strings.HasPrefix(param, QueryPrefix) {
+			continue
+		}
+		key, bound, _ := strings.Cut(strings.TrimPrefix(param, QueryPrefix), ".")
+
+		def, ok := byKey[key]
+		if !ok {
+			errs = append(errs, FieldError{key, "not defined for this category"})
+			continue
+		}
+
+		field := "attributes." + key
+		if def.Type == models.AttributeUnit {
+			field += ".value"
+		}
+
+		switch {
+		case bound == "min" || bound == "max":
+			if def.Type != models.AttributeNumber && def.Type != models.AttributeUnit {
+				errs = append(errs, FieldError{key, "ranges only apply to number and unit attributes"})
+				continue
+			}
+			n, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				errs = append(errs, FieldError{key, bound + " must be a number"})
+				continue
+			}
+			cond, _ := filter[field].(bson.M)
+			if cond == nil {
+				cond = bson.M{}
+			}
+			if bound == "min" {
+				cond["$gte"] = n
+			} else {
+				cond["$lte"] = n
+			}
+			filter[field] = cond
+
+		case bound != "":
+			errs = append(errs, FieldError{key, "unknown filter " + bound})
+
+		default:
+			value, reason := parseValue(def, raw)
+			if reason != "" {
+				errs = append(errs, FieldError{key, reason})
+				continue
+			}
+			filter[field] = value
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return filter, nil
+}
+
+func parseValue(def models.AttributeDefinition, raw string) (interface{}, string) {
+	switch def.Type {
+	case models.AttributeBoolean:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, "must be true or false"
+		}
+		return b, ""
+	case models.AttributeNumber, models.AttributeUnit:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, "must be a number"
+		}
+		return n, ""
+	case models.AttributeEnum:
+		values := strings.Split(raw, ",")
+		if len(values) == 1 {
+			return values[0], ""
+		}
+		return bson.M{"$in": values}, ""
+	default:
+		return raw, ""
+	}
+}
@@ -0,0 +1,40 @@
+package attributes
+
+// unit relates a unit to the base unit of its dimension
+type unit struct {
+	dimension string
+	factor    float64
+}
+
+var units = map[string]unit{
+	"mm": {"length", 0.001},
+	"cm": {"length", 0.01},
+	"m":  {"length", 1},
+	"km": {"length", 1000},
+	"in": {"length", 0.0254},
+	"ft": {"length", 0.3048},
+
+	"mg": {"mass", 0.001},
+	"g":  {"mass", 1},
+	"kg": {"mass", 1000},
+	"oz": {"mass", 28.349523125},
+	"lb": {"mass", 453.59237},
+
+	"ml":   {"volume", 1},
+	"l":    {"volume", 1000},
+	"floz": {"volume", 29.5735295625},
+}
+
+// Convert expresses n in from as a quantity in to. Units outside the table
+// only convert to themselves.
+func Convert(n float64, from, to string) (float64, bool) {
+	if from == to {
+		return n, true
+	}
+	f, okFrom := units[from]
+	t, okTo := units[to]
+	if !okFrom || !okTo || f.dimension != t.dimension {
+		return 0, false
+	}
+	return n * f.factor / t.factor, true
+}
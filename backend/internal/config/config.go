@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"github.com/joho/godotenv"
 )
 
@@ -11,6 +13,68 @@ type Config struct {
 	Database    string
 	JWTSecret   string
 	FrontendURL string
+	// PublicURL is where clients reach this API, used for links in emails
+	PublicURL string
+
+	// Image uploads
+	StorageDriver string
+	UploadDir     string
+	MaxUploadSize int64
+	S3Endpoint    string
+	S3Region      string
+	S3Bucket      string
+	S3AccessKey   string
+	S3SecretKey   string
+
+	// Soft-deleted products are purged after this many days
+	ProductPurgeDays int
+
+	// Outgoing mail
+	MailDriver   string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Inventory alerts go to AlertEmails, or to all admins when empty.
+	// LowStockThreshold applies to products without their own threshold.
+	AlertEmails       []string
+	LowStockThreshold int
+
+	// Product feeds and sitemap
+	FeedTitle    string
+	FeedCurrency string
+
+	// Guest carts expire after GuestCartDays without changes.
+	// CartMergeStrategy decides quantities when a guest cart is merged on
+	// login: sum, max, user or guest.
+	GuestCartDays     int
+	CartMergeStrategy string
+
+	// Stock reserved at checkout is released after ReservationMinutes
+	ReservationMinutes int
+
+	// Responses to POSTs with an Idempotency-Key are kept this long
+	IdempotencyKeyHours int
+
+	// Carts idle for AbandonedCartHours are reminded of by email, with a
+	// coupon for AbandonedCartCouponPercent off valid for
+	// AbandonedCartCouponDays when the percentage is set. 0 hours turns
+	// reminders off.
+	AbandonedCartHours         int
+	AbandonedCartCouponPercent float64
+	AbandonedCartCouponDays    int
+
+	// Cart and order pricing. TaxRate is a percentage of the discounted
+	// subtotal; ShippingRate is waived from FreeShippingOver when set.
+	TaxRate          float64
+	ShippingRate     float64
+	FreeShippingOver float64
+
+	// CronSecret authorizes an external scheduler to run the background
+	// jobs through /api/jobs/run, which is off while it is empty
+	CronSecret string
 }
 
 func Load() *Config {
@@ -23,6 +87,48 @@ func Load() *Config {
 		Database:    getEnv("MONGO_DB", "ecom"),
 		JWTSecret:   getEnv("JWT_SECRET", ""),
 		FrontendURL: getEnv("FRONTEND_URL", ""),
+		PublicURL:   getEnv("PUBLIC_URL", "http://localhost:8080"),
+
+		StorageDriver: getEnv("STORAGE_DRIVER", "local"),
+		UploadDir:     getEnv("UPLOAD_DIR", "uploads"),
+		MaxUploadSize: int64(getEnvInt("MAX_UPLOAD_SIZE", 5<<20)),
+		S3Endpoint:    getEnv("S3_ENDPOINT", ""),
+		S3Region:      getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:      getEnv("S3_BUCKET", ""),
+		S3AccessKey:   getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:   getEnv("S3_SECRET_KEY", ""),
+
+		ProductPurgeDays: getEnvInt("PRODUCT_PURGE_DAYS", 30),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "shop@localhost"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		AlertEmails:       getEnvList("ALERT_EMAILS"),
+		LowStockThreshold: getEnvInt("LOW_STOCK_THRESHOLD", 5),
+
+		FeedTitle:    getEnv("FEED_TITLE", "Shop"),
+		FeedCurrency: getEnv("FEED_CURRENCY", "USD"),
+
+		GuestCartDays:     getEnvInt("GUEST_CART_DAYS", 30),
+		CartMergeStrategy: getEnv("CART_MERGE_STRATEGY", "sum"),
+
+		ReservationMinutes: getEnvInt("RESERVATION_MINUTES", 15),
+
+		IdempotencyKeyHours: getEnvInt("IDEMPOTENCY_KEY_HOURS", 24),
+
+		AbandonedCartHours:         getEnvInt("ABANDONED_CART_HOURS", 24),
+		AbandonedCartCouponPercent: getEnvFloat("ABANDONED_CART_COUPON_PERCENT", 0),
+		AbandonedCartCouponDays:    getEnvInt("ABANDONED_CART_COUPON_DAYS", 7),
+
+		TaxRate:          getEnvFloat("TAX_RATE", 0),
+		ShippingRate:     getEnvFloat("SHIPPING_RATE", 0),
+		FreeShippingOver: getEnvFloat("FREE_SHIPPING_OVER", 0),
+
+		CronSecret: getEnv("CRON_SECRET", ""),
 	}
 }
 
@@ -37,3 +143,31 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the handlers rely on. CreateMany is a
+// no-op for indexes that already exist, so this is safe to run on every start.
+func EnsureIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		// Every sortable field gets an index on it plus the _id tie-breaker so
+		// the order comes from the index instead of an in-memory sort; the
+		// two-key sorts are added from sortPairs below. Filtered lists only
+		// avoid examining unrelated documents where a compound index leads
+		// with the filter, as the category ones do.
+		"products": {
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("updatedAt", -1, "_id", -1)},
+			{Keys: keys("title", 1, "_id", 1)},
+			{Keys: keys("effectivePrice", 1, "_id", 1)},
+			{Keys: keys("rating.average", -1, "_id", -1)},
+			{Keys: keys("stock", 1, "_id", 1)},
+			{Keys: keys("reserved", -1, "_id", -1)},
+			{Keys: keys("category", 1, "createdAt", -1, "_id", -1)},
+			{Keys: keys("category", 1, "title", 1, "_id", 1)},
+			{Keys: keys("category", 1, "effectivePrice", 1, "_id", 1)},
+			{Keys: keys("category", 1, "rating.average", -1, "_id", -1)},
+			{Keys: keys("sale", 1), Options: options.Index().SetSparse(true)},
+			{
+				Keys:    keys("slug", 1),
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+			},
+			// Attribute filters vary by category, so one wildcard index covers them all
+			{Keys: keys("attributes.$**", 1)},
+		},
+		"categories": {
+			{
+				Keys:    keys("name", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    keys("slug", 1),
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		"slug_redirects": {
+			{
+				Keys:    keys("kind", 1, "slug", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: keys("kind", 1, "targetId", 1)},
+		},
+		"product_associations": {
+			// $merge in the recommendations job matches on this pair
+			{
+				Keys:    keys("productId", 1, "relatedId", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: keys("productId", 1, "count", -1)},
+			{Keys: keys("computedAt", 1)},
+		},
+		"category_schemas": {
+			{
+				Keys:    keys("category", 1),
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		"price_history": {
+			{Keys: keys("productId", 1, "changedAt", -1)},
+		},
+		"price_schedules": {
+			{Keys: keys("status", 1, "effectiveAt", 1)},
+			{Keys: keys("productId", 1, "effectiveAt", 1)},
+		},
+		"orders": {
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("updatedAt", -1, "_id", -1)},
+			{Keys: keys("total", -1, "_id", -1)},
+			{Keys: keys("status", 1, "_id", 1)},
+			{Keys: keys("userId", 1, "createdAt", -1, "_id", -1)},
+			{Keys: keys("assignedTo", 1, "createdAt", -1, "_id", -1)},
+		},
+		"users": {
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("email", 1, "_id", 1)},
+			{Keys: keys("role", 1, "_id", 1)},
+		},
+		"product_images": {
+			{Keys: keys("productId", 1)},
+		},
+		"reviews": {
+			{
+				Keys:    keys("productId", 1, "userId", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: keys("productId", 1, "status", 1, "createdAt", -1, "_id", -1)},
+			{Keys: keys("productId", 1, "status", 1, "helpfulCount", -1, "_id", -1)},
+			{Keys: keys("productId", 1, "status", 1, "rating", -1, "_id", -1)},
+			{Keys: keys("status", 1, "createdAt", -1, "_id", -1)},
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("helpfulCount", -1, "_id", -1)},
+			{Keys: keys("rating", -1, "_id", -1)},
+		},
+		"restock_subscriptions": {
+			{
+				Keys:    keys("productId", 1, "userId", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    keys("token", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: keys("productId", 1, "notifiedAt", 1, "createdAt", 1)},
+			{Keys: keys("notifiedAt", 1, "productId", 1)},
+			{Keys: keys("claim", 1), Options: options.Index().SetSparse(true)},
+		},
+		// One cart per user; cart writes upsert on userId and rely on this
+		// to turn a racing second insert into a retry
+		"carts": {
+			{
+				Keys:    keys("userId", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			// Abandoned cart reminders scan idle carts
+			{Keys: keys("updatedAt", 1)},
+		},
+		"cart_reminders": {
+			{Keys: keys("sentAt", -1)},
+			{Keys: keys("recoveredAt", 1, "sentAt", -1)},
+		},
+		"guest_carts": {
+			{
+				Keys:    keys("expiresAt", 1),
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		// Expired keys are removed by the TTL monitor; the middleware also
+		// ignores them until then
+		"idempotency_keys": {
+			{
+				Keys:    keys("scope", 1, "key", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    keys("expiresAt", 1),
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		// At most one active reservation per customer
+		"stock_reservations": {
+			{
+				Keys:    keys("userId", 1),
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "active"}),
+			},
+			{Keys: keys("status", 1, "expiresAt", 1)},
+		},
+		"promotions": {
+			{
+				Keys:    keys("code", 1),
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"code": bson.M{"$type": "string"}}),
+			},
+			{Keys: keys("active", 1, "code", 1)},
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("name", 1, "_id", 1)},
+		},
+		// Redemption enforces per-customer limits by upserting on this key
+		"promotion_usage": {
+			{
+				Keys:    keys("promotionId", 1, "userId", 1),
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		"promotion_redemptions": {
+			{Keys: keys("promotionId", 1, "createdAt", -1)},
+			{Keys: keys("orderId", 1)},
+		},
+		"wishlists": {
+			{
+				Keys:    keys("userId", 1, "name", 1),
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    keys("shareToken", 1),
+				Options: options.Index().SetUnique(true).SetSparse(true),
+			},
+		},
+		"stock_alerts": {
+			// One open alert per product and kind
+			{
+				Keys:    keys("productId", 1, "kind", 1),
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true}),
+			},
+			{Keys: keys("createdAt", -1, "_id", -1)},
+			{Keys: keys("open", 1, "createdAt", -1, "_id", -1)},
+		},
+	}
+
+	for _, pair := range sortPairs {
+		indexes[pair.collection] = append(indexes[pair.collection], pair.indexes()...)
+	}
+
+	// Older databases may hold several carts for a user, which would fail
+	// the unique index below
+	if removed, err := mergeDuplicateCarts(); err != nil {
+		return err
+	} else if removed > 0 {
+		log.Printf("Merged %d duplicate carts", removed)
+	}
+
+	for collection, models := range indexes {
+		if _, err := Database.Collection(collection).Indexes().CreateMany(Ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortPair is a two-key sort a list endpoint allows (pagination.Key.Then),
+// optionally behind the equality filter the list applies first
+type sortPair struct {
+	collection    string
+	prefix        []string
+	first, second string
+}
+
+// sortPairs must list every pair the handlers' Sortables allow
+var sortPairs = []sortPair{
+	{collection: "products", first: "title", second: "effectivePrice"},
+	{collection: "products", first: "effectivePrice", second: "rating.average"},
+	{collection: "products", first: "effectivePrice", second: "title"},
+	{collection: "products", first: "rating.average", second: "effectivePrice"},
+	{collection: "products", first: "rating.average", second: "createdAt"},
+	{collection: "products", prefix: []string{"category"}, first: "title", second: "effectivePrice"},
+	{collection: "products", prefix: []string{"category"}, first: "effectivePrice", second: "rating.average"},
+	{collection: "products", prefix: []string{"category"}, first: "effectivePrice", second: "title"},
+	{collection: "products", prefix: []string{"category"}, first: "rating.average", second: "effectivePrice"},
+	{collection: "products", prefix: []string{"category"}, first: "rating.average", second: "createdAt"},
+	{collection: "products", first: "stock", second: "title"},
+	{collection: "products", first: "reserved", second: "stock"},
+	{collection: "products", first: "reserved", second: "title"},
+	{collection: "orders", first: "total", second: "createdAt"},
+	{collection: "orders", first: "status", second: "createdAt"},
+	{collection: "orders", first: "status", second: "total"},
+	{collection: "users", first: "role", second: "createdAt"},
+	{collection: "users", first: "role", second: "email"},
+	{collection: "reviews", first: "helpfulCount", second: "createdAt"},
+	{collection: "reviews", first: "rating", second: "createdAt"},
+	{collection: "reviews", first: "rating", second: "helpfulCount"},
+	{collection: "reviews", prefix: []string{"productId", "status"}, first: "helpfulCount", second: "createdAt"},
+	{collection: "reviews", prefix: []string{"productId", "status"}, first: "rating", second: "createdAt"},
+	{collection: "reviews", prefix: []string{"productId", "status"}, first: "rating", second: "helpfulCount"},
+}
+
+// indexes returns one index for the keys in the same direction and one for
+// opposite directions. Each also serves its fully reversed order, and the
+// _id tie-breaker follows the second key, so the two cover all four sorts.
+func (p sortPair) indexes() []mongo.IndexModel {
+	models := make([]mongo.IndexModel, 0, 2)
+	for _, direction := range []int{1, -1} {
+		d := bson.D{}
+		for _, field := range p.prefix {
+			d = append(d, bson.E{Key: field, Value: 1})
+		}
+		d = append(d,
+			bson.E{Key: p.first, Value: 1},
+			bson.E{Key: p.second, Value: direction},
+			bson.E{Key: "_id", Value: direction},
+		)
+		models = append(models, mongo.IndexModel{Keys: d})
+	}
+	return models
+}
+
+// keys builds an index key document from alternating field names and directions
+func keys(pairs ...interface{}) bson.D {
+	d := bson.D{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		d = append(d, bson.E{Key: pairs[i].(string), Value: pairs[i+1]})
+	}
+	return d
+}
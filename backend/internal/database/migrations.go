@@ -0,0 +1,110 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/models"
+)
+
+// mergeDuplicateCarts leaves each user with one cart, so the unique userId
+// index can be built on databases from before it existed, when racing
+// writes could create a second cart. The most recently updated cart is
+// kept; lines and coupons only the others have are added to it, and a
+// product in both keeps the kept cart's line. It returns the number of
+// carts removed.
+func mergeDuplicateCarts() (int, error) {
+	carts := Database.Collection("carts")
+	cursor, err := carts.Aggregate(Ctx, bson.A{
+		bson.M{"$match": bson.M{"userId": bson.M{"$exists": true}}},
+		bson.M{"$group": bson.M{"_id": "$userId", "count": bson.M{"$sum": 1}}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var users []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(Ctx, &users); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, user := range users {
+		cursor, err := carts.Find(Ctx, bson.M{"userId": user.UserID},
+			options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}),
+		)
+		if err != nil {
+			return removed, err
+		}
+		var found []models.Cart
+		if err := cursor.All(Ctx, &found); err != nil {
+			return removed, err
+		}
+		if len(found) < 2 {
+			continue
+		}
+
+		kept := found[0]
+		var others []primitive.ObjectID
+		for _, cart := range found[1:] {
+			kept.Items = addMissingLines(kept.Items, cart.Items)
+			kept.SavedForLater = addMissingLines(kept.SavedForLater, cart.SavedForLater)
+			for _, code := range cart.Coupons {
+				if !containsString(kept.Coupons, code) {
+					kept.Coupons = append(kept.Coupons, code)
+				}
+			}
+			others = append(others, cart.ID)
+		}
+		if kept.Items == nil {
+			kept.Items = []models.CartItem{}
+		}
+
+		set := bson.M{"items": kept.Items}
+		if len(kept.SavedForLater) > 0 {
+			set["savedForLater"] = kept.SavedForLater
+		}
+		if len(kept.Coupons) > 0 {
+			set["coupons"] = kept.Coupons
+		}
+		if _, err := carts.UpdateOne(Ctx, bson.M{"_id": kept.ID}, bson.M{"$set": set}); err != nil {
+			return removed, err
+		}
+		result, err := carts.DeleteMany(Ctx, bson.M{"_id": bson.M{"$in": others}})
+		if err != nil {
+			return removed, err
+		}
+		removed += int(result.DeletedCount)
+	}
+	return removed, nil
+}
+
+// addMissingLines appends the lines of extra whose product has no line in
+// lines yet
+func addMissingLines(lines, extra []models.CartItem) []models.CartItem {
+	for _, line := range extra {
+		present := false
+		for _, existing := range lines {
+			if existing.ProductID == line.ProductID {
+				present = true
+				break
+			}
+		}
+		if !present {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
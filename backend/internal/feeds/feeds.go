@@ -0,0 +1,205 @@
+// Package feeds renders products for shopping feeds and sitemaps. Each
+// product renders to a self-contained fragment, so feeds can be kept up to
+// date one product at a time and streamed without building them in memory.
+package feeds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"ecom-backend/internal/models"
+)
+
+// SitemapLimit is the most URLs the sitemap protocol allows in one file
+const SitemapLimit = 50000
+
+// maxAdditionalImages is Google Merchant's limit on extra image links
+const maxAdditionalImages = 10
+
+// Site holds the shop details feeds link back to
+type Site struct {
+	Title    string
+	Currency string
+	// StorefrontURL is where customers browse the shop; APIURL is where
+	// relative image paths are served from
+	StorefrontURL string
+	APIURL        string
+}
+
+// ProductURL is the canonical storefront address of a product, by slug.
+// Products not yet given a slug fall back to their ID.
+func (s Site) ProductURL(product models.Product) string {
+	if product.Slug == "" {
+		return s.StorefrontURL + "/customer/products/" + product.ID.Hex()
+	}
+	return s.StorefrontURL + "/customer/products/" + url.PathEscape(product.Slug)
+}
+
+// CategoryURL is the canonical storefront address of a category, by slug
+func (s Site) CategoryURL(category models.Category) string {
+	if category.Slug == "" {
+		return s.StorefrontURL + "/customer/products?category=" + url.QueryEscape(category.Name)
+	}
+	return s.StorefrontURL + "/customer/categories/" + url.PathEscape(category.Slug)
+}
+
+func (s Site) imageURL(image string) string {
+	if strings.HasPrefix(image, "/") {
+		return s.APIURL + image
+	}
+	return image
+}
+
+func (s Site) price(amount float64) string {
+	return fmt.Sprintf("%.2f %s", amount, s.Currency)
+}
+
+type rssItem struct {
+	XMLName          xml.Name `xml:"item"`
+	ID               string   `xml:"g:id"`
+	Title            string   `xml:"g:title"`
+	Description      string   `xml:"g:description"`
+	Link             string   `xml:"g:link"`
+	ImageLink        string   `xml:"g:image_link,omitempty"`
+	AdditionalImages []string `xml:"g:additional_image_link,omitempty"`
+	Availability     string   `xml:"g:availability"`
+	Price            string   `xml:"g:price"`
+	SalePrice        string   `xml:"g:sale_price,omitempty"`
+	Brand            string   `xml:"g:brand,omitempty"`
+	ProductType      string   `xml:"g:product_type,omitempty"`
+	Condition        string   `xml:"g:condition"`
+}
+
+// RSSItem renders a product as a Google Merchant <item>
+func RSSItem(site Site, product models.Product) (string, error) {
+	item := rssItem{
+		ID:           product.ID.Hex(),
+		Title:        product.Title,
+		Description:  product.Description,
+		Link:         site.ProductURL(product),
+		Availability: availability(product),
+		Price:        site.price(product.Price),
+		Brand:        brand(product),
+		ProductType:  product.Category,
+		Condition:    "new",
+	}
+	if product.EffectivePrice < product.Price {
+		item.SalePrice = site.price(product.EffectivePrice)
+	}
+	for i, image := range product.Images {
+		if i == 0 {
+			item.ImageLink = site.imageURL(image)
+		} else if i <= maxAdditionalImages {
+			item.AdditionalImages = append(item.AdditionalImages, site.imageURL(image))
+		}
+	}
+
+	out, err := xml.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// CSVHeader names the columns of CSVRow
+var CSVHeader = []string{"id", "title", "description", "link", "image_link", "availability", "price", "sale_price", "brand", "product_type"}
+
+// CSVRow renders a product as one row of the CSV feed
+func CSVRow(site Site, product models.Product) []string {
+	image, salePrice := "", ""
+	if len(product.Images) > 0 {
+		image = site.imageURL(product.Images[0])
+	}
+	if product.EffectivePrice < product.Price {
+		salePrice = site.price(product.EffectivePrice)
+	}
+	return []string{
+		product.ID.Hex(),
+		product.Title,
+		product.Description,
+		site.ProductURL(product),
+		image,
+		availability(product),
+		site.price(product.Price),
+		salePrice,
+		brand(product),
+		product.Category,
+	}
+}
+
+// SitemapURL renders one <url> of a sitemap
+func SitemapURL(loc string, lastMod time.Time) string {
+	var b strings.Builder
+	b.WriteString("<url><loc>")
+	xml.EscapeText(&b, []byte(loc))
+	b.WriteString("</loc>")
+	if !lastMod.IsZero() {
+		b.WriteString("<lastmod>" + lastMod.UTC().Format("2006-01-02") + "</lastmod>")
+	}
+	b.WriteString("</url>")
+	return b.String()
+}
+
+func WriteRSSHeader(w io.Writer, site Site) error {
+	_, err := fmt.Fprintf(w, "%s<rss xmlns:g=\"http://base.google.com/ns/1.0\" version=\"2.0\"><channel><title>%s</title><link>%s</link><description>%s</description>\n",
+		xml.Header, escape(site.Title), escape(site.StorefrontURL), escape(site.Title+" products"))
+	return err
+}
+
+func WriteRSSFooter(w io.Writer) error {
+	_, err := io.WriteString(w, "</channel></rss>\n")
+	return err
+}
+
+func WriteURLSetHeader(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header+`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+"\n")
+	return err
+}
+
+func WriteURLSetFooter(w io.Writer) error {
+	_, err := io.WriteString(w, "</urlset>\n")
+	return err
+}
+
+// WriteSitemapIndex lists the sitemap files that together cover the shop
+func WriteSitemapIndex(w io.Writer, locs []string, lastMod time.Time) error {
+	if _, err := io.WriteString(w, xml.Header+`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+"\n"); err != nil {
+		return err
+	}
+	for _, loc := range locs {
+		entry := "<sitemap><loc>" + escape(loc) + "</loc>"
+		if !lastMod.IsZero() {
+			entry += "<lastmod>" + lastMod.UTC().Format(time.RFC3339) + "</lastmod>"
+		}
+		if _, err := io.WriteString(w, entry+"</sitemap>\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</sitemapindex>\n")
+	return err
+}
+
+func availability(product models.Product) string {
+	if product.Stock > 0 {
+		return "in_stock"
+	}
+	return "out_of_stock"
+}
+
+// brand comes from the product's brand attribute when its category has one
+func brand(product models.Product) string {
+	if b, ok := product.Attributes["brand"].(string); ok {
+		return b
+	}
+	return ""
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
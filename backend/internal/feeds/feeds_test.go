@@ -0,0 +1,27 @@
+package feeds
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/models"
+)
+
+// Feeds and sitemaps link to the slug URLs search engines should index
+func TestCanonicalURLs(t *testing.T) {
+	site := Site{StorefrontURL: "https://shop.example"}
+	id := primitive.NewObjectID()
+
+	cases := []struct{ got, want string }{
+		{site.ProductURL(models.Product{ID: id, Slug: "blue-kettle"}), "https://shop.example/customer/products/blue-kettle"},
+		{site.ProductURL(models.Product{ID: id}), "https://shop.example/customer/products/" + id.Hex()},
+		{site.CategoryURL(models.Category{Name: "Home & Garden", Slug: "home-garden"}), "https://shop.example/customer/categories/home-garden"},
+		{site.CategoryURL(models.Category{Name: "Home & Garden"}), "https://shop.example/customer/products?category=Home+%26+Garden"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %s, want %s", c.got, c.want)
+		}
+	}
+}
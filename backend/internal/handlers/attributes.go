@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/attributes"
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+type CategorySchemasHandler struct {
+	collection *mongo.Collection
+}
+
+func NewCategorySchemasHandler() *CategorySchemasHandler {
+	return &CategorySchemasHandler{
+		collection: database.Database.Collection("category_schemas"),
+	}
+}
+
+// Definitions returns the attribute definitions of a category, or nil when
+// the category has no schema
+func (h *CategorySchemasHandler) Definitions(category string) ([]models.AttributeDefinition, error) {
+	var schema models.CategorySchema
+	err := h.collection.FindOne(database.Ctx, bson.M{"category": category}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schema.Attributes, nil
+}
+
+// validateProductAttributes checks product attribute values against the
+// category's schema. On failure it returns the status and body to respond with.
+func (h *CategorySchemasHandler) validateProductAttributes(category string, values map[string]interface{}) (map[string]interface{}, int, fiber.Map) {
+	defs, err := h.Definitions(category)
+	if err != nil {
+		return nil, 500, fiber.Map{"error": "Failed to fetch category schema"}
+	}
+	if defs == nil && len(values) > 0 {
+		return nil, 400, fiber.Map{"error": "Category has no attribute schema"}
+	}
+
+	normalised, err := attributes.Validate(defs, values)
+	if err != nil {
+		return nil, 400, fiber.Map{"error": "Invalid product attributes", "attributes": err}
+	}
+	return normalised, 0, nil
+}
+
+func (h *CategorySchemasHandler) GetSchemas(c *fiber.Ctx) error {
+	cursor, err := h.collection.Find(database.Ctx, bson.M{}, options.Find().SetSort(bson.M{"category": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category schemas"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	schemas := []models.CategorySchema{}
+	if err = cursor.All(database.Ctx, &schemas); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode category schemas"})
+	}
+
+	return c.JSON(fiber.Map{"schemas": schemas})
+}
+
+func (h *CategorySchemasHandler) GetSchema(c *fiber.Ctx) error {
+	category, err := categoryParam(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category"})
+	}
+
+	var schema models.CategorySchema
+	err = h.collection.FindOne(database.Ctx, bson.M{"category": category}).Decode(&schema)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Category schema not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category schema"})
+	}
+
+	return c.JSON(schema)
+}
+
+// PutSchema creates or replaces a category's attribute definitions.
+// Existing products are not revalidated; they are checked against the new
+// schema the next time their attributes or category change.
+func (h *CategorySchemasHandler) PutSchema(c *fiber.Ctx) error {
+	category, err := categoryParam(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category"})
+	}
+
+	var req models.CategorySchemaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Attributes == nil {
+		req.Attributes = []models.AttributeDefinition{}
+	}
+	if err := attributes.ValidateSchema(req.Attributes); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid attribute definitions", "attributes": err})
+	}
+
+	var schema models.CategorySchema
+	err = h.collection.FindOneAndUpdate(database.Ctx,
+		bson.M{"category": category},
+		bson.M{"$set": bson.M{"attributes": req.Attributes, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&schema)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save category schema"})
+	}
+
+	return c.JSON(schema)
+}
+
+func (h *CategorySchemasHandler) DeleteSchema(c *fiber.Ctx) error {
+	category, err := categoryParam(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category"})
+	}
+
+	result, err := h.collection.DeleteOne(database.Ctx, bson.M{"category": category})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete category schema"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Category schema not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Category schema deleted"})
+}
+
+// categoryParam decodes the :category route parameter, since category names
+// may contain spaces and other characters clients percent-encode
+func categoryParam(c *fiber.Ctx) (string, error) {
+	category, err := url.PathUnescape(c.Params("category"))
+	if err != nil {
+		return "", err
+	}
+	category = strings.TrimSpace(category)
+	if category == "" {
+		return "", url.EscapeError("")
+	}
+	return category, nil
+}
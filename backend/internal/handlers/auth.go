@@ -13,18 +13,21 @@ import (
 
 	"ecom-backend/internal/models"
 	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
 	"ecom-backend/internal/middleware"
 )
 
 type AuthHandler struct {
 	collection *mongo.Collection
 	jwtSecret  string
+	cart       *CartHandler
 }
 
-func NewAuthHandler(jwtSecret string) *AuthHandler {
+func NewAuthHandler(jwtSecret string, cart *CartHandler) *AuthHandler {
 	return &AuthHandler{
 		collection: database.Database.Collection("users"),
 		jwtSecret:  jwtSecret,
+		cart:       cart,
 	}
 }
 
@@ -74,6 +77,9 @@ func (h *AuthHandler) Signup(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate refresh token"})
 	}
 
+	// Carry over the cart the user built as a guest
+	h.cart.mergeGuestCartFrom(c, user.ID)
+
 	// Remove password from response
 	user.Password = ""
 
@@ -129,6 +135,9 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate refresh token"})
 	}
 
+	// Carry over the cart the user built as a guest
+	h.cart.mergeGuestCartFrom(c, user.ID)
+
 	// Remove password from response
 	user.Password = ""
 
@@ -213,6 +222,7 @@ func (h *AuthHandler) GetProfile(c *fiber.Ctx) error {
 	// Remove password from response
 	user.Password = ""
 
+	c.Set(fiber.HeaderETag, httpcache.VersionETag(user.Version))
 	return c.JSON(user)
 }
 
@@ -236,10 +246,21 @@ func (h *AuthHandler) UpdateProfile(c *fiber.Ctx) error {
 		update["address"] = req.Address
 	}
 
-	_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.collection.UpdateOne(database.Ctx, matchVersion(bson.M{"_id": objectID}, expected), bson.M{
+		"$set": update,
+		"$inc": bson.M{"version": 1},
+	})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update profile"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, h.collection, objectID, &models.User{}, "User")
+	}
 
 	// Return updated user
 	var user models.User
@@ -289,6 +310,7 @@ func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
 			"password":  string(hashedPassword),
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
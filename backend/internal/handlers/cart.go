@@ -1,164 +1,327 @@
 package handlers
 
 import (
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"ecom-backend/internal/models"
 	"ecom-backend/internal/database"
+	"ecom-backend/internal/pricing"
 )
 
 type CartHandler struct {
 	cartCollection    *mongo.Collection
+	guestCollection   *mongo.Collection
 	productCollection *mongo.Collection
+	tokenSecret       []byte
+	guestTTL          time.Duration
+	mergeStrategy     string
+	pricing           *pricing.Engine
+	promotions        *PromotionsHandler
 }
 
-func NewCartHandler() *CartHandler {
+// NewCartHandler signs guest cart tokens with tokenSecret. Guest carts
+// expire guestTTL after their last change; mergeStrategy is one of the
+// CartMerge constants. Carts are priced by engine with the promotions
+// they qualify for, the same way orders are.
+func NewCartHandler(tokenSecret string, guestTTL time.Duration, mergeStrategy string, engine *pricing.Engine, promotions *PromotionsHandler) *CartHandler {
+	switch mergeStrategy {
+	case CartMergeSum, CartMergeMax, CartMergeUser, CartMergeGuest:
+	default:
+		log.Printf("Unknown cart merge strategy %q, using %q", mergeStrategy, CartMergeSum)
+		mergeStrategy = CartMergeSum
+	}
+
 	return &CartHandler{
 		cartCollection:    database.Database.Collection("carts"),
+		guestCollection:   database.Database.Collection("guest_carts"),
 		productCollection: database.Database.Collection("products"),
+		tokenSecret:       []byte(tokenSecret),
+		guestTTL:          guestTTL,
+		mergeStrategy:     mergeStrategy,
+		pricing:           engine,
+		promotions:        promotions,
 	}
 }
 
+// GetCart works for signed-in users and for guests sending their cart
+// token; a guest without one has an empty cart. Lines whose price changed
+// since the customer added them, or that no longer fit the stock, are
+// flagged and listed under warnings.
 func (h *CartHandler) GetCart(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
 	}
 
+	// A cart without a document is simply empty; it is created by the
+	// first item added
 	var cart models.Cart
-	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": objectID}).Decode(&cart)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Create empty cart
-			cart = models.Cart{
-				ID:        primitive.NewObjectID(),
-				UserID:    objectID,
-				Items:     []models.CartItem{},
-				UpdatedAt: time.Now(),
-			}
-			_, err = h.cartCollection.InsertOne(database.Ctx, cart)
-			if err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to create cart"})
-			}
-		} else {
+	if ok {
+		err := ref.collection.FindOne(database.Ctx, ref.filter).Decode(&cart)
+		if err != nil && err != mongo.ErrNoDocuments {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
 		}
 	}
 
 	// Populate product details
+	now := time.Now()
 	var cartItems []fiber.Map
+	var priced []pricing.Item
+	warnings := []models.CheckoutProblem{}
+	itemCount := 0
 	for _, item := range cart.Items {
+		filter := visibleProductFilter()
+		filter["_id"] = item.ProductID
+
 		var product models.Product
-		err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": item.ProductID}).Decode(&product)
+		err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
 		if err != nil {
-			continue // Skip invalid products
+			continue // Skip invalid or withdrawn products
+		}
+
+		// Lines added before prices were recorded have nothing to compare
+		currentPrice := product.PriceAt(now)
+		priceChanged := item.UnitPrice > 0 && pricing.Round(item.UnitPrice) != pricing.Round(currentPrice)
+		if priceChanged {
+			warnings = append(warnings, models.CheckoutProblem{
+				ProductID: item.ProductID,
+				Code:      models.ProblemPriceChanged,
+				Message:   fmt.Sprintf("The price of %s changed from %.2f to %.2f", product.Title, item.UnitPrice, currentPrice),
+				Expected:  item.UnitPrice,
+				Current:   currentPrice,
+			})
+		}
+		stockChanged := product.Stock < item.Quantity
+		if stockChanged {
+			available := max(product.Stock, 0)
+			warning := models.CheckoutProblem{
+				ProductID: item.ProductID,
+				Code:      models.ProblemInsufficientStock,
+				Message:   fmt.Sprintf("Only %d of %s left in stock", available, product.Title),
+				Quantity:  item.Quantity,
+				Available: &available,
+			}
+			if available == 0 {
+				warning.Code = models.ProblemOutOfStock
+				warning.Message = product.Title + " is out of stock"
+			}
+			warnings = append(warnings, warning)
 		}
 
 		cartItems = append(cartItems, fiber.Map{
+			"productId":    item.ProductID,
+			"quantity":     item.Quantity,
+			"unitPrice":    item.UnitPrice,
+			"currentPrice": currentPrice,
+			"priceChanged": priceChanged,
+			"stockChanged": stockChanged,
+			"note":         item.Note,
+			"gift":         item.Gift,
+			"product":      product,
+		})
+		priced = append(priced, pricing.Item{Product: product, Quantity: item.Quantity})
+		itemCount += item.Quantity
+	}
+
+	savedItems := []fiber.Map{}
+	for _, item := range cart.SavedForLater {
+		filter := visibleProductFilter()
+		filter["_id"] = item.ProductID
+
+		var product models.Product
+		if err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product); err != nil {
+			continue
+		}
+
+		savedItems = append(savedItems, fiber.Map{
 			"productId": item.ProductID,
 			"quantity":  item.Quantity,
+			"note":      item.Note,
+			"gift":      item.Gift,
 			"product":   product,
 		})
 	}
 
+	promotions, err := h.promotions.Applicable(cart.Coupons, currentUserID(c), now)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+
+	totals := h.pricing.Price(priced, promotions, now)
 	return c.JSON(fiber.Map{
-		"items": cartItems,
-		"total": len(cartItems),
+		"items":         cartItems,
+		"itemCount":     itemCount,
+		"warnings":      warnings,
+		"savedForLater": savedItems,
+		"coupons":       cart.Coupons,
+		"totals":        totals,
+		"total":         totals.Total,
 	})
 }
 
+// AddToCart starts a guest cart when a guest without a cart token adds
+// an item, returning the new token in the X-Cart-Token header
 func (h *CartHandler) AddToCart(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
-	}
-
 	var req models.AddToCartRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	ref, _, cerr := h.resolveCart(c, true)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+
+	if cerr := h.addItem(ref, req.ProductID, req.Quantity); cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+
+	return c.JSON(fiber.Map{"message": "Item added to cart"})
+}
+
+// cartError is a failed cart change, carrying the response to send
+type cartError struct {
+	status  int
+	message string
+}
+
+// maxCartAttempts bounds the retries of addItem when concurrent requests
+// keep changing the same cart line
+const maxCartAttempts = 3
+
+// addItem adds quantity of a product to a cart after checking the
+// product is in the catalog and in stock. Wishlists move items to the cart
+// through it too.
+//
+// Every write is a single atomic update, so concurrent requests for the
+// same cart cannot overwrite each other: an existing line is incremented in
+// place, and a new line is pushed by an upsert that also creates the cart.
+func (h *CartHandler) addItem(ref cartRef, productID primitive.ObjectID, quantity int) *cartError {
+	if quantity <= 0 {
+		return &cartError{400, "Quantity must be positive"}
+	}
+
 	// Check if product exists and has stock
+	filter := visibleProductFilter()
+	filter["_id"] = productID
+
 	var product models.Product
-	err = h.productCollection.FindOne(database.Ctx, bson.M{"_id": req.ProductID}).Decode(&product)
+	err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+			return &cartError{404, "Product not found"}
 		}
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+		return &cartError{500, "Failed to fetch product"}
 	}
 
-	if product.Stock < req.Quantity {
-		return c.Status(400).JSON(fiber.Map{"error": "Insufficient stock"})
+	if product.Stock < quantity {
+		return &cartError{400, "Insufficient stock"}
 	}
 
-	// Get or create cart
-	var cart models.Cart
-	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": objectID}).Decode(&cart)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Create new cart
-			cart = models.Cart{
-				ID:        primitive.NewObjectID(),
-				UserID:    objectID,
-				Items:     []models.CartItem{},
-				UpdatedAt: time.Now(),
-			}
-		} else {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		// Increment the line if the product is already in the cart and the
+		// new quantity still fits the stock
+		result, err := ref.collection.UpdateOne(database.Ctx,
+			ref.where(bson.M{"items": bson.M{"$elemMatch": bson.M{
+				"productId": productID,
+				"quantity":  bson.M{"$lte": product.Stock - quantity},
+			}}}),
+			bson.M{
+				"$inc": bson.M{"items.$.quantity": quantity},
+				"$set": h.touched(ref, nil),
+			},
+		)
+		if err != nil {
+			return &cartError{500, "Failed to update cart"}
+		}
+		if result.MatchedCount > 0 {
+			return nil
 		}
-	}
 
-	// Check if item already exists in cart
-	itemIndex := -1
-	for i, item := range cart.Items {
-		if item.ProductID == req.ProductID {
-			itemIndex = i
-			break
+		// Otherwise add the line, creating the cart if needed
+		err = h.pushItem(ref, product, quantity)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return &cartError{500, "Failed to update cart"}
 		}
-	}
 
-	if itemIndex >= 0 {
-		// Update quantity
-		cart.Items[itemIndex].Quantity += req.Quantity
-	} else {
-		// Add new item
-		cart.Items = append(cart.Items, models.CartItem{
-			ProductID: req.ProductID,
-			Quantity:  req.Quantity,
-		})
+		// The upsert collided with the existing cart, so the cart already
+		// holds this product: either the increment above would exceed the
+		// stock, or another request added the line in between
+		count, err := ref.collection.CountDocuments(database.Ctx, ref.where(bson.M{
+			"items": bson.M{"$elemMatch": bson.M{
+				"productId": productID,
+				"quantity":  bson.M{"$gt": product.Stock - quantity},
+			}},
+		}))
+		if err != nil {
+			return &cartError{500, "Failed to update cart"}
+		}
+		if count > 0 {
+			return &cartError{400, "Insufficient stock"}
+		}
 	}
 
-	cart.UpdatedAt = time.Now()
+	return &cartError{409, "Cart changed while updating, please retry"}
+}
 
-	// Save cart
-	if cart.ID.IsZero() {
-		_, err = h.cartCollection.InsertOne(database.Ctx, cart)
-	} else {
-		_, err = h.cartCollection.ReplaceOne(database.Ctx, bson.M{"_id": cart.ID}, cart)
-	}
+// pushItem adds a line for a product the cart does not hold yet, creating
+// the cart if needed, and records the price the customer sees now. It
+// fails with a duplicate key error when the cart exists and already holds
+// the product.
+func (h *CartHandler) pushItem(ref cartRef, product models.Product, quantity int) error {
+	_, err := ref.collection.UpdateOne(database.Ctx,
+		ref.where(bson.M{"items.productId": bson.M{"$ne": product.ID}}),
+		bson.M{
+			"$push": bson.M{"items": models.CartItem{
+				ProductID: product.ID,
+				Quantity:  quantity,
+				UnitPrice: product.PriceAt(time.Now()),
+			}},
+			"$set":  h.touched(ref, nil),
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
 
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
-	}
+// setItem sets a line's quantity, adding the line if the cart lacks it
+func (h *CartHandler) setItem(ref cartRef, product models.Product, quantity int) *cartError {
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		result, err := ref.collection.UpdateOne(database.Ctx,
+			ref.where(bson.M{"items.productId": product.ID}),
+			bson.M{"$set": h.touched(ref, bson.M{"items.$.quantity": quantity})},
+		)
+		if err != nil {
+			return &cartError{500, "Failed to update cart"}
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
 
-	return c.JSON(fiber.Map{"message": "Item added to cart"})
+		err = h.pushItem(ref, product, quantity)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return &cartError{500, "Failed to update cart"}
+		}
+		// Another request added the line in between; set it on the next pass
+	}
+	return &cartError{409, "Cart changed while updating, please retry"}
 }
 
 func (h *CartHandler) UpdateCartItem(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
-	}
-
 	productID := c.Params("productId")
 	productObjectID, err := primitive.ObjectIDFromHex(productID)
 	if err != nil {
@@ -169,31 +332,28 @@ func (h *CartHandler) UpdateCartItem(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
-
-	// Get cart
-	var cart models.Cart
-	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": objectID}).Decode(&cart)
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	if req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Quantity must be positive"})
 	}
 
-	// Find and update item
-	itemIndex := -1
-	for i, item := range cart.Items {
-		if item.ProductID == productObjectID {
-			itemIndex = i
-			break
-		}
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
 	}
-
-	if itemIndex == -1 {
+	if !ok {
 		return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
 	}
 
 	// Check stock
+	filter := visibleProductFilter()
+	filter["_id"] = productObjectID
+
 	var product models.Product
-	err = h.productCollection.FindOne(database.Ctx, bson.M{"_id": productObjectID}).Decode(&product)
+	err = h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product is no longer available"})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
 	}
 
@@ -201,67 +361,138 @@ func (h *CartHandler) UpdateCartItem(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Insufficient stock"})
 	}
 
-	cart.Items[itemIndex].Quantity = req.Quantity
-	cart.UpdatedAt = time.Now()
-
-	_, err = h.cartCollection.ReplaceOne(database.Ctx, bson.M{"_id": cart.ID}, cart)
+	// Set the quantity of the matched line in place
+	result, err := ref.collection.UpdateOne(database.Ctx,
+		ref.where(bson.M{"items.productId": productObjectID}),
+		bson.M{"$set": h.touched(ref, bson.M{"items.$.quantity": req.Quantity})},
+	)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
 	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
+	}
 
 	return c.JSON(fiber.Map{"message": "Cart item updated"})
 }
 
 func (h *CartHandler) RemoveFromCart(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
-	}
-
 	productID := c.Params("productId")
 	productObjectID, err := primitive.ObjectIDFromHex(productID)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
 	}
 
-	// Get cart
-	var cart models.Cart
-	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": objectID}).Decode(&cart)
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
 	}
-
-	// Remove item
-	var newItems []models.CartItem
-	for _, item := range cart.Items {
-		if item.ProductID != productObjectID {
-			newItems = append(newItems, item)
-		}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
 	}
 
-	cart.Items = newItems
-	cart.UpdatedAt = time.Now()
-
-	_, err = h.cartCollection.ReplaceOne(database.Ctx, bson.M{"_id": cart.ID}, cart)
+	result, err := ref.collection.UpdateOne(database.Ctx,
+		ref.filter,
+		bson.M{
+			"$pull": bson.M{"items": bson.M{"productId": productObjectID}},
+			"$set":  h.touched(ref, nil),
+		},
+	)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
 	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	}
 
 	return c.JSON(fiber.Map{"message": "Item removed from cart"})
 }
 
 func (h *CartHandler) ClearCart(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.JSON(fiber.Map{"message": "Cart cleared"})
 	}
 
-	_, err = h.cartCollection.DeleteOne(database.Ctx, bson.M{"userId": objectID})
+	// Items saved for later stay
+	_, err := ref.collection.UpdateOne(database.Ctx, ref.filter, bson.M{
+		"$set":   h.touched(ref, bson.M{"items": []models.CartItem{}}),
+		"$unset": bson.M{"coupons": ""},
+	})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to clear cart"})
 	}
 
 	return c.JSON(fiber.Map{"message": "Cart cleared"})
 }
+
+// ApplyCoupon adds a coupon code to the cart. The cart is then priced with
+// the best combination its stacking rules allow.
+func (h *CartHandler) ApplyCoupon(c *fiber.Ctx) error {
+	var req models.ApplyCouponRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	code := normalizeCoupon(req.Code)
+	if code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Coupon code is required"})
+	}
+
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	}
+
+	promotion, cerr := h.promotions.checkCoupon(code, currentUserID(c))
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+
+	result, err := ref.collection.UpdateOne(database.Ctx,
+		ref.filter,
+		bson.M{
+			"$addToSet": bson.M{"coupons": promotion.Code},
+			"$set":      h.touched(ref, nil),
+		},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Coupon applied", "promotion": promotion.Name})
+}
+
+func (h *CartHandler) RemoveCoupon(c *fiber.Ctx) error {
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	}
+
+	result, err := ref.collection.UpdateOne(database.Ctx,
+		ref.where(bson.M{"coupons": normalizeCoupon(c.Params("code"))}),
+		bson.M{
+			"$pull": bson.M{"coupons": normalizeCoupon(c.Params("code"))},
+			"$set":  h.touched(ref, nil),
+		},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Coupon not applied to cart"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Coupon removed"})
+}
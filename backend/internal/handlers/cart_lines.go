@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pricing"
+)
+
+// maxLineNoteLength bounds, in characters, the note a customer can attach
+// to a cart line
+const maxLineNoteLength = 500
+
+// UpdateCartLine sets the note or gift flag of a cart line
+func (h *CartHandler) UpdateCartLine(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var req models.UpdateCartLineRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	if req.Note != nil {
+		note := strings.TrimSpace(*req.Note)
+		if utf8.RuneCountInString(note) > maxLineNoteLength {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Note must be at most %d characters", maxLineNoteLength)})
+		}
+		if note == "" {
+			unset["items.$.note"] = ""
+		} else {
+			set["items.$.note"] = note
+		}
+	}
+	if req.Gift != nil {
+		if *req.Gift {
+			set["items.$.gift"] = true
+		} else {
+			unset["items.$.gift"] = ""
+		}
+	}
+
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
+	}
+
+	update := bson.M{"$set": h.touched(ref, set)}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	result, err := ref.collection.UpdateOne(database.Ctx, ref.where(bson.M{"items.productId": productID}), update)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Cart item updated"})
+}
+
+// SaveForLater moves a line out of the cart into the saved-for-later list,
+// adding to the saved quantity if the product is saved already. The move is
+// one update conditioned on the line as it was read, so a concurrent change
+// to the line makes it retry rather than lose the change.
+func (h *CartHandler) SaveForLater(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
+	}
+
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		var cart models.Cart
+		err := ref.collection.FindOne(database.Ctx, ref.filter).Decode(&cart)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+		}
+		line, found := findLine(cart.Items, productID)
+		if !found {
+			return c.Status(404).JSON(fiber.Map{"error": "Item not found in cart"})
+		}
+		_, saved := findLine(cart.SavedForLater, productID)
+
+		filter := ref.where(bson.M{"items": bson.M{"$elemMatch": bson.M{
+			"productId": productID,
+			"quantity":  line.Quantity,
+		}}})
+		update := bson.M{
+			"$pull": bson.M{"items": bson.M{"productId": productID}},
+			"$set":  h.touched(ref, nil),
+		}
+		opts := options.Update()
+		if saved {
+			filter["savedForLater.productId"] = productID
+			update["$inc"] = bson.M{"savedForLater.$[saved].quantity": line.Quantity}
+			opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"saved.productId": productID}}})
+		} else {
+			filter["savedForLater.productId"] = bson.M{"$ne": productID}
+			update["$push"] = bson.M{"savedForLater": line}
+		}
+
+		result, err := ref.collection.UpdateOne(database.Ctx, filter, update, opts)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+		}
+		if result.MatchedCount > 0 {
+			return c.JSON(fiber.Map{"message": "Item saved for later"})
+		}
+		// The line or the saved list changed since it was read
+	}
+
+	return c.Status(409).JSON(fiber.Map{"error": "Cart changed while updating, please retry"})
+}
+
+// MoveToCart moves a saved line back into the cart, checking the stock
+// like adding it would. A product already in the cart has its quantity
+// increased and keeps its own note and gift flag; otherwise the saved line
+// moves over as it is, at today's price. Like SaveForLater, the move is one
+// update conditioned on both lines as they were read.
+func (h *CartHandler) MoveToCart(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not saved for later"})
+	}
+
+	filter := visibleProductFilter()
+	filter["_id"] = productID
+	var product models.Product
+	err = h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		var cart models.Cart
+		err := ref.collection.FindOne(database.Ctx, ref.filter).Decode(&cart)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+		}
+		line, found := findLine(cart.SavedForLater, productID)
+		if !found {
+			return c.Status(404).JSON(fiber.Map{"error": "Item not saved for later"})
+		}
+		current, inCart := findLine(cart.Items, productID)
+		if current.Quantity+line.Quantity > product.Stock {
+			return c.Status(400).JSON(fiber.Map{"error": "Insufficient stock"})
+		}
+
+		filter := ref.where(bson.M{"savedForLater": bson.M{"$elemMatch": bson.M{
+			"productId": productID,
+			"quantity":  line.Quantity,
+		}}})
+		update := bson.M{
+			"$pull": bson.M{"savedForLater": bson.M{"productId": productID}},
+			"$set":  h.touched(ref, nil),
+		}
+		opts := options.Update()
+		if inCart {
+			filter["items"] = bson.M{"$elemMatch": bson.M{"productId": productID, "quantity": current.Quantity}}
+			update["$inc"] = bson.M{"items.$[line].quantity": line.Quantity}
+			opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"line.productId": productID}}})
+		} else {
+			filter["items.productId"] = bson.M{"$ne": productID}
+			line.UnitPrice = product.PriceAt(time.Now())
+			update["$push"] = bson.M{"items": line}
+		}
+
+		result, err := ref.collection.UpdateOne(database.Ctx, filter, update, opts)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+		}
+		if result.MatchedCount > 0 {
+			return c.JSON(fiber.Map{"message": "Item moved to cart"})
+		}
+		// The line or the saved list changed since it was read
+	}
+
+	return c.Status(409).JSON(fiber.Map{"error": "Cart changed while updating, please retry"})
+}
+
+func (h *CartHandler) RemoveSavedItem(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not saved for later"})
+	}
+
+	result, err := ref.collection.UpdateOne(database.Ctx,
+		ref.where(bson.M{"savedForLater.productId": productID}),
+		bson.M{
+			"$pull": bson.M{"savedForLater": bson.M{"productId": productID}},
+			"$set":  h.touched(ref, nil),
+		},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not saved for later"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Saved item removed"})
+}
+
+// AcceptPrices records the current prices on the cart's lines, clearing
+// their price change warnings. The update is conditioned on the cart as it
+// was read, so a line added meanwhile is not accepted unseen.
+func (h *CartHandler) AcceptPrices(c *fiber.Ctx) error {
+	ref, ok, cerr := h.resolveCart(c, false)
+	if cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+	}
+
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		var cart models.Cart
+		err := ref.collection.FindOne(database.Ctx, ref.filter).Decode(&cart)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+		}
+
+		now := time.Now()
+		set := bson.M{}
+		for i, item := range cart.Items {
+			filter := visibleProductFilter()
+			filter["_id"] = item.ProductID
+			var product models.Product
+			err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+			}
+			if price := product.PriceAt(now); pricing.Round(item.UnitPrice) != pricing.Round(price) {
+				set[fmt.Sprintf("items.%d.unitPrice", i)] = price
+			}
+		}
+		accepted := len(set)
+		if accepted == 0 {
+			return c.JSON(fiber.Map{"message": "Prices are up to date", "accepted": 0})
+		}
+
+		result, err := ref.collection.UpdateOne(database.Ctx,
+			ref.where(bson.M{"updatedAt": cart.UpdatedAt}),
+			bson.M{"$set": h.touched(ref, set)},
+		)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+		}
+		if result.MatchedCount > 0 {
+			return c.JSON(fiber.Map{"message": "Prices accepted", "accepted": accepted})
+		}
+		// The cart changed since it was read
+	}
+
+	return c.Status(409).JSON(fiber.Map{"error": "Cart changed while updating, please retry"})
+}
+
+func findLine(lines []models.CartItem, productID primitive.ObjectID) (models.CartItem, bool) {
+	for _, line := range lines {
+		if line.ProductID == productID {
+			return line, true
+		}
+	}
+	return models.CartItem{}, false
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/mailer"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pricing"
+)
+
+const (
+	// cartReminderBatchSize bounds how many carts one run reminds of
+	cartReminderBatchSize = 100
+	// Carts idle for longer than cartReminderMaxIdle are left alone, so
+	// turning reminders on does not mail every cart ever abandoned
+	cartReminderMaxIdle = 14 * 24 * time.Hour
+	// An order within cartRecoveryWindow of a reminder counts as recovered
+	cartRecoveryWindow = 7 * 24 * time.Hour
+)
+
+// CartCoupon is the coupon offered in cart reminders; a zero Percent
+// offers none
+type CartCoupon struct {
+	Percent float64
+	Valid   time.Duration
+}
+
+type CartRemindersHandler struct {
+	cartCollection     *mongo.Collection
+	reminderCollection *mongo.Collection
+	orderCollection    *mongo.Collection
+	userCollection     *mongo.Collection
+	productCollection  *mongo.Collection
+	mail               mailer.Mailer
+	pricing            *pricing.Engine
+	promotions         *PromotionsHandler
+	storefrontURL      string
+	idle               time.Duration
+	coupon             CartCoupon
+}
+
+// NewCartRemindersHandler reminds customers of carts left unchanged for
+// idle. Links in the reminders point at storefrontURL.
+func NewCartRemindersHandler(mail mailer.Mailer, engine *pricing.Engine, promotions *PromotionsHandler, storefrontURL string, idle time.Duration, coupon CartCoupon) *CartRemindersHandler {
+	return &CartRemindersHandler{
+		cartCollection:     database.Database.Collection("carts"),
+		reminderCollection: database.Database.Collection("cart_reminders"),
+		orderCollection:    database.Database.Collection("orders"),
+		userCollection:     database.Database.Collection("users"),
+		productCollection:  database.Database.Collection("products"),
+		mail:               mail,
+		pricing:            engine,
+		promotions:         promotions,
+		storefrontURL:      strings.TrimRight(storefrontURL, "/"),
+		idle:               idle,
+		coupon:             coupon,
+	}
+}
+
+// SendReminders mails the owners of carts that have been idle past the
+// threshold and were not reminded of since they last changed. Each cart is
+// claimed by marking it reminded before it is mailed, so overlapping runs
+// never mail a cart twice. It returns the number of reminders sent.
+func (h *CartRemindersHandler) SendReminders() (int, error) {
+	now := time.Now()
+	cursor, err := h.cartCollection.Find(database.Ctx,
+		bson.M{
+			"items.0":   bson.M{"$exists": true},
+			"updatedAt": bson.M{"$lte": now.Add(-h.idle), "$gte": now.Add(-cartReminderMaxIdle)},
+			"$or": bson.A{
+				bson.M{"remindedAt": nil},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$remindedAt", "$updatedAt"}}},
+			},
+		},
+		options.Find().SetSort(bson.M{"updatedAt": 1}).SetLimit(cartReminderBatchSize),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var carts []models.Cart
+	if err = cursor.All(database.Ctx, &carts); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, cart := range carts {
+		reminded, err := h.remind(cart)
+		if err != nil {
+			return sent, err
+		}
+		if reminded {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func (h *CartRemindersHandler) remind(cart models.Cart) (bool, error) {
+	// Claim the cart as it was read; a cart changed since is idle no more
+	now := time.Now()
+	result, err := h.cartCollection.UpdateOne(database.Ctx,
+		bson.M{"_id": cart.ID, "updatedAt": cart.UpdatedAt, "remindedAt": cart.RemindedAt},
+		bson.M{"$set": bson.M{"remindedAt": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	// Customers who ordered since the cart last changed bought elsewhere
+	// in the shop; the claim stays so they are not asked again
+	ordered, err := h.orderCollection.CountDocuments(database.Ctx, bson.M{
+		"userId":    cart.UserID,
+		"createdAt": bson.M{"$gt": cart.UpdatedAt},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		h.unclaim(cart)
+		return false, err
+	}
+	if ordered > 0 {
+		return false, nil
+	}
+
+	var user models.User
+	err = h.userCollection.FindOne(database.Ctx, bson.M{"_id": cart.UserID, "isActive": true}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		h.unclaim(cart)
+		return false, err
+	}
+
+	var items []pricing.Item
+	for _, line := range cart.Items {
+		filter := visibleProductFilter()
+		filter["_id"] = line.ProductID
+		var product models.Product
+		if err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product); err != nil {
+			continue // Withdrawn since it was added
+		}
+		items = append(items, pricing.Item{Product: product, Quantity: line.Quantity})
+	}
+	if len(items) == 0 {
+		return false, nil
+	}
+	totals := h.pricing.Price(items, nil, now)
+
+	reminder := models.CartReminder{
+		ID:            primitive.NewObjectID(),
+		UserID:        cart.UserID,
+		Email:         user.Email,
+		CartUpdatedAt: cart.UpdatedAt,
+		Subtotal:      totals.Subtotal,
+		SentAt:        now,
+	}
+	for _, item := range items {
+		reminder.ItemCount += item.Quantity
+	}
+
+	var coupon *models.Promotion
+	if h.coupon.Percent > 0 {
+		coupon, err = h.promotions.issueCoupon("Cart reminder", h.coupon.Percent, now.Add(h.coupon.Valid))
+		if err != nil {
+			h.unclaim(cart)
+			return false, err
+		}
+		reminder.CouponCode = coupon.Code
+		reminder.PromotionID = &coupon.ID
+	}
+
+	if err := h.sendReminderMail(reminder, items, coupon); err != nil {
+		h.unclaim(cart)
+		if coupon != nil {
+			h.promotions.collection.DeleteOne(database.Ctx, bson.M{"_id": coupon.ID})
+		}
+		return false, err
+	}
+
+	if _, err := h.reminderCollection.InsertOne(database.Ctx, reminder); err != nil {
+		// The mail is out; the claim stays so it is not sent twice
+		return true, err
+	}
+	return true, nil
+}
+
+// unclaim lets the next run retry a cart whose reminder was not sent
+func (h *CartRemindersHandler) unclaim(cart models.Cart) {
+	update := bson.M{"$unset": bson.M{"remindedAt": ""}}
+	if cart.RemindedAt != nil {
+		update = bson.M{"$set": bson.M{"remindedAt": *cart.RemindedAt}}
+	}
+	h.cartCollection.UpdateOne(database.Ctx, bson.M{"_id": cart.ID}, update)
+}
+
+func (h *CartRemindersHandler) sendReminderMail(reminder models.CartReminder, items []pricing.Item, coupon *models.Promotion) error {
+	link := h.storefrontURL + "/customer/cart"
+	if coupon != nil {
+		link += "?coupon=" + url.QueryEscape(coupon.Code)
+	}
+
+	var body strings.Builder
+	body.WriteString("You left these in your cart:\n\n")
+	for _, item := range items {
+		fmt.Fprintf(&body, "  %d x %s\n", item.Quantity, item.Product.Title)
+	}
+	fmt.Fprintf(&body, "\nSubtotal: %.2f\n\n", reminder.Subtotal)
+	if coupon != nil {
+		fmt.Fprintf(&body, "Use code %s for %g%% off, valid until %s.\n\n", coupon.Code, coupon.Value, coupon.EndsAt.Format("January 2, 2006"))
+	}
+	fmt.Fprintf(&body, "Pick up where you left off: %s\n", link)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return h.mail.Send(ctx, mailer.Message{
+		To:      []string{reminder.Email},
+		Subject: "You left something in your cart",
+		Body:    body.String(),
+	})
+}
+
+// AttributeRecoveries marks reminders recovered by the customer's first
+// order placed after the reminder, within the attribution window. It
+// returns the number of reminders newly recovered.
+func (h *CartRemindersHandler) AttributeRecoveries() (int, error) {
+	cursor, err := h.reminderCollection.Find(database.Ctx, bson.M{
+		"recoveredAt": nil,
+		"sentAt":      bson.M{"$gte": time.Now().Add(-cartRecoveryWindow)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var reminders []models.CartReminder
+	if err = cursor.All(database.Ctx, &reminders); err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, reminder := range reminders {
+		var order models.Order
+		err := h.orderCollection.FindOne(database.Ctx,
+			bson.M{
+				"userId":    reminder.UserID,
+				"createdAt": bson.M{"$gt": reminder.SentAt, "$lte": reminder.SentAt.Add(cartRecoveryWindow)},
+				"status":    bson.M{"$ne": models.OrderCancelled},
+			},
+			options.FindOne().SetSort(bson.M{"createdAt": 1}),
+		).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return recovered, err
+		}
+
+		couponUsed := false
+		if reminder.PromotionID != nil && order.Pricing != nil {
+			for _, discount := range order.Pricing.Discounts {
+				if discount.PromotionID == *reminder.PromotionID {
+					couponUsed = true
+				}
+			}
+		}
+
+		_, err = h.reminderCollection.UpdateOne(database.Ctx,
+			bson.M{"_id": reminder.ID, "recoveredAt": nil},
+			bson.M{"$set": bson.M{
+				"recoveredAt": order.CreatedAt,
+				"orderId":     order.ID,
+				"revenue":     order.Total,
+				"couponUsed":  couponUsed,
+			}},
+		)
+		if err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+	return recovered, nil
+}
+
+// GetRecoveryReport sums up the reminders sent in the last days days
+// (default 30): how many were sent, how many led to an order and the
+// revenue of those orders
+func (h *CartRemindersHandler) GetRecoveryReport(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 365 {
+		return c.Status(400).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	cursor, err := h.reminderCollection.Aggregate(database.Ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"sentAt": bson.M{"$gte": time.Now().AddDate(0, 0, -days)}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         nil,
+			"sent":        bson.M{"$sum": 1},
+			"recovered":   bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$recoveredAt", nil}}, 1, 0}}},
+			"revenue":     bson.M{"$sum": "$revenue"},
+			"couponsSent": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$couponCode", nil}}, 1, 0}}},
+			"couponsUsed": bson.M{"$sum": bson.M{"$cond": bson.A{"$couponUsed", 1, 0}}},
+		}}},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build report"})
+	}
+	var totals []struct {
+		Sent        int     `bson:"sent"`
+		Recovered   int     `bson:"recovered"`
+		Revenue     float64 `bson:"revenue"`
+		CouponsSent int     `bson:"couponsSent"`
+		CouponsUsed int     `bson:"couponsUsed"`
+	}
+	if err = cursor.All(database.Ctx, &totals); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build report"})
+	}
+
+	report := models.CartRecoveryReport{Days: days}
+	if len(totals) > 0 {
+		report.Sent = totals[0].Sent
+		report.Recovered = totals[0].Recovered
+		report.RecoveredRevenue = pricing.Round(totals[0].Revenue)
+		report.CouponsSent = totals[0].CouponsSent
+		report.CouponsUsed = totals[0].CouponsUsed
+	}
+	if report.Sent > 0 {
+		report.RecoveryRate = float64(report.Recovered) / float64(report.Sent)
+	}
+
+	return c.JSON(report)
+}
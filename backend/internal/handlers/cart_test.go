@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pricing"
+)
+
+// The cart tests run against a real MongoDB named by MONGO_TEST_URI, in a
+// throwaway database; they are skipped when it is not set
+func TestMain(m *testing.M) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		os.Exit(m.Run())
+	}
+
+	name := fmt.Sprintf("ecom_test_%d", time.Now().UnixNano())
+	if err := database.Connect(uri, name); err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to %s: %v\n", uri, err)
+		os.Exit(1)
+	}
+	if err := database.EnsureIndexes(); err != nil {
+		fmt.Fprintf(os.Stderr, "creating indexes: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	database.Database.Drop(database.Ctx)
+	database.Disconnect()
+	os.Exit(code)
+}
+
+func requireMongo(t *testing.T) {
+	t.Helper()
+	if database.Database == nil {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+}
+
+// newCartApp serves the cart routes, taking the signed-in user from the
+// X-Test-User header instead of a token
+func newCartApp(t *testing.T) (*fiber.App, *CartHandler) {
+	t.Helper()
+	engine := pricing.New(pricing.Config{})
+	h := NewCartHandler("test-secret", time.Hour, CartMergeSum, engine, NewPromotionsHandler())
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if user := c.Get("X-Test-User"); user != "" {
+			c.Locals("userId", user)
+		}
+		return c.Next()
+	})
+	app.Post("/cart", h.AddToCart)
+	app.Put("/cart/:productId", h.UpdateCartItem)
+	app.Post("/cart/saved/:productId/move-to-cart", h.MoveToCart)
+	return app, h
+}
+
+func insertProduct(t *testing.T, stock int) primitive.ObjectID {
+	t.Helper()
+	product := models.Product{
+		ID:        primitive.NewObjectID(),
+		Title:     "Test product",
+		Price:     10,
+		Stock:     stock,
+		Images:    []string{},
+		Category:  "test",
+		Status:    models.ProductActive,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if _, err := database.Database.Collection("products").InsertOne(database.Ctx, product); err != nil {
+		t.Fatalf("inserting product: %v", err)
+	}
+	return product.ID
+}
+
+func send(t *testing.T, app *fiber.App, method, path string, headers map[string]string, body interface{}) int {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// parallel runs fn n times at once and returns the status codes
+func parallel(n int, fn func(i int) int) []int {
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses[i] = fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return statuses
+}
+
+func loadCart(t *testing.T, ref cartRef) models.Cart {
+	t.Helper()
+	var cart models.Cart
+	if err := ref.collection.FindOne(database.Ctx, ref.filter).Decode(&cart); err != nil {
+		t.Fatalf("loading cart: %v", err)
+	}
+	return cart
+}
+
+func quantityOf(cart models.Cart, productID primitive.ObjectID) (int, int) {
+	quantity, lines := 0, 0
+	for _, item := range cart.Items {
+		if item.ProductID == productID {
+			quantity += item.Quantity
+			lines++
+		}
+	}
+	return quantity, lines
+}
+
+// Concurrent first adds to a cart that does not exist yet race on the
+// upsert; the losers hit the duplicate key, retry and increment instead
+func TestAddToCartConcurrentFirstAdds(t *testing.T) {
+	requireMongo(t)
+	app, h := newCartApp(t)
+	productID := insertProduct(t, 1000)
+	userID := primitive.NewObjectID()
+
+	const n = 25
+	statuses := parallel(n, func(int) int {
+		return send(t, app, "POST", "/cart", map[string]string{"X-Test-User": userID.Hex()},
+			models.AddToCartRequest{ProductID: productID, Quantity: 2})
+	})
+	for i, status := range statuses {
+		if status != 200 {
+			t.Errorf("request %d: status %d, want 200", i, status)
+		}
+	}
+
+	quantity, lines := quantityOf(loadCart(t, h.userCart(userID)), productID)
+	if lines != 1 {
+		t.Errorf("cart has %d lines for the product, want 1", lines)
+	}
+	if quantity != 2*n {
+		t.Errorf("quantity = %d, want %d", quantity, 2*n)
+	}
+}
+
+// Adds beyond the stock are refused through the duplicate key and
+// CountDocuments path, and the refused ones leave the cart untouched
+func TestAddToCartConcurrentExceedsStock(t *testing.T) {
+	requireMongo(t)
+	app, h := newCartApp(t)
+	const stock = 10
+	productID := insertProduct(t, stock)
+	userID := primitive.NewObjectID()
+
+	statuses := parallel(3*stock, func(int) int {
+		return send(t, app, "POST", "/cart", map[string]string{"X-Test-User": userID.Hex()},
+			models.AddToCartRequest{ProductID: productID, Quantity: 1})
+	})
+	accepted := 0
+	for i, status := range statuses {
+		switch status {
+		case 200:
+			accepted++
+		case 400:
+		default:
+			t.Errorf("request %d: status %d, want 200 or 400", i, status)
+		}
+	}
+	if accepted != stock {
+		t.Errorf("%d adds accepted, want %d", accepted, stock)
+	}
+
+	quantity, lines := quantityOf(loadCart(t, h.userCart(userID)), productID)
+	if lines != 1 || quantity != stock {
+		t.Errorf("cart holds %d in %d lines, want %d in 1", quantity, lines, stock)
+	}
+}
+
+// Lines for different products added at once to one guest cart are all
+// kept
+func TestAddToCartConcurrentGuestLines(t *testing.T) {
+	requireMongo(t)
+	app, h := newCartApp(t)
+	cartID := primitive.NewObjectID()
+	token := h.cartToken(cartID)
+
+	const n = 20
+	products := make([]primitive.ObjectID, n)
+	for i := range products {
+		products[i] = insertProduct(t, 100)
+	}
+
+	statuses := parallel(n, func(i int) int {
+		return send(t, app, "POST", "/cart", map[string]string{CartTokenHeader: token},
+			models.AddToCartRequest{ProductID: products[i], Quantity: i + 1})
+	})
+	for i, status := range statuses {
+		if status != 200 {
+			t.Errorf("request %d: status %d, want 200", i, status)
+		}
+	}
+
+	cart := loadCart(t, h.guestCart(cartID))
+	for i, productID := range products {
+		if quantity, lines := quantityOf(cart, productID); lines != 1 || quantity != i+1 {
+			t.Errorf("product %d: %d in %d lines, want %d in 1", i, quantity, lines, i+1)
+		}
+	}
+}
+
+// Quantity updates to different lines of one cart do not overwrite each
+// other, and updates racing with adds to other lines lose neither
+func TestUpdateCartItemConcurrent(t *testing.T) {
+	requireMongo(t)
+	app, h := newCartApp(t)
+	userID := primitive.NewObjectID()
+	headers := map[string]string{"X-Test-User": userID.Hex()}
+
+	const n = 10
+	existing := make([]primitive.ObjectID, n)
+	for i := range existing {
+		existing[i] = insertProduct(t, 100)
+		if status := send(t, app, "POST", "/cart", headers, models.AddToCartRequest{ProductID: existing[i], Quantity: 1}); status != 200 {
+			t.Fatalf("seeding cart: status %d", status)
+		}
+	}
+	added := make([]primitive.ObjectID, n)
+	for i := range added {
+		added[i] = insertProduct(t, 100)
+	}
+
+	statuses := parallel(2*n, func(i int) int {
+		if i < n {
+			return send(t, app, "PUT", "/cart/"+existing[i].Hex(), headers, models.UpdateCartItemRequest{Quantity: 10 + i})
+		}
+		return send(t, app, "POST", "/cart", headers, models.AddToCartRequest{ProductID: added[i-n], Quantity: 3})
+	})
+	for i, status := range statuses {
+		if status != 200 {
+			t.Errorf("request %d: status %d, want 200", i, status)
+		}
+	}
+
+	cart := loadCart(t, h.userCart(userID))
+	if len(cart.Items) != 2*n {
+		t.Errorf("cart has %d lines, want %d", len(cart.Items), 2*n)
+	}
+	for i, productID := range existing {
+		if quantity, _ := quantityOf(cart, productID); quantity != 10+i {
+			t.Errorf("updated line %d: quantity %d, want %d", i, quantity, 10+i)
+		}
+	}
+	for i, productID := range added {
+		if quantity, _ := quantityOf(cart, productID); quantity != 3 {
+			t.Errorf("added line %d: quantity %d, want 3", i, quantity)
+		}
+	}
+
+	// Sanity check that the carts are keyed as the handler expects
+	count, _ := h.cartCollection.CountDocuments(database.Ctx, bson.M{"userId": userID})
+	if count != 1 {
+		t.Errorf("%d carts for the user, want 1", count)
+	}
+}
+
+// A merge keeps the price the guest added a line at, and a merge retried
+// after failing part way does not add the merged lines again
+func TestMergeGuestCartRetry(t *testing.T) {
+	requireMongo(t)
+	_, h := newCartApp(t)
+	userID := primitive.NewObjectID()
+	first, second := insertProduct(t, 100), insertProduct(t, 100)
+
+	guest := models.Cart{
+		ID: primitive.NewObjectID(),
+		Items: []models.CartItem{
+			{ProductID: first, Quantity: 2, UnitPrice: 8},
+			{ProductID: second, Quantity: 3, UnitPrice: 9, Note: "for Sam"},
+		},
+	}
+	if _, err := h.guestCollection.InsertOne(database.Ctx, guest); err != nil {
+		t.Fatalf("inserting guest cart: %v", err)
+	}
+	ref := h.userCart(userID)
+
+	// The first attempt merged one line, then failed
+	if cerr := h.mergeLine(ref, guest.ID.Hex()+":"+first.Hex(), guest.Items[0], 2); cerr != nil {
+		t.Fatalf("merging the first line: %s", cerr.message)
+	}
+	if err := h.MergeGuestCart(userID, h.cartToken(guest.ID)); err != nil {
+		t.Fatalf("MergeGuestCart: %v", err)
+	}
+
+	cart := loadCart(t, ref)
+	for _, want := range []models.CartItem{
+		{ProductID: first, Quantity: 2, UnitPrice: 8},
+		{ProductID: second, Quantity: 3, UnitPrice: 9, Note: "for Sam"},
+	} {
+		line, found := findLine(cart.Items, want.ProductID)
+		if !found || line.Quantity != want.Quantity || line.UnitPrice != want.UnitPrice || line.Note != want.Note {
+			t.Errorf("line %s = %+v, want %+v", want.ProductID.Hex(), line, want)
+		}
+	}
+	if len(cart.MergedLines) != 0 {
+		t.Errorf("merge tags left behind: %v", cart.MergedLines)
+	}
+	if count, _ := h.guestCollection.CountDocuments(database.Ctx, bson.M{"_id": guest.ID}); count != 0 {
+		t.Error("guest cart not deleted after the merge")
+	}
+
+	// Logging in again with the same token finds nothing to merge
+	if err := h.MergeGuestCart(userID, h.cartToken(guest.ID)); err != nil {
+		t.Fatalf("second MergeGuestCart: %v", err)
+	}
+	if quantity, _ := quantityOf(loadCart(t, ref), first); quantity != 2 {
+		t.Errorf("quantity after a second login = %d, want 2", quantity)
+	}
+}
+
+// A guest cart another login is merging is left to it
+func TestMergeGuestCartClaimed(t *testing.T) {
+	requireMongo(t)
+	_, h := newCartApp(t)
+	productID := insertProduct(t, 100)
+
+	until := time.Now().Add(time.Minute)
+	guest := models.Cart{
+		ID:           primitive.NewObjectID(),
+		Items:        []models.CartItem{{ProductID: productID, Quantity: 1, UnitPrice: 10}},
+		MergingUntil: &until,
+	}
+	if _, err := h.guestCollection.InsertOne(database.Ctx, guest); err != nil {
+		t.Fatalf("inserting guest cart: %v", err)
+	}
+
+	userID := primitive.NewObjectID()
+	if err := h.MergeGuestCart(userID, h.cartToken(guest.ID)); err != nil {
+		t.Fatalf("MergeGuestCart: %v", err)
+	}
+	if count, _ := h.cartCollection.CountDocuments(database.Ctx, bson.M{"userId": userID}); count != 0 {
+		t.Error("claimed guest cart was merged")
+	}
+	if count, _ := h.guestCollection.CountDocuments(database.Ctx, bson.M{"_id": guest.ID}); count != 1 {
+		t.Error("claimed guest cart was deleted")
+	}
+}
+
+// Moving a saved line onto one already in the cart adds the quantity once,
+// however many moves race, and leaves the cart line's note alone
+func TestMoveToCartConcurrent(t *testing.T) {
+	requireMongo(t)
+	app, h := newCartApp(t)
+	productID := insertProduct(t, 100)
+	userID := primitive.NewObjectID()
+	ref := h.userCart(userID)
+
+	_, err := h.cartCollection.InsertOne(database.Ctx, models.Cart{
+		UserID:        userID,
+		Items:         []models.CartItem{{ProductID: productID, Quantity: 2, UnitPrice: 10, Note: "in the cart"}},
+		SavedForLater: []models.CartItem{{ProductID: productID, Quantity: 3, UnitPrice: 10, Note: "saved"}},
+		UpdatedAt:     time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("inserting cart: %v", err)
+	}
+
+	statuses := parallel(10, func(int) int {
+		return send(t, app, "POST", "/cart/saved/"+productID.Hex()+"/move-to-cart", map[string]string{"X-Test-User": userID.Hex()}, nil)
+	})
+	moved := 0
+	for i, status := range statuses {
+		switch status {
+		case 200:
+			moved++
+		case 404:
+		default:
+			t.Errorf("request %d: status %d, want 200 or 404", i, status)
+		}
+	}
+	if moved != 1 {
+		t.Errorf("%d moves succeeded, want 1", moved)
+	}
+
+	cart := loadCart(t, ref)
+	line, _ := findLine(cart.Items, productID)
+	if line.Quantity != 5 || line.Note != "in the cart" {
+		t.Errorf("cart line = %+v, want quantity 5 with its own note", line)
+	}
+	if len(cart.SavedForLater) != 0 {
+		t.Errorf("saved list = %+v, want empty", cart.SavedForLater)
+	}
+}
+
+// Databases from before carts were unique per user get their duplicate
+// carts merged when the indexes are built
+func TestEnsureIndexesMergesDuplicateCarts(t *testing.T) {
+	requireMongo(t)
+	carts := database.Database.Collection("carts")
+	if _, err := carts.Indexes().DropAll(database.Ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	userID := primitive.NewObjectID()
+	shared, older, newer := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	now := time.Now()
+	_, err := carts.InsertMany(database.Ctx, []interface{}{
+		models.Cart{UserID: userID, UpdatedAt: now.Add(-time.Hour), Coupons: []string{"OLD"},
+			Items: []models.CartItem{{ProductID: shared, Quantity: 5}, {ProductID: older, Quantity: 1}}},
+		models.Cart{UserID: userID, UpdatedAt: now, Coupons: []string{"NEW"},
+			Items: []models.CartItem{{ProductID: shared, Quantity: 2}, {ProductID: newer, Quantity: 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.EnsureIndexes(); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+
+	var left []models.Cart
+	cursor, err := carts.Find(database.Ctx, bson.M{"userId": userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(database.Ctx, &left); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 {
+		t.Fatalf("%d carts left, want 1", len(left))
+	}
+	cart := left[0]
+	for productID, want := range map[primitive.ObjectID]int{shared: 2, older: 1, newer: 1} {
+		if got, _ := quantityOf(cart, productID); got != want {
+			t.Errorf("quantity of %s = %d, want %d", productID.Hex(), got, want)
+		}
+	}
+	if len(cart.Coupons) != 2 {
+		t.Errorf("coupons = %v, want both carts' coupons", cart.Coupons)
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+)
+
+// The catalog generation counts writes to products. GetProducts tags its
+// pages with it, so a conditional request for an unchanged catalog is
+// answered before any product query runs. It lives in the database so
+// every instance sees the same value.
+const catalogStateID = "products"
+
+// bumpCatalog moves the catalog generation on. Call it after a product
+// write is visible, i.e. after its transaction commits, or a page read in
+// between would be cached under the new generation.
+func bumpCatalog() {
+	_, err := database.Database.Collection("catalog_state").UpdateOne(database.Ctx,
+		bson.M{"_id": catalogStateID},
+		bson.M{"$inc": bson.M{"generation": 1}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Failed to bump the catalog generation: %v", err)
+	}
+}
+
+// catalogGeneration reads the catalog generation; 0 before the first write
+func catalogGeneration() (int64, error) {
+	var state struct {
+		Generation int64 `bson:"generation"`
+	}
+	err := database.Database.Collection("catalog_state").FindOne(database.Ctx, bson.M{"_id": catalogStateID}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	return state.Generation, nil
+}
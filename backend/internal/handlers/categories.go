@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/slug"
+)
+
+type CategoriesHandler struct {
+	collection        *mongo.Collection
+	productCollection *mongo.Collection
+}
+
+func NewCategoriesHandler() *CategoriesHandler {
+	return &CategoriesHandler{
+		collection:        database.Database.Collection("categories"),
+		productCollection: database.Database.Collection("products"),
+	}
+}
+
+// Ensure creates the category record for a name products use, giving it a
+// slug derived from the name. Existing categories are left alone.
+func (h *CategoriesHandler) Ensure(name string) error {
+	if name == "" {
+		return nil
+	}
+	count, err := h.collection.CountDocuments(database.Ctx, bson.M{"name": name}, options.Count().SetLimit(1))
+	if err != nil || count > 0 {
+		return err
+	}
+
+	categorySlug, err := uniqueSlug(h.collection, name, "category", primitive.NilObjectID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = h.collection.UpdateOne(database.Ctx,
+		bson.M{"name": name},
+		bson.M{"$setOnInsert": bson.M{"slug": categorySlug, "createdAt": now, "updatedAt": now}},
+		options.Update().SetUpsert(true),
+	)
+	// A concurrent Ensure for the same name already created it
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// EnsureAll creates records for every category products already use, so
+// catalogs from before categories had slugs get them on startup
+func (h *CategoriesHandler) EnsureAll() (int, error) {
+	names, err := h.productCollection.Distinct(database.Ctx, "category", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	before, err := h.collection.CountDocuments(database.Ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		if s, ok := name.(string); ok {
+			if err := h.Ensure(s); err != nil {
+				log.Printf("Failed to create category %q: %v", s, err)
+			}
+		}
+	}
+	after, err := h.collection.CountDocuments(database.Ctx, bson.M{})
+	return int(after - before), err
+}
+
+func (h *CategoriesHandler) GetCategories(c *fiber.Ctx) error {
+	cursor, err := h.collection.Find(database.Ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	categories := []models.Category{}
+	if err = cursor.All(database.Ctx, &categories); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode categories"})
+	}
+
+	return c.JSON(fiber.Map{"categories": categories})
+}
+
+// GetCategoryBySlug resolves a storefront category URL. A slug the category
+// used to have answers with a permanent redirect to the current one.
+func (h *CategoriesHandler) GetCategoryBySlug(c *fiber.Ctx) error {
+	categorySlug := c.Params("slug")
+
+	var category models.Category
+	err := h.collection.FindOne(database.Ctx, bson.M{"slug": categorySlug}).Decode(&category)
+	if err == nil {
+		return c.JSON(category)
+	}
+	if err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category"})
+	}
+
+	targetID, ok, err := resolveSlugRedirect(models.SlugCategory, categorySlug)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category"})
+	}
+	if ok {
+		if err := h.collection.FindOne(database.Ctx, bson.M{"_id": targetID}).Decode(&category); err == nil {
+			c.Location("/api/categories/by-slug/" + url.PathEscape(category.Slug))
+			return c.Status(301).JSON(fiber.Map{"id": category.ID, "slug": category.Slug})
+		}
+	}
+	return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+}
+
+// UpdateCategory edits a category's slug and SEO fields. The old slug keeps
+// redirecting to the category.
+func (h *CategoriesHandler) UpdateCategory(c *fiber.Ctx) error {
+	name, err := categoryParam(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category"})
+	}
+
+	var req models.UpdateCategoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Slug != nil && !slug.Valid(*req.Slug) {
+		return c.Status(400).JSON(fiber.Map{"error": "Slug must be lowercase letters, digits and single hyphens"})
+	}
+
+	var existing models.Category
+	err = h.collection.FindOne(database.Ctx, bson.M{"name": name}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category"})
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if req.Slug != nil {
+		update["slug"] = *req.Slug
+	}
+	if req.MetaTitle != nil {
+		update["metaTitle"] = *req.MetaTitle
+	}
+	if req.MetaDescription != nil {
+		update["metaDescription"] = *req.MetaDescription
+	}
+
+	var category models.Category
+	err = h.collection.FindOneAndUpdate(database.Ctx,
+		bson.M{"_id": existing.ID},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&category)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "Slug already in use"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update category"})
+	}
+
+	if category.Slug != existing.Slug {
+		if err := recordSlugChange(models.SlugCategory, existing.Slug, category.Slug, category.ID); err != nil {
+			log.Printf("Failed to record slug change for category %q: %v", category.Name, err)
+		}
+	}
+
+	return c.JSON(category)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecom-backend/internal/database"
+)
+
+// matchVersion narrows filter to the version the client last saw. Documents
+// written before versioning have no version field and count as version 0.
+func matchVersion(filter bson.M, expected *int64) bson.M {
+	if expected == nil {
+		return filter
+	}
+	if *expected == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	} else {
+		filter["version"] = *expected
+	}
+	return filter
+}
+
+// versionConflict answers a stale If-Match with the current representation
+// so the client can merge and retry
+func versionConflict(c *fiber.Ctx, current interface{}) error {
+	return c.Status(409).JSON(fiber.Map{
+		"error":   "The resource was modified by someone else; reload and retry",
+		"current": current,
+	})
+}
+
+// staleWrite answers a conditional write that matched nothing: 409 with the
+// document as it is now, or 404 when it no longer exists. current must be a
+// pointer to the document's model; name is used in error messages.
+func staleWrite(c *fiber.Ctx, collection *mongo.Collection, id primitive.ObjectID, current interface{}, name string) error {
+	err := collection.FindOne(database.Ctx, bson.M{"_id": id}).Decode(current)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": name + " not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch " + strings.ToLower(name)})
+	}
+	return versionConflict(c, current)
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/feeds"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/models"
+)
+
+const (
+	feedStateID = "products"
+
+	// feedFormat is bumped whenever rendering changes, so the next refresh
+	// re-renders every entry rather than only changed products
+	feedFormat = 2
+
+	// feedRefreshOverlap re-reads products updated shortly before the last
+	// refresh started, covering writes that committed while it ran
+	feedRefreshOverlap = time.Minute
+)
+
+// FeedsHandler serves the shopping feeds and sitemap from pre-rendered
+// per-product entries, which Refresh keeps in step with the catalog
+type FeedsHandler struct {
+	entryCollection    *mongo.Collection
+	stateCollection    *mongo.Collection
+	productCollection  *mongo.Collection
+	categoryCollection *mongo.Collection
+	site               feeds.Site
+}
+
+func NewFeedsHandler(site feeds.Site) *FeedsHandler {
+	return &FeedsHandler{
+		entryCollection:    database.Database.Collection("feed_entries"),
+		stateCollection:    database.Database.Collection("feed_state"),
+		productCollection:  database.Database.Collection("products"),
+		categoryCollection: database.Database.Collection("categories"),
+		site:               site,
+	}
+}
+
+// Refresh re-renders the entries of products changed since the last run,
+// dropping those that left the catalog. The first run renders everything.
+// It returns the number of products processed.
+func (h *FeedsHandler) Refresh() (int, error) {
+	state, err := h.state()
+	if err != nil {
+		return 0, err
+	}
+
+	started := time.Now()
+	filter := bson.M{}
+	if !state.SyncedAt.IsZero() && state.Format == feedFormat {
+		filter["updatedAt"] = bson.M{"$gte": state.SyncedAt.Add(-feedRefreshOverlap)}
+	}
+	cursor, err := h.productCollection.Find(database.Ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	processed := 0
+	for cursor.Next(database.Ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return processed, err
+		}
+		if err := h.render(product); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	if err := cursor.Err(); err != nil {
+		return processed, err
+	}
+
+	set := bson.M{"syncedAt": started, "format": feedFormat}
+	if processed > 0 {
+		set["changedAt"] = started
+	}
+	_, err = h.stateCollection.UpdateOne(database.Ctx, bson.M{"_id": feedStateID}, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	return processed, err
+}
+
+func (h *FeedsHandler) render(product models.Product) error {
+	visible := product.DeletedAt == nil && (product.Status == models.ProductActive || product.Status == "")
+	if !visible {
+		_, err := h.entryCollection.DeleteOne(database.Ctx, bson.M{"_id": product.ID})
+		return err
+	}
+
+	item, err := feeds.RSSItem(h.site, product)
+	if err != nil {
+		return err
+	}
+	entry := models.FeedEntry{
+		ProductID: product.ID,
+		Item:      item,
+		Row:       feeds.CSVRow(h.site, product),
+		Loc:       h.site.ProductURL(product),
+		LastMod:   product.UpdatedAt,
+	}
+	_, err = h.entryCollection.ReplaceOne(database.Ctx, bson.M{"_id": product.ID}, entry, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (h *FeedsHandler) state() (models.FeedState, error) {
+	var state models.FeedState
+	err := h.stateCollection.FindOne(database.Ctx, bson.M{"_id": feedStateID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return state, nil
+	}
+	return state, err
+}
+
+// notModified answers conditional requests for a feed; feeds only change
+// when a refresh changed some entry
+func (h *FeedsHandler) notModified(c *fiber.Ctx, name string) (bool, error) {
+	state, err := h.state()
+	if err != nil {
+		return false, err
+	}
+	etag := httpcache.ETag(name, state.ChangedAt.UnixNano())
+	return httpcache.NotModified(c, etag, state.ChangedAt), nil
+}
+
+// streamEntries writes every entry in product order, so the response never
+// holds the whole catalog in memory
+func (h *FeedsHandler) streamEntries(c *fiber.Ctx, opts *options.FindOptions, write func(w *bufio.Writer, entry models.FeedEntry) error, header, footer func(w *bufio.Writer) error) error {
+	cursor, err := h.entryCollection.Find(database.Ctx, bson.M{}, opts.SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch feed"})
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cursor.Close(database.Ctx)
+		if err := header(w); err != nil {
+			return
+		}
+		for cursor.Next(database.Ctx) {
+			var entry models.FeedEntry
+			if err := cursor.Decode(&entry); err != nil {
+				log.Printf("Failed to decode feed entry: %v", err)
+				return
+			}
+			// A write error means the client went away
+			if err := write(w, entry); err != nil {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Printf("Failed to stream feed: %v", err)
+			return
+		}
+		footer(w)
+	})
+	return nil
+}
+
+// GetRSSFeed serves a Google Merchant product feed
+func (h *FeedsHandler) GetRSSFeed(c *fiber.Ctx) error {
+	if fresh, err := h.notModified(c, "rss"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch feed"})
+	} else if fresh {
+		return c.SendStatus(304)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+	return h.streamEntries(c, options.Find().SetProjection(bson.M{"item": 1}),
+		func(w *bufio.Writer, entry models.FeedEntry) error {
+			_, err := w.WriteString(entry.Item + "\n")
+			return err
+		},
+		func(w *bufio.Writer) error { return feeds.WriteRSSHeader(w, h.site) },
+		func(w *bufio.Writer) error { return feeds.WriteRSSFooter(w) },
+	)
+}
+
+// GetCSVFeed serves the same products as GetRSSFeed as CSV
+func (h *FeedsHandler) GetCSVFeed(c *fiber.Ctx) error {
+	if fresh, err := h.notModified(c, "csv"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch feed"})
+	} else if fresh {
+		return c.SendStatus(304)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="products.csv"`)
+
+	var out *csv.Writer
+	return h.streamEntries(c, options.Find().SetProjection(bson.M{"row": 1}),
+		func(w *bufio.Writer, entry models.FeedEntry) error {
+			return out.Write(entry.Row)
+		},
+		func(w *bufio.Writer) error {
+			out = csv.NewWriter(w)
+			return out.Write(feeds.CSVHeader)
+		},
+		func(w *bufio.Writer) error {
+			out.Flush()
+			return out.Error()
+		},
+	)
+}
+
+// GetSitemapIndex lists the category sitemap and as many product sitemaps
+// as the catalog needs
+func (h *FeedsHandler) GetSitemapIndex(c *fiber.Ctx) error {
+	state, err := h.state()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sitemap"})
+	}
+	count, err := h.entryCollection.CountDocuments(database.Ctx, bson.M{})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sitemap"})
+	}
+
+	locs := []string{h.site.StorefrontURL + "/sitemaps/categories.xml"}
+	for page := 1; page == 1 || int64(page-1)*feeds.SitemapLimit < count; page++ {
+		locs = append(locs, h.site.StorefrontURL+"/sitemaps/products-"+strconv.Itoa(page)+".xml")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return feeds.WriteSitemapIndex(c, locs, state.ChangedAt)
+}
+
+func (h *FeedsHandler) GetProductSitemap(c *fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Params("page"))
+	if err != nil || page < 1 {
+		return c.Status(404).JSON(fiber.Map{"error": "Sitemap not found"})
+	}
+	if fresh, err := h.notModified(c, "sitemap-"+strconv.Itoa(page)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sitemap"})
+	} else if fresh {
+		return c.SendStatus(304)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	opts := options.Find().
+		SetProjection(bson.M{"loc": 1, "lastMod": 1}).
+		SetSkip(int64(page-1) * feeds.SitemapLimit).
+		SetLimit(feeds.SitemapLimit)
+	return h.streamEntries(c, opts,
+		func(w *bufio.Writer, entry models.FeedEntry) error {
+			_, err := w.WriteString(feeds.SitemapURL(entry.Loc, entry.LastMod) + "\n")
+			return err
+		},
+		func(w *bufio.Writer) error { return feeds.WriteURLSetHeader(w) },
+		func(w *bufio.Writer) error { return feeds.WriteURLSetFooter(w) },
+	)
+}
+
+func (h *FeedsHandler) GetCategorySitemap(c *fiber.Ctx) error {
+	cursor, err := h.categoryCollection.Find(database.Ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sitemap"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var categories []models.Category
+	if err = cursor.All(database.Ctx, &categories); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sitemap"})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	if err := feeds.WriteURLSetHeader(c); err != nil {
+		return err
+	}
+	for _, category := range categories {
+		if _, err := c.WriteString(feeds.SitemapURL(h.site.CategoryURL(category), category.UpdatedAt) + "\n"); err != nil {
+			return err
+		}
+	}
+	return feeds.WriteURLSetFooter(c)
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+// CartTokenHeader carries the guest cart token in both directions
+const CartTokenHeader = "X-Cart-Token"
+
+// How quantities combine when a guest cart is merged into a user's cart
+// that already holds the same product. Every strategy is capped by stock.
+const (
+	CartMergeSum   = "sum"   // add the quantities
+	CartMergeMax   = "max"   // keep the larger quantity
+	CartMergeUser  = "user"  // keep the user's quantity
+	CartMergeGuest = "guest" // take the guest's quantity
+)
+
+var errInvalidCartToken = errors.New("invalid cart token")
+
+// guestMergeClaim is how long a login has to merge a guest cart before
+// another may take it over
+const guestMergeClaim = time.Minute
+
+// cartRef identifies the cart a request works on: a user's cart by userId,
+// or a guest cart by its ID. The filter's fields seed a cart created by an
+// upsert.
+type cartRef struct {
+	collection *mongo.Collection
+	filter     bson.M
+	guest      bool
+}
+
+func (h *CartHandler) userCart(userID primitive.ObjectID) cartRef {
+	return cartRef{collection: h.cartCollection, filter: bson.M{"userId": userID}}
+}
+
+func (h *CartHandler) guestCart(cartID primitive.ObjectID) cartRef {
+	return cartRef{collection: h.guestCollection, filter: bson.M{"_id": cartID}, guest: true}
+}
+
+// where narrows the cart filter, e.g. to carts holding a given line
+func (r cartRef) where(extra bson.M) bson.M {
+	filter := bson.M{}
+	for k, v := range r.filter {
+		filter[k] = v
+	}
+	for k, v := range extra {
+		filter[k] = v
+	}
+	return filter
+}
+
+// touched returns the $set fields every cart write includes. Guest carts
+// slide their expiry forward on each change.
+func (h *CartHandler) touched(ref cartRef, set bson.M) bson.M {
+	if set == nil {
+		set = bson.M{}
+	}
+	now := time.Now()
+	set["updatedAt"] = now
+	if ref.guest {
+		set["expiresAt"] = now.Add(h.guestTTL)
+	}
+	return set
+}
+
+// resolveCart picks the cart for a request: the signed-in user's, or the
+// guest cart named by the cart token. With create set, a guest without a
+// token gets a new cart ID and the token is returned in the response
+// header. ok is false when the guest has no cart yet.
+func (h *CartHandler) resolveCart(c *fiber.Ctx, create bool) (ref cartRef, ok bool, cerr *cartError) {
+	if userID := currentUserID(c); userID != nil {
+		return h.userCart(*userID), true, nil
+	}
+
+	if token := c.Get(CartTokenHeader); token != "" {
+		cartID, err := h.parseCartToken(token)
+		if err != nil {
+			return cartRef{}, false, &cartError{400, "Invalid cart token"}
+		}
+		return h.guestCart(cartID), true, nil
+	}
+
+	if !create {
+		return cartRef{}, false, nil
+	}
+	cartID := primitive.NewObjectID()
+	c.Set(CartTokenHeader, h.cartToken(cartID))
+	return h.guestCart(cartID), true, nil
+}
+
+// GuestCartID returns the ID of the guest cart named by a request's cart
+// token, or "" when it carries no valid token
+func (h *CartHandler) GuestCartID(c *fiber.Ctx) string {
+	cartID, err := h.parseCartToken(c.Get(CartTokenHeader))
+	if err != nil {
+		return ""
+	}
+	return cartID.Hex()
+}
+
+// cartToken signs a guest cart ID so guests cannot guess each other's carts
+func (h *CartHandler) cartToken(cartID primitive.ObjectID) string {
+	return cartID.Hex() + "." + h.cartSignature(cartID)
+}
+
+func (h *CartHandler) parseCartToken(token string) (primitive.ObjectID, error) {
+	id, signature, found := strings.Cut(token, ".")
+	if !found {
+		return primitive.NilObjectID, errInvalidCartToken
+	}
+	cartID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, errInvalidCartToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(h.cartSignature(cartID))) {
+		return primitive.NilObjectID, errInvalidCartToken
+	}
+	return cartID, nil
+}
+
+func (h *CartHandler) cartSignature(cartID primitive.ObjectID) string {
+	mac := hmac.New(sha256.New, h.tokenSecret)
+	mac.Write([]byte("guest-cart:" + cartID.Hex()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MergeGuestCart moves a guest cart's items into the user's cart when the
+// guest signs in, combining quantities of products both carts hold with
+// the configured strategy. Lines keep the price the guest added them at.
+// The guest cart is claimed while merging, so concurrent logins do not
+// both merge it, and deleted only once the merge is done; each line is
+// tagged in the user's cart as it is merged, so a retry after a failure
+// part way does not merge it twice.
+func (h *CartHandler) MergeGuestCart(userID primitive.ObjectID, token string) error {
+	cartID, err := h.parseCartToken(token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var guest models.Cart
+	err = h.guestCollection.FindOneAndUpdate(database.Ctx,
+		bson.M{"_id": cartID, "$or": bson.A{
+			bson.M{"mergingUntil": bson.M{"$exists": false}},
+			bson.M{"mergingUntil": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"mergingUntil": now.Add(guestMergeClaim)}},
+	).Decode(&guest)
+	if err == mongo.ErrNoDocuments {
+		return nil // Gone, or another login is merging it
+	}
+	if err != nil {
+		return err
+	}
+	release := func() {
+		h.guestCollection.UpdateOne(database.Ctx, bson.M{"_id": cartID}, bson.M{"$unset": bson.M{"mergingUntil": ""}})
+	}
+
+	if err := h.mergeInto(h.userCart(userID), guest); err != nil {
+		release()
+		return err
+	}
+
+	if _, err := h.guestCollection.DeleteOne(database.Ctx, bson.M{"_id": cartID}); err != nil {
+		release()
+		return err
+	}
+	// The tags only matter while the guest cart exists
+	_, err = h.cartCollection.UpdateOne(database.Ctx, bson.M{"userId": userID}, bson.M{
+		"$pull": bson.M{"mergedLines": bson.M{"$regex": "^" + cartID.Hex() + ":"}},
+	})
+	return err
+}
+
+// mergeInto merges a claimed guest cart's lines, coupons and saved items
+// into ref, skipping lines an earlier attempt already merged
+func (h *CartHandler) mergeInto(ref cartRef, guest models.Cart) error {
+	var current models.Cart
+	err := h.cartCollection.FindOne(database.Ctx, ref.filter).Decode(&current)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	held := map[primitive.ObjectID]int{}
+	for _, item := range current.Items {
+		held[item.ProductID] = item.Quantity
+	}
+	merged := map[string]bool{}
+	for _, tag := range current.MergedLines {
+		merged[tag] = true
+	}
+
+	for _, item := range guest.Items {
+		tag := guest.ID.Hex() + ":" + item.ProductID.Hex()
+		if merged[tag] {
+			continue
+		}
+
+		filter := visibleProductFilter()
+		filter["_id"] = item.ProductID
+		var product models.Product
+		if err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product); err != nil {
+			continue // Withdrawn since the guest added it
+		}
+
+		userQuantity, inCart := held[item.ProductID]
+		quantity := h.mergedQuantity(userQuantity, item.Quantity, inCart, product.Stock)
+		if quantity <= 0 || (inCart && quantity == userQuantity) {
+			continue
+		}
+		if item.UnitPrice == 0 {
+			item.UnitPrice = product.PriceAt(time.Now())
+		}
+		if cerr := h.mergeLine(ref, tag, item, quantity); cerr != nil {
+			return errors.New(cerr.message)
+		}
+	}
+
+	// Coupons come along; they are checked against the user's limits when
+	// the cart is priced
+	if len(guest.Coupons) > 0 {
+		_, err = h.cartCollection.UpdateOne(database.Ctx, ref.filter, bson.M{
+			"$addToSet": bson.M{"coupons": bson.M{"$each": guest.Coupons}},
+			"$set":      h.touched(ref, nil),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Saved items the user has not saved already are added to their list
+	var saved []models.CartItem
+	for _, item := range guest.SavedForLater {
+		if _, found := findLine(current.SavedForLater, item.ProductID); !found {
+			saved = append(saved, item)
+		}
+	}
+	if len(saved) > 0 {
+		_, err = h.cartCollection.UpdateOne(database.Ctx, ref.filter, bson.M{
+			"$push": bson.M{"savedForLater": bson.M{"$each": saved}},
+			"$set":  h.touched(ref, nil),
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLine sets a merged line's quantity, adding the guest's line as it
+// stands when the cart lacks it, and tags the cart with the guest line in
+// the same write
+func (h *CartHandler) mergeLine(ref cartRef, tag string, line models.CartItem, quantity int) *cartError {
+	line.Quantity = quantity
+	line.Product = nil
+
+	for attempt := 0; attempt < maxCartAttempts; attempt++ {
+		result, err := ref.collection.UpdateOne(database.Ctx,
+			ref.where(bson.M{"items.productId": line.ProductID}),
+			bson.M{
+				"$set":      h.touched(ref, bson.M{"items.$.quantity": quantity}),
+				"$addToSet": bson.M{"mergedLines": tag},
+			},
+		)
+		if err != nil {
+			return &cartError{500, "Failed to update cart"}
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		_, err = ref.collection.UpdateOne(database.Ctx,
+			ref.where(bson.M{"items.productId": bson.M{"$ne": line.ProductID}}),
+			bson.M{
+				"$push":     bson.M{"items": line},
+				"$set":      h.touched(ref, nil),
+				"$addToSet": bson.M{"mergedLines": tag},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return &cartError{500, "Failed to update cart"}
+		}
+		// The user added the product in between; set it on the next pass
+	}
+	return &cartError{409, "Cart changed while updating, please retry"}
+}
+
+func (h *CartHandler) mergedQuantity(userQuantity, guestQuantity int, inCart bool, stock int) int {
+	quantity := guestQuantity
+	if inCart {
+		switch h.mergeStrategy {
+		case CartMergeMax:
+			quantity = max(userQuantity, guestQuantity)
+		case CartMergeUser:
+			return userQuantity
+		case CartMergeGuest:
+			quantity = guestQuantity
+		default:
+			quantity = userQuantity + guestQuantity
+		}
+	}
+	return min(quantity, stock)
+}
+
+// mergeGuestCartFrom merges the guest cart a signing-in request carries,
+// logging rather than failing the sign-in when it cannot
+func (h *CartHandler) mergeGuestCartFrom(c *fiber.Ctx, userID primitive.ObjectID) {
+	token := c.Get(CartTokenHeader)
+	if token == "" {
+		return
+	}
+	if err := h.MergeGuestCart(userID, token); err != nil {
+		log.Printf("Failed to merge guest cart into user %s: %v", userID.Hex(), err)
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/imaging"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/storage"
+)
+
+const imageURLPrefix = "/api/images/"
+
+// imageKeyPrefix starts the storage key of every product image, and is the
+// only part of the store ServeImage reads from
+const imageKeyPrefix = "products/"
+
+type ImagesHandler struct {
+	imageCollection   *mongo.Collection
+	productCollection *mongo.Collection
+	store             storage.Storage
+	maxUploadSize     int64
+}
+
+func NewImagesHandler(store storage.Storage, maxUploadSize int64) *ImagesHandler {
+	return &ImagesHandler{
+		imageCollection:   database.Database.Collection("product_images"),
+		productCollection: database.Database.Collection("products"),
+		store:             store,
+		maxUploadSize:     maxUploadSize,
+	}
+}
+
+func (h *ImagesHandler) UploadProductImage(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	count, err := h.productCollection.CountDocuments(database.Ctx, bson.M{"_id": productID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if count == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Image file is required in the 'image' field"})
+	}
+	if fileHeader.Size > h.maxUploadSize {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("Image exceeds the %d byte limit", h.maxUploadSize)})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read uploaded file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxUploadSize+1))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read uploaded file"})
+	}
+	if int64(len(data)) > h.maxUploadSize {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("Image exceeds the %d byte limit", h.maxUploadSize)})
+	}
+
+	// Trust the bytes, not the client-supplied Content-Type
+	contentType := http.DetectContentType(data)
+	ext, ok := imaging.AllowedTypes[contentType]
+	if !ok {
+		return c.Status(415).JSON(fiber.Map{"error": "Unsupported image type: " + contentType})
+	}
+
+	img, err := imaging.Decode(data)
+	if err == imaging.ErrTooManyPixels {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("Image exceeds the %d pixel limit", imaging.MaxPixels)})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Could not decode image"})
+	}
+
+	variants, err := imaging.Variants(img, imaging.DefaultSizes)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate thumbnails"})
+	}
+
+	imageID := primitive.NewObjectID()
+	prefix := imageKeyPrefix + productID.Hex() + "/" + imageID.Hex() + "/"
+	bounds := img.Bounds()
+
+	productImage := models.ProductImage{
+		ID:          imageID,
+		ProductID:   productID,
+		Key:         prefix + "original" + ext,
+		URL:         imageURLPrefix + prefix + "original" + ext,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Variants:    []models.ImageVariant{},
+		CreatedAt:   time.Now(),
+	}
+
+	stored := []string{}
+	cleanup := func() {
+		for _, key := range stored {
+			h.store.Delete(database.Ctx, key)
+		}
+	}
+
+	if err := h.store.Put(database.Ctx, productImage.Key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		log.Printf("Failed to store image %s: %v", productImage.Key, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to store image"})
+	}
+	stored = append(stored, productImage.Key)
+
+	for _, v := range variants {
+		key := prefix + v.Name + v.Ext
+		if err := h.store.Put(database.Ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			log.Printf("Failed to store image variant %s: %v", key, err)
+			cleanup()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to store image"})
+		}
+		stored = append(stored, key)
+
+		productImage.Variants = append(productImage.Variants, models.ImageVariant{
+			Name:        v.Name,
+			Key:         key,
+			URL:         imageURLPrefix + key,
+			ContentType: v.ContentType,
+			Width:       v.Width,
+			Height:      v.Height,
+		})
+	}
+
+	if _, err := h.imageCollection.InsertOne(database.Ctx, productImage); err != nil {
+		cleanup()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save image"})
+	}
+
+	result, err := h.productCollection.UpdateOne(database.Ctx, bson.M{"_id": productID}, bson.M{
+		"$push": bson.M{"images": productImage.URL},
+		"$set":  bson.M{"updatedAt": time.Now()},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil || result.MatchedCount == 0 {
+		// Leave nothing behind for an image the product does not list
+		h.imageCollection.DeleteOne(database.Ctx, bson.M{"_id": imageID})
+		cleanup()
+		if err == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to attach image to product"})
+	}
+	bumpCatalog()
+
+	return c.Status(201).JSON(productImage)
+}
+
+func (h *ImagesHandler) GetProductImages(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	cursor, err := h.imageCollection.Find(database.Ctx, bson.M{"productId": productID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch images"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	images := []models.ProductImage{}
+	if err = cursor.All(database.Ctx, &images); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode images"})
+	}
+
+	return c.JSON(images)
+}
+
+func (h *ImagesHandler) DeleteProductImage(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	imageID, err := primitive.ObjectIDFromHex(c.Params("imageId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid image ID"})
+	}
+
+	var image models.ProductImage
+	err = h.imageCollection.FindOne(database.Ctx, bson.M{"_id": imageID, "productId": productID}).Decode(&image)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Image not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch image"})
+	}
+
+	_, err = h.productCollection.UpdateOne(database.Ctx, bson.M{"_id": productID}, bson.M{
+		"$pull": bson.M{"images": image.URL},
+		"$set":  bson.M{"updatedAt": time.Now()},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to detach image from product"})
+	}
+	bumpCatalog()
+
+	if err := h.removeImages([]models.ProductImage{image}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete image"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Image deleted successfully"})
+}
+
+// ServeImage streams a stored original or variant. Keys embed the image ID,
+// so the content behind a URL never changes and can be cached forever.
+func (h *ImagesHandler) ServeImage(c *fiber.Ctx) error {
+	// Only image objects are served, never anything else in the store
+	key := c.Params("*")
+	if !strings.HasPrefix(key, imageKeyPrefix) || !storage.ValidKey(key) {
+		return c.Status(404).JSON(fiber.Map{"error": "Image not found"})
+	}
+
+	etag := `"` + key + `"`
+	if c.Get("If-None-Match") == etag {
+		return c.SendStatus(304)
+	}
+
+	reader, info, err := h.store.Get(database.Ctx, key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Image not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read image"})
+	}
+
+	if info.ContentType != "" {
+		c.Set("Content-Type", info.ContentType)
+	}
+	if !info.LastModified.IsZero() {
+		c.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	c.Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Set("ETag", etag)
+
+	size := -1
+	if info.Size > 0 {
+		size = int(info.Size)
+		c.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	return c.SendStream(reader, size)
+}
+
+// DeleteProductImages removes every uploaded image belonging to a product
+func (h *ImagesHandler) DeleteProductImages(productID primitive.ObjectID) error {
+	return h.deleteWhere(bson.M{"productId": productID})
+}
+
+// PruneProductImages removes uploaded images that are no longer referenced
+// from the product's image list
+func (h *ImagesHandler) PruneProductImages(productID primitive.ObjectID, keep []string) error {
+	return h.deleteWhere(bson.M{"productId": productID, "url": bson.M{"$nin": keep}})
+}
+
+func (h *ImagesHandler) deleteWhere(filter bson.M) error {
+	cursor, err := h.imageCollection.Find(database.Ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var images []models.ProductImage
+	if err = cursor.All(database.Ctx, &images); err != nil {
+		return err
+	}
+	return h.removeImages(images)
+}
+
+func (h *ImagesHandler) removeImages(images []models.ProductImage) error {
+	for _, image := range images {
+		keys := []string{image.Key}
+		for _, v := range image.Variants {
+			keys = append(keys, v.Key)
+		}
+		for _, key := range keys {
+			if err := h.store.Delete(database.Ctx, key); err != nil {
+				log.Printf("Failed to delete stored image %s: %v", key, err)
+			}
+		}
+
+		if _, err := h.imageCollection.DeleteOne(database.Ctx, bson.M{"_id": image.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/mailer"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pagination"
+)
+
+const mailTimeout = 30 * time.Second
+
+var alertSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+}
+
+type InventoryHandler struct {
+	productCollection *mongo.Collection
+	alertCollection   *mongo.Collection
+	orderCollection   *mongo.Collection
+	userCollection    *mongo.Collection
+	mail              mailer.Mailer
+	recipients        []string
+	defaultThreshold  int
+}
+
+// NewInventoryHandler sends alert mail to recipients, or to every active
+// admin when recipients is empty. defaultThreshold applies to products
+// without their own reorder threshold.
+func NewInventoryHandler(mail mailer.Mailer, recipients []string, defaultThreshold int) *InventoryHandler {
+	return &InventoryHandler{
+		productCollection: database.Database.Collection("products"),
+		alertCollection:   database.Database.Collection("stock_alerts"),
+		orderCollection:   database.Database.Collection("orders"),
+		userCollection:    database.Database.Collection("users"),
+		mail:              mail,
+		recipients:        recipients,
+		defaultThreshold:  defaultThreshold,
+	}
+}
+
+func (h *InventoryHandler) threshold(product models.Product) int {
+	if product.ReorderThreshold != nil {
+		return *product.ReorderThreshold
+	}
+	return h.defaultThreshold
+}
+
+// CheckStock raises alerts for the given products that are at or below their
+// threshold and resolves alerts for products that were restocked. With no
+// IDs it sweeps the whole catalog. It returns the number of new alerts.
+func (h *InventoryHandler) CheckStock(productIDs ...primitive.ObjectID) (int, error) {
+	filter := visibleProductFilter()
+	if len(productIDs) > 0 {
+		filter["_id"] = bson.M{"$in": productIDs}
+	}
+
+	cursor, err := h.productCollection.Find(database.Ctx, filter, options.Find().SetProjection(bson.M{
+		"title":            1,
+		"stock":            1,
+		"reorderThreshold": 1,
+	}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var raised []models.StockAlert
+	checked := map[primitive.ObjectID]bool{}
+	for cursor.Next(database.Ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return len(raised), err
+		}
+		checked[product.ID] = true
+
+		alert, err := h.evaluate(product)
+		if err != nil {
+			return len(raised), err
+		}
+		if alert != nil {
+			raised = append(raised, *alert)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return len(raised), err
+	}
+
+	// Alerts for products that left the catalog are no longer actionable
+	checkedIDs := make([]primitive.ObjectID, 0, len(checked))
+	for id := range checked {
+		checkedIDs = append(checkedIDs, id)
+	}
+	gone := bson.M{"$nin": checkedIDs}
+	if len(productIDs) > 0 {
+		gone["$in"] = productIDs
+	}
+	if err := h.resolveAlerts(bson.M{"open": true, "productId": gone}); err != nil {
+		return len(raised), err
+	}
+
+	if len(raised) > 0 {
+		if err := h.notify(raised); err != nil {
+			log.Printf("Failed to send stock alert mail: %v", err)
+		}
+	}
+
+	return len(raised), nil
+}
+
+// Recheck runs CheckStock in the background, for callers that just changed
+// stock and should not wait on alert mail
+func (h *InventoryHandler) Recheck(productIDs ...primitive.ObjectID) {
+	go func() {
+		if _, err := h.CheckStock(productIDs...); err != nil {
+			log.Printf("Stock check failed: %v", err)
+		}
+	}()
+}
+
+// evaluate brings the open alerts for one product in line with its stock
+// and returns the alert it raised, if any
+func (h *InventoryHandler) evaluate(product models.Product) (*models.StockAlert, error) {
+	threshold := h.threshold(product)
+
+	var kind models.StockAlertKind
+	switch {
+	case product.Stock <= 0:
+		kind = models.StockAlertOut
+	case product.Stock <= threshold:
+		kind = models.StockAlertLow
+	}
+
+	resolve := bson.M{"productId": product.ID, "open": true}
+	if kind != "" {
+		resolve["kind"] = bson.M{"$ne": kind}
+	}
+	if err := h.resolveAlerts(resolve); err != nil {
+		return nil, err
+	}
+	if kind == "" {
+		return nil, nil
+	}
+
+	// Refresh the open alert, or open one; the unique index on open alerts
+	// keeps concurrent checks from raising the same alert twice
+	now := time.Now()
+	result, err := h.alertCollection.UpdateOne(database.Ctx,
+		bson.M{"productId": product.ID, "kind": kind, "open": true},
+		bson.M{
+			"$set": bson.M{
+				"productTitle": product.Title,
+				"stock":        product.Stock,
+				"threshold":    threshold,
+			},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if result.UpsertedCount == 0 {
+		return nil, nil
+	}
+
+	return &models.StockAlert{
+		ID:           result.UpsertedID.(primitive.ObjectID),
+		ProductID:    product.ID,
+		ProductTitle: product.Title,
+		Kind:         kind,
+		Stock:        product.Stock,
+		Threshold:    threshold,
+		Open:         true,
+		CreatedAt:    now,
+	}, nil
+}
+
+func (h *InventoryHandler) resolveAlerts(filter bson.M) error {
+	_, err := h.alertCollection.UpdateMany(database.Ctx, filter, bson.M{
+		"$set": bson.M{"open": false, "resolvedAt": time.Now()},
+	})
+	return err
+}
+
+// notify mails newly raised alerts to the configured recipients
+func (h *InventoryHandler) notify(alerts []models.StockAlert) error {
+	recipients, err := h.alertRecipients()
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	body.WriteString("The following products need restocking:\n\n")
+	for _, alert := range alerts {
+		if alert.Kind == models.StockAlertOut {
+			fmt.Fprintf(&body, "- %s is out of stock\n", alert.ProductTitle)
+		} else {
+			fmt.Fprintf(&body, "- %s has %d left (reorder at %d)\n", alert.ProductTitle, alert.Stock, alert.Threshold)
+		}
+	}
+
+	subject := fmt.Sprintf("Stock alert: %s", alerts[0].ProductTitle)
+	if len(alerts) > 1 {
+		subject = fmt.Sprintf("Stock alert: %d products need restocking", len(alerts))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return h.mail.Send(ctx, mailer.Message{To: recipients, Subject: subject, Body: body.String()})
+}
+
+func (h *InventoryHandler) alertRecipients() ([]string, error) {
+	if len(h.recipients) > 0 {
+		return h.recipients, nil
+	}
+
+	emails, err := h.userCollection.Distinct(database.Ctx, "email", bson.M{
+		"role":     models.RoleAdmin,
+		"isActive": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if s, ok := email.(string); ok {
+			recipients = append(recipients, s)
+		}
+	}
+	return recipients, nil
+}
+
+// GetAlerts is the admin alert feed, newest first. status=open or
+// status=resolved narrows it.
+func (h *InventoryHandler) GetAlerts(c *fiber.Ctx) error {
+	filter := bson.M{}
+	switch c.Query("status") {
+	case "":
+	case "open":
+		filter["open"] = true
+	case "resolved":
+		filter["open"] = false
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "status must be open or resolved"})
+	}
+	if kind := c.Query("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+
+	page, err := pagination.ParseList(c, alertSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.alertCollection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch alerts"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var alerts []models.StockAlert
+	if err = cursor.All(database.Ctx, &alerts); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode alerts"})
+	}
+
+	alerts, links, err := pagination.Finish(page, alerts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	return c.JSON(fiber.Map{
+		"alerts":     alerts,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
+
+func (h *InventoryHandler) AcknowledgeAlert(c *fiber.Ctx) error {
+	alertID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid alert ID"})
+	}
+
+	var alert models.StockAlert
+	err = h.alertCollection.FindOneAndUpdate(database.Ctx,
+		bson.M{"_id": alertID},
+		bson.M{"$set": bson.M{
+			"acknowledgedBy": currentUserID(c),
+			"acknowledgedAt": time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&alert)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Alert not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to acknowledge alert"})
+	}
+
+	return c.JSON(alert)
+}
+
+// GetLowStockReport lists catalog products at or below their reorder
+// threshold with days of cover estimated from sales over the last `days`
+// days (default 30), the most urgent first
+func (h *InventoryHandler) GetLowStockReport(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 365 {
+		return c.Status(400).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	filter := visibleProductFilter()
+	filter["$expr"] = bson.M{"$lte": bson.A{"$stock", bson.M{"$ifNull": bson.A{"$reorderThreshold", h.defaultThreshold}}}}
+
+	cursor, err := h.productCollection.Find(database.Ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode products"})
+	}
+
+	ids := make([]primitive.ObjectID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	sold, err := h.unitsSold(ids, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate sales velocity"})
+	}
+
+	items := make([]models.LowStockItem, len(products))
+	for i, product := range products {
+		item := models.LowStockItem{
+			ProductID: product.ID,
+			Title:     product.Title,
+			Category:  product.Category,
+			Stock:     product.Stock,
+			Threshold: h.threshold(product),
+			UnitsSold: sold[product.ID],
+			DailyRate: float64(sold[product.ID]) / float64(days),
+		}
+		if item.DailyRate > 0 {
+			cover := float64(max(item.Stock, 0)) / item.DailyRate
+			item.DaysOfCover = &cover
+		}
+		items[i] = item
+	}
+
+	// Fewest days of cover first; products without recent sales go last
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].DaysOfCover, items[j].DaysOfCover
+		switch {
+		case a != nil && b != nil:
+			return *a < *b
+		case a != nil || b != nil:
+			return a != nil
+		default:
+			return items[i].Stock < items[j].Stock
+		}
+	})
+
+	return c.JSON(fiber.Map{
+		"products": items,
+		"days":     days,
+	})
+}
+
+// unitsSold totals the quantities ordered per product since the given time,
+// ignoring cancelled orders
+func (h *InventoryHandler) unitsSold(productIDs []primitive.ObjectID, since time.Time) (map[primitive.ObjectID]int, error) {
+	sold := map[primitive.ObjectID]int{}
+	if len(productIDs) == 0 {
+		return sold, nil
+	}
+
+	cursor, err := h.orderCollection.Aggregate(database.Ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"createdAt":       bson.M{"$gte": since},
+			"status":          bson.M{"$ne": models.OrderCancelled},
+			"items.productId": bson.M{"$in": productIDs},
+		}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: bson.M{"items.productId": bson.M{"$in": productIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$items.productId",
+			"units": bson.M{"$sum": "$items.quantity"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	for cursor.Next(database.Ctx) {
+		var row struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Units int                `bson:"units"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		sold[row.ID] = row.Units
+	}
+	return sold, cursor.Err()
+}
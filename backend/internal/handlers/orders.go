@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,66 +13,245 @@ import (
 
 	"ecom-backend/internal/models"
 	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/pagination"
+	"ecom-backend/internal/pricing"
 )
 
 type OrdersHandler struct {
 	orderCollection   *mongo.Collection
 	cartCollection    *mongo.Collection
 	productCollection *mongo.Collection
+	inventory         *InventoryHandler
+	pricing           *pricing.Engine
+	promotions        *PromotionsHandler
+	reservations      *ReservationsHandler
 }
 
-func NewOrdersHandler() *OrdersHandler {
+var orderSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"updatedAt": pagination.TimeKey("updatedAt"),
+	"total":     pagination.NumberKey("total").ThenBy("createdAt"),
+	"status":    pagination.StringKey("status").ThenBy("createdAt", "total"),
+}
+
+func NewOrdersHandler(inventory *InventoryHandler, engine *pricing.Engine, promotions *PromotionsHandler, reservations *ReservationsHandler) *OrdersHandler {
 	return &OrdersHandler{
 		orderCollection:   database.Database.Collection("orders"),
 		cartCollection:    database.Database.Collection("carts"),
 		productCollection: database.Database.Collection("products"),
+		inventory:         inventory,
+		pricing:           engine,
+		promotions:        promotions,
+		reservations:      reservations,
 	}
 }
 
+// CreateOrder is the older way to place an order. Orders are always built
+// from the user's cart, so it checks out the cart and refuses item lists.
 func (h *OrdersHandler) CreateOrder(c *fiber.Ctx) error {
-	userID := c.Locals("userId").(string)
-	objectID, err := primitive.ObjectIDFromHex(userID)
+	var req models.CreateOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.Items) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Orders are placed from the cart; add the items to the cart and check out"})
+	}
+
+	return h.Checkout(c)
+}
+
+// Checkout places an order from the user's cart. The cart is validated and
+// priced again; anything that changed since the customer last saw it is
+// answered with 409 and a list of problems, and the customer confirms by
+// checking out again with the new amounts.
+func (h *OrdersHandler) Checkout(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	var req models.CreateOrderRequest
+	var req models.CheckoutRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	req.Address = strings.TrimSpace(req.Address)
+	if req.Address == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Address is required"})
+	}
+
+	var cart models.Cart
+	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": objectID}).Decode(&cart)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if len(cart.Items) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+
+	priced, problems, err := h.checkLines(objectID, cart.Items)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+
+	now := time.Now()
+	promotions, err := h.promotions.Applicable(cart.Coupons, &objectID, now)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	totals := h.pricing.Price(priced, promotions, now)
+
+	// Without prices from the client, the ones recorded on the cart lines
+	// are what the customer agreed to
+	if req.ExpectedPrices == nil {
+		req.ExpectedPrices = map[string]float64{}
+		for _, item := range cart.Items {
+			if item.UnitPrice > 0 {
+				req.ExpectedPrices[item.ProductID.Hex()] = item.UnitPrice
+			}
+		}
+	}
+	for _, line := range totals.Lines {
+		expected, ok := req.ExpectedPrices[line.ProductID.Hex()]
+		if ok && pricing.Round(expected) != line.UnitPrice {
+			problems = append(problems, models.CheckoutProblem{
+				ProductID: line.ProductID,
+				Code:      models.ProblemPriceChanged,
+				Message:   fmt.Sprintf("The price of %s changed from %.2f to %.2f", line.Title, expected, line.UnitPrice),
+				Expected:  expected,
+				Current:   line.UnitPrice,
+			})
+		}
+	}
+	if req.ExpectedTotal != nil && pricing.Round(*req.ExpectedTotal) != totals.Total {
+		problems = append(problems, models.CheckoutProblem{
+			Code:     models.ProblemTotalChanged,
+			Message:  fmt.Sprintf("The order total changed from %.2f to %.2f", *req.ExpectedTotal, totals.Total),
+			Expected: *req.ExpectedTotal,
+			Current:  totals.Total,
+		})
+	}
+	if len(problems) > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "Please review your cart",
+			"problems": problems,
+			"totals":   totals,
+		})
+	}
+
+	order := newOrder(objectID, cart.Items, req.Address, totals)
+	if err := h.placeOrder(order, promotions, cart); err != nil {
+		return h.orderFailed(c, order, err)
+	}
+
+	return c.Status(201).JSON(order)
+}
+
+// checkLines validates a user's order lines against the catalog. It
+// returns the lines to price and the problems that stop them from being
+// ordered. Stock the user has reserved counts as available to them, and
+// expired holds on the lines' products, the user's own included, go back
+// to stock first.
+func (h *OrdersHandler) checkLines(userID primitive.ObjectID, lines []models.CartItem) ([]pricing.Item, []models.CheckoutProblem, error) {
+	if err := h.reservations.releaseLapsed(reservedProducts(lines)); err != nil {
+		return nil, nil, err
+	}
+	held, err := h.reservations.heldBy(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var priced []pricing.Item
+	var problems []models.CheckoutProblem
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			problems = append(problems, models.CheckoutProblem{
+				ProductID: line.ProductID,
+				Code:      models.ProblemInvalidQuantity,
+				Message:   "Quantity must be positive",
+				Quantity:  line.Quantity,
+			})
+			continue
+		}
+
+		filter := visibleProductFilter()
+		filter["_id"] = line.ProductID
 
-	// Validate items and calculate total
-	var total float64
-	for _, item := range req.Items {
 		var product models.Product
-		err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": item.ProductID}).Decode(&product)
+		err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			problems = append(problems, models.CheckoutProblem{
+				ProductID: line.ProductID,
+				Code:      models.ProblemUnavailable,
+				Message:   "Product not found: " + line.ProductID.Hex(),
+				Quantity:  line.Quantity,
+			})
+			continue
+		}
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "Product not found: " + item.ProductID.Hex()})
+			return nil, nil, err
 		}
 
-		if product.Stock < item.Quantity {
-			return c.Status(400).JSON(fiber.Map{"error": "Insufficient stock for product: " + product.Title})
+		if available := product.Stock + held[line.ProductID]; available < line.Quantity {
+			available = max(available, 0)
+			problem := models.CheckoutProblem{
+				ProductID: line.ProductID,
+				Code:      models.ProblemInsufficientStock,
+				Message:   "Insufficient stock for product: " + product.Title,
+				Quantity:  line.Quantity,
+				Available: &available,
+			}
+			if available == 0 {
+				problem.Code = models.ProblemOutOfStock
+				problem.Message = product.Title + " is out of stock"
+			}
+			problems = append(problems, problem)
+			continue
 		}
 
-		total += product.Price * float64(item.Quantity)
+		priced = append(priced, pricing.Item{Product: product, Quantity: line.Quantity})
+	}
+	return priced, problems, nil
+}
+
+// newOrder builds an order of items priced as totals; each item records
+// the unit price charged
+func newOrder(userID primitive.ObjectID, items []models.CartItem, address string, totals models.PriceBreakdown) models.Order {
+	charged := map[primitive.ObjectID]float64{}
+	for _, line := range totals.Lines {
+		charged[line.ProductID] = line.UnitPrice
+	}
+	orderItems := make([]models.CartItem, len(items))
+	for i, item := range items {
+		item.UnitPrice = charged[item.ProductID]
+		orderItems[i] = item
 	}
 
-	// Create order
-	order := models.Order{
+	now := time.Now()
+	return models.Order{
 		ID:        primitive.NewObjectID(),
-		UserID:    objectID,
-		Items:     req.Items,
-		Total:     total,
+		UserID:    userID,
+		Items:     orderItems,
+		Total:     totals.Total,
+		Pricing:   &totals,
 		Status:    models.OrderPending,
-		Address:   req.Address,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Address:   address,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
+}
+
+// errCartChanged aborts a checkout whose cart was modified after it was
+// priced
+var errCartChanged = errors.New("cart changed during checkout")
 
-	// Start transaction
+// placeOrder inserts the order, redeems its promotions, takes its stock and
+// empties the cart it was built from in one transaction. The cart must
+// still be as it was read.
+func (h *OrdersHandler) placeOrder(order models.Order, promotions []models.Promotion, cart models.Cart) error {
 	session, err := database.Client.StartSession()
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+		return err
 	}
 	defer session.EndSession(database.Ctx)
 
@@ -81,30 +262,65 @@ func (h *OrdersHandler) CreateOrder(c *fiber.Ctx) error {
 			return nil, err
 		}
 
-		// Update product stock
-		for _, item := range req.Items {
-			_, err := h.productCollection.UpdateOne(ctx, bson.M{"_id": item.ProductID}, bson.M{
-				"$inc": bson.M{"stock": -item.Quantity},
-			})
-			if err != nil {
-				return nil, err
-			}
+		// Redeem promotions together with the order, so a failed order
+		// uses up nothing and a used-up promotion fails the order
+		if err := h.promotions.redeem(ctx, promotions, order.Pricing.Discounts, order.UserID, order.ID); err != nil {
+			return nil, err
 		}
 
-		// Clear cart
-		_, err = h.cartCollection.DeleteOne(ctx, bson.M{"userId": objectID})
+		// Take the stock, using up the user's reservation first. A
+		// concurrent order that took the last units fails this one.
+		if err := h.reservations.commit(ctx, order.UserID, order.ID, order.Items); err != nil {
+			return nil, err
+		}
+
+		// Clear cart, keeping the items saved for later
+		filter := bson.M{"userId": order.UserID, "updatedAt": cart.UpdatedAt}
+		result, err := h.cartCollection.UpdateOne(ctx, filter, bson.M{
+			"$set":   bson.M{"items": []models.CartItem{}, "updatedAt": time.Now()},
+			"$unset": bson.M{"coupons": ""},
+		})
 		if err != nil {
 			return nil, err
 		}
+		if result.MatchedCount == 0 {
+			return nil, errCartChanged
+		}
 
 		return order, nil
 	})
-
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
+		return err
 	}
 
-	return c.Status(201).JSON(order)
+	productIDs := make([]primitive.ObjectID, len(order.Items))
+	for i, item := range order.Items {
+		productIDs[i] = item.ProductID
+	}
+	bumpCatalog()
+	h.inventory.Recheck(productIDs...)
+	return nil
+}
+
+func (h *OrdersHandler) orderFailed(c *fiber.Ctx, order models.Order, err error) error {
+	var shortage *stockShortage
+	if errors.As(err, &shortage) {
+		return c.Status(409).JSON(fiber.Map{
+			"error":    "Please review your cart",
+			"problems": []models.CheckoutProblem{h.reservations.shortageProblem(shortage.productID, order.Items)},
+		})
+	}
+	var unavailable *promotionUnavailable
+	if errors.As(err, &unavailable) {
+		return c.Status(409).JSON(fiber.Map{
+			"error":     "Promotion no longer available",
+			"promotion": unavailable.name,
+		})
+	}
+	if errors.Is(err, errCartChanged) {
+		return c.Status(409).JSON(fiber.Map{"error": "Cart changed during checkout, please review it"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
 }
 
 func (h *OrdersHandler) GetOrders(c *fiber.Ctx) error {
@@ -114,29 +330,7 @@ func (h *OrdersHandler) GetOrders(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	cursor, err := h.orderCollection.Find(database.Ctx, bson.M{"userId": objectID})
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch orders"})
-	}
-	defer cursor.Close(database.Ctx)
-
-	var orders []models.Order
-	if err = cursor.All(database.Ctx, &orders); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode orders"})
-	}
-
-	// Populate product details for each order
-	for i := range orders {
-		for j := range orders[i].Items {
-			var product models.Product
-			err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": orders[i].Items[j].ProductID}).Decode(&product)
-			if err == nil {
-				orders[i].Items[j].Product = &product
-			}
-		}
-	}
-
-	return c.JSON(orders)
+	return h.listOrders(c, bson.M{"userId": objectID})
 }
 
 func (h *OrdersHandler) GetOrder(c *fiber.Ctx) error {
@@ -174,37 +368,17 @@ func (h *OrdersHandler) GetOrder(c *fiber.Ctx) error {
 		}
 	}
 
+	c.Set(fiber.HeaderETag, httpcache.VersionETag(order.Version))
 	return c.JSON(order)
 }
 
 func (h *OrdersHandler) GetAllOrders(c *fiber.Ctx) error {
-	fmt.Println("GetAllOrders called")
-	cursor, err := h.orderCollection.Find(database.Ctx, bson.M{})
-	if err != nil {
-		fmt.Printf("Error finding orders: %v\n", err)
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch orders"})
-	}
-	defer cursor.Close(database.Ctx)
-
-	var orders []models.Order
-	if err = cursor.All(database.Ctx, &orders); err != nil {
-		fmt.Printf("Error decoding orders: %v\n", err)
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode orders"})
-	}
-
-	// Populate product details for each order
-	for i := range orders {
-		for j := range orders[i].Items {
-			var product models.Product
-			err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": orders[i].Items[j].ProductID}).Decode(&product)
-			if err == nil {
-				orders[i].Items[j].Product = &product
-			}
-		}
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
 	}
 
-	fmt.Printf("Found %d orders\n", len(orders))
-	return c.JSON(orders)
+	return h.listOrders(c, filter)
 }
 
 func (h *OrdersHandler) UpdateOrderStatus(c *fiber.Ctx) error {
@@ -219,16 +393,25 @@ func (h *OrdersHandler) UpdateOrderStatus(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	_, err = h.orderCollection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.orderCollection.UpdateOne(database.Ctx, matchVersion(bson.M{"_id": objectID}, expected), bson.M{
 		"$set": bson.M{
 			"status":    req.Status,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order status"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, h.orderCollection, objectID, &models.Order{}, "Order")
+	}
 
 	return c.JSON(fiber.Map{"message": "Order status updated"})
 }
@@ -240,29 +423,7 @@ func (h *OrdersHandler) GetAssignedOrders(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	cursor, err := h.orderCollection.Find(database.Ctx, bson.M{"assignedTo": objectID})
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch assigned orders"})
-	}
-	defer cursor.Close(database.Ctx)
-
-	var orders []models.Order
-	if err = cursor.All(database.Ctx, &orders); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode orders"})
-	}
-
-	// Populate product details for each order
-	for i := range orders {
-		for j := range orders[i].Items {
-			var product models.Product
-			err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": orders[i].Items[j].ProductID}).Decode(&product)
-			if err == nil {
-				orders[i].Items[j].Product = &product
-			}
-		}
-	}
-
-	return c.JSON(orders)
+	return h.listOrders(c, bson.M{"assignedTo": objectID})
 }
 
 func (h *OrdersHandler) MarkAsDelivered(c *fiber.Ctx) error {
@@ -278,6 +439,11 @@ func (h *OrdersHandler) MarkAsDelivered(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Check if order is assigned to this delivery agent
 	var order models.Order
 	err = h.orderCollection.FindOne(database.Ctx, bson.M{
@@ -292,16 +458,64 @@ func (h *OrdersHandler) MarkAsDelivered(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
 	}
 
-	_, err = h.orderCollection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{
+	filter := matchVersion(bson.M{"_id": objectID, "assignedTo": userObjectID}, expected)
+	result, err := h.orderCollection.UpdateOne(database.Ctx, filter, bson.M{
 		"$set": bson.M{
 			"status":    models.OrderDelivered,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order status"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, h.orderCollection, objectID, &models.Order{}, "Order")
+	}
 
 	return c.JSON(fiber.Map{"message": "Order marked as delivered"})
 }
+
+// listOrders returns one page of orders matching filter, newest first unless
+// the client asks otherwise, with product details populated
+func (h *OrdersHandler) listOrders(c *fiber.Ctx, filter bson.M) error {
+	page, err := pagination.ParseList(c, orderSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.orderCollection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch orders"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var orders []models.Order
+	if err = cursor.All(database.Ctx, &orders); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode orders"})
+	}
+
+	orders, links, err := pagination.Finish(page, orders)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	// Populate product details for each order
+	for i := range orders {
+		for j := range orders[i].Items {
+			var product models.Product
+			err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": orders[i].Items[j].ProductID}).Decode(&product)
+			if err == nil {
+				orders[i].Items[j].Product = &product
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"orders":     orders,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
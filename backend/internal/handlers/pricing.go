@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/models"
+)
+
+type PricingHandler struct {
+	productCollection  *mongo.Collection
+	historyCollection  *mongo.Collection
+	scheduleCollection *mongo.Collection
+}
+
+func NewPricingHandler() *PricingHandler {
+	return &PricingHandler{
+		productCollection:  database.Database.Collection("products"),
+		historyCollection:  database.Database.Collection("price_history"),
+		scheduleCollection: database.Database.Collection("price_schedules"),
+	}
+}
+
+func (h *PricingHandler) SetSale(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var req models.SetSaleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Price < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Sale price must not be negative"})
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return c.Status(400).JSON(fiber.Map{"error": "Sale must end after it starts"})
+	}
+
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var product models.Product
+	err = h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if expected != nil && product.Version != *expected {
+		return versionConflict(c, product)
+	}
+
+	sale := models.Sale{
+		Price:          req.Price,
+		CompareAtPrice: req.CompareAtPrice,
+		StartsAt:       req.StartsAt,
+		EndsAt:         req.EndsAt,
+	}
+	if sale.CompareAtPrice == 0 {
+		sale.CompareAtPrice = product.Price
+	}
+	if sale.Price >= sale.CompareAtPrice {
+		return c.Status(400).JSON(fiber.Map{"error": "Sale price must be below the compare-at price"})
+	}
+
+	oldPrice := product.PriceAt(time.Now())
+	product.Sale = &sale
+	product.EffectivePrice = product.PriceAt(time.Now())
+	product.UpdatedAt = time.Now()
+
+	var updated models.Product
+	err = h.productCollection.FindOneAndUpdate(database.Ctx, matchVersion(bson.M{"_id": productID}, expected), bson.M{
+		"$set": bson.M{
+			"sale":           sale,
+			"effectivePrice": product.EffectivePrice,
+			"updatedAt":      product.UpdatedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Modified or deleted since it was read
+			return staleWrite(c, h.productCollection, productID, &models.Product{}, "Product")
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set sale"})
+	}
+	bumpCatalog()
+
+	recordPriceChange(models.PriceHistory{
+		ProductID: productID,
+		Kind:      models.PriceChangeSaleStart,
+		OldPrice:  oldPrice,
+		NewPrice:  sale.Price,
+		Sale:      &sale,
+		Source:    "admin",
+		ChangedBy: currentUserID(c),
+	})
+
+	return c.JSON(updated)
+}
+
+func (h *PricingHandler) RemoveSale(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var product models.Product
+	err = h.productCollection.FindOneAndUpdate(database.Ctx,
+		matchVersion(bson.M{"_id": productID, "sale": bson.M{"$exists": true}}, expected),
+		bson.A{
+			bson.M{"$set": bson.M{
+				"effectivePrice": "$price",
+				"updatedAt":      time.Now(),
+				"version":        bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+			}},
+			bson.M{"$unset": "sale"},
+		},
+	).Decode(&product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to remove sale"})
+		}
+		// Either the product has no sale, or it was modified or deleted
+		// since the client read it
+		var current models.Product
+		err = h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID}).Decode(&current)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+		}
+		if expected != nil && current.Version != *expected {
+			return versionConflict(c, current)
+		}
+		return c.Status(404).JSON(fiber.Map{"error": "Product has no sale"})
+	}
+	bumpCatalog()
+
+	recordPriceChange(models.PriceHistory{
+		ProductID: productID,
+		Kind:      models.PriceChangeSaleEnd,
+		OldPrice:  product.PriceAt(time.Now()),
+		NewPrice:  product.Price,
+		Sale:      product.Sale,
+		Source:    "admin",
+		ChangedBy: currentUserID(c),
+	})
+
+	return c.JSON(fiber.Map{"message": "Sale removed"})
+}
+
+func (h *PricingHandler) SchedulePrice(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var req models.SchedulePriceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Price < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Price must not be negative"})
+	}
+	if !req.EffectiveAt.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "effectiveAt must be in the future"})
+	}
+
+	count, err := h.productCollection.CountDocuments(database.Ctx, bson.M{"_id": productID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if count == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	schedule := models.PriceSchedule{
+		ID:          primitive.NewObjectID(),
+		ProductID:   productID,
+		Price:       req.Price,
+		EffectiveAt: req.EffectiveAt,
+		Status:      models.PriceSchedulePending,
+		CreatedAt:   time.Now(),
+	}
+	if userID := currentUserID(c); userID != nil {
+		schedule.CreatedBy = *userID
+	}
+
+	if _, err := h.scheduleCollection.InsertOne(database.Ctx, schedule); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to schedule price change"})
+	}
+
+	return c.Status(201).JSON(schedule)
+}
+
+func (h *PricingHandler) GetPriceSchedules(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	filter := bson.M{"productId": productID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := h.scheduleCollection.Find(database.Ctx, filter, options.Find().SetSort(bson.M{"effectiveAt": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price schedules"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	schedules := []models.PriceSchedule{}
+	if err = cursor.All(database.Ctx, &schedules); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode price schedules"})
+	}
+
+	return c.JSON(schedules)
+}
+
+func (h *PricingHandler) CancelPriceSchedule(c *fiber.Ctx) error {
+	scheduleID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid schedule ID"})
+	}
+
+	result, err := h.scheduleCollection.UpdateOne(database.Ctx,
+		bson.M{"_id": scheduleID, "status": models.PriceSchedulePending},
+		bson.M{"$set": bson.M{"status": models.PriceScheduleCancelled}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel price schedule"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Pending price schedule not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Price schedule cancelled"})
+}
+
+func (h *PricingHandler) GetPriceHistory(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	filter := bson.M{"productId": productID}
+	changedAt := bson.M{}
+	if from, err := time.Parse(time.RFC3339, c.Query("from")); err == nil {
+		changedAt["$gte"] = from
+	}
+	if to, err := time.Parse(time.RFC3339, c.Query("to")); err == nil {
+		changedAt["$lt"] = to
+	}
+	if len(changedAt) > 0 {
+		filter["changedAt"] = changedAt
+	}
+
+	cursor, err := h.historyCollection.Find(database.Ctx, filter, options.Find().SetSort(bson.M{"changedAt": -1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price history"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	history := []models.PriceHistory{}
+	if err = cursor.All(database.Ctx, &history); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode price history"})
+	}
+
+	return c.JSON(history)
+}
+
+// ApplyDuePrices applies pending price schedules whose time has come and
+// returns how many were applied
+func (h *PricingHandler) ApplyDuePrices() (int, error) {
+	cursor, err := h.scheduleCollection.Find(database.Ctx, bson.M{
+		"status":      models.PriceSchedulePending,
+		"effectiveAt": bson.M{"$lte": time.Now()},
+	}, options.Find().SetSort(bson.M{"effectiveAt": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var schedules []models.PriceSchedule
+	if err = cursor.All(database.Ctx, &schedules); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, schedule := range schedules {
+		now := time.Now()
+
+		// Claim the schedule first so concurrent runs never apply it twice
+		result, err := h.scheduleCollection.UpdateOne(database.Ctx,
+			bson.M{"_id": schedule.ID, "status": models.PriceSchedulePending},
+			bson.M{"$set": bson.M{"status": models.PriceScheduleApplied, "appliedAt": now}},
+		)
+		if err != nil {
+			return applied, err
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+
+		// From here on the claim is handed back when the price is not
+		// written, so the next run tries again
+		var product models.Product
+		err = h.productCollection.FindOne(database.Ctx, bson.M{"_id": schedule.ProductID}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			log.Printf("Price schedule %s failed: product %s is gone", schedule.ID.Hex(), schedule.ProductID.Hex())
+			h.settleSchedule(schedule.ID, models.PriceScheduleFailed)
+			continue
+		}
+		if err != nil {
+			h.settleSchedule(schedule.ID, models.PriceSchedulePending)
+			return applied, err
+		}
+
+		oldPrice := product.Price
+		product.Price = schedule.Price
+
+		written, err := h.productCollection.UpdateOne(database.Ctx, bson.M{"_id": product.ID}, bson.M{
+			"$set": bson.M{
+				"price":          product.Price,
+				"effectivePrice": product.PriceAt(now),
+				"updatedAt":      now,
+			},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			h.settleSchedule(schedule.ID, models.PriceSchedulePending)
+			return applied, err
+		}
+		if written.MatchedCount == 0 {
+			log.Printf("Price schedule %s failed: product %s is gone", schedule.ID.Hex(), schedule.ProductID.Hex())
+			h.settleSchedule(schedule.ID, models.PriceScheduleFailed)
+			continue
+		}
+		bumpCatalog()
+
+		recordPriceChange(models.PriceHistory{
+			ProductID: product.ID,
+			Kind:      models.PriceChangeRegular,
+			OldPrice:  oldPrice,
+			NewPrice:  product.Price,
+			Source:    "schedule",
+			ChangedBy: &schedule.CreatedBy,
+		})
+		applied++
+	}
+
+	return applied, nil
+}
+
+// settleSchedule moves a claimed schedule whose price was not written to
+// status: back to pending to be retried, or to failed
+func (h *PricingHandler) settleSchedule(id primitive.ObjectID, status models.PriceScheduleStatus) {
+	_, err := h.scheduleCollection.UpdateOne(database.Ctx,
+		bson.M{"_id": id, "status": models.PriceScheduleApplied},
+		bson.M{"$set": bson.M{"status": status}, "$unset": bson.M{"appliedAt": ""}},
+	)
+	if err != nil {
+		log.Printf("Failed to release price schedule %s: %v", id.Hex(), err)
+	}
+}
+
+// RefreshEffectivePrices brings the stored effectivePrice in line with
+// sales that started or ended since the last run, and drops expired sales
+func (h *PricingHandler) RefreshEffectivePrices() (int, error) {
+	now := time.Now()
+
+	// Products created before sale pricing existed have no effectivePrice yet
+	backfilled, err := h.productCollection.UpdateMany(database.Ctx,
+		bson.M{"effectivePrice": bson.M{"$exists": false}},
+		bson.A{bson.M{"$set": bson.M{
+			"effectivePrice": "$price",
+			"version":        bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+		}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if backfilled.ModifiedCount > 0 {
+		bumpCatalog()
+	}
+
+	cursor, err := h.productCollection.Find(database.Ctx, bson.M{"sale": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, product := range products {
+		price := product.PriceAt(now)
+		expired := product.Sale.EndsAt != nil && !now.Before(*product.Sale.EndsAt)
+
+		if price == product.EffectivePrice && !expired {
+			continue
+		}
+
+		update := bson.M{
+			"$set": bson.M{"effectivePrice": price, "updatedAt": now},
+			"$inc": bson.M{"version": 1},
+		}
+		if expired {
+			update["$unset"] = bson.M{"sale": ""}
+		}
+		if _, err := h.productCollection.UpdateOne(database.Ctx, bson.M{"_id": product.ID}, update); err != nil {
+			return updated, err
+		}
+		bumpCatalog()
+
+		if expired {
+			recordPriceChange(models.PriceHistory{
+				ProductID: product.ID,
+				Kind:      models.PriceChangeSaleEnd,
+				OldPrice:  product.Sale.Price,
+				NewPrice:  product.Price,
+				Sale:      product.Sale,
+				Source:    "schedule",
+			})
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// recordPriceChange appends an entry to the price history. Failures are
+// logged rather than returned so they never block the price change itself.
+func recordPriceChange(entry models.PriceHistory) {
+	entry.ID = primitive.NewObjectID()
+	entry.ChangedAt = time.Now()
+
+	_, err := database.Database.Collection("price_history").InsertOne(database.Ctx, entry)
+	if err != nil {
+		log.Printf("Failed to record price change for product %s: %v", entry.ProductID.Hex(), err)
+	}
+}
+
+func currentUserID(c *fiber.Ctx) *primitive.ObjectID {
+	userID, ok := c.Locals("userId").(string)
+	if !ok {
+		return nil
+	}
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil
+	}
+	return &objectID
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+// A due schedule changes its product's price and is marked applied; one
+// whose product is gone is marked failed rather than applied
+func TestApplyDuePrices(t *testing.T) {
+	requireMongo(t)
+	h := NewPricingHandler()
+
+	productID := insertProduct(t, 5)
+	due := time.Now().Add(-time.Minute)
+	applied := models.PriceSchedule{ID: primitive.NewObjectID(), ProductID: productID, Price: 7.5, EffectiveAt: due, Status: models.PriceSchedulePending}
+	orphaned := models.PriceSchedule{ID: primitive.NewObjectID(), ProductID: primitive.NewObjectID(), Price: 3, EffectiveAt: due, Status: models.PriceSchedulePending}
+	if _, err := h.scheduleCollection.InsertMany(database.Ctx, []interface{}{applied, orphaned}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ApplyDuePrices(); err != nil {
+		t.Fatalf("ApplyDuePrices: %v", err)
+	}
+
+	var product models.Product
+	if err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		t.Fatal(err)
+	}
+	if product.Price != 7.5 || product.EffectivePrice != 7.5 {
+		t.Errorf("price = %v, effective %v, want 7.5", product.Price, product.EffectivePrice)
+	}
+
+	for id, want := range map[primitive.ObjectID]models.PriceScheduleStatus{
+		applied.ID:  models.PriceScheduleApplied,
+		orphaned.ID: models.PriceScheduleFailed,
+	} {
+		var schedule models.PriceSchedule
+		if err := h.scheduleCollection.FindOne(database.Ctx, bson.M{"_id": id}).Decode(&schedule); err != nil {
+			t.Fatal(err)
+		}
+		if schedule.Status != want {
+			t.Errorf("schedule %s: status %q, want %q", id.Hex(), schedule.Status, want)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
-	"strconv"
+	"log"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,30 +13,119 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"ecom-backend/internal/models"
+	"ecom-backend/internal/attributes"
 	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/pagination"
+	"ecom-backend/internal/slug"
 )
 
+const categoryCacheTTL = 5 * time.Minute
+
 type ProductsHandler struct {
 	collection *mongo.Collection
+	images     *ImagesHandler
+	inventory  *InventoryHandler
+	restock    *RestockHandler
+	schemas    *CategorySchemasHandler
+	catalog    *CategoriesHandler
+	categories categoryCache
 }
 
-func NewProductsHandler() *ProductsHandler {
+func NewProductsHandler(images *ImagesHandler, inventory *InventoryHandler, restock *RestockHandler, schemas *CategorySchemasHandler, catalog *CategoriesHandler) *ProductsHandler {
 	return &ProductsHandler{
 		collection: database.Database.Collection("products"),
+		images:     images,
+		inventory:  inventory,
+		restock:    restock,
+		schemas:    schemas,
+		catalog:    catalog,
+	}
+}
+
+// categoryCache keeps the distinct category list between product writes.
+// It is reloaded when the catalog generation moves, so writes through
+// other instances invalidate it too; the TTL is a backstop.
+type categoryCache struct {
+	mu         sync.Mutex
+	values     []interface{}
+	catalog    int64
+	expiresAt  time.Time
+	generation int
+}
+
+func (cc *categoryCache) get(catalog int64, load func() ([]interface{}, error)) ([]interface{}, error) {
+	cc.mu.Lock()
+	if cc.values != nil && cc.catalog == catalog && time.Now().Before(cc.expiresAt) {
+		values := cc.values
+		cc.mu.Unlock()
+		return values, nil
+	}
+	generation := cc.generation
+	cc.mu.Unlock()
+
+	values, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't cache a result that raced with a product write
+	cc.mu.Lock()
+	if cc.generation == generation {
+		cc.values = values
+		cc.catalog = catalog
+		cc.expiresAt = time.Now().Add(categoryCacheTTL)
+	}
+	cc.mu.Unlock()
+
+	return values, nil
+}
+
+func (cc *categoryCache) invalidate() {
+	cc.mu.Lock()
+	cc.values = nil
+	cc.generation++
+	cc.mu.Unlock()
+}
+
+// productSorts are the catalog sort keys, each backed by an index in
+// database.EnsureIndexes. Price sorts on what customers actually pay,
+// including active sales.
+var productSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"updatedAt": pagination.TimeKey("updatedAt"),
+	"title":     pagination.StringKey("title").ThenBy("price"),
+	"price":     pagination.NumberKey("effectivePrice").ThenBy("rating", "title"),
+	"rating":    pagination.NumberKey("rating.average").ThenBy("price", "createdAt"),
+}
+
+var adminProductSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"updatedAt": pagination.TimeKey("updatedAt"),
+	"title":     pagination.StringKey("title").ThenBy("price"),
+	"price":     pagination.NumberKey("effectivePrice").ThenBy("rating", "title"),
+	"rating":    pagination.NumberKey("rating.average").ThenBy("price", "createdAt"),
+	"stock":     pagination.NumberKey("stock").ThenBy("title"),
+}
+
+var newestFirst = []pagination.SortField{{Field: "createdAt", Desc: true}}
+
+// visibleProductFilter matches products customers can browse and buy.
+// Products created before statuses existed have no status and count as active.
+func visibleProductFilter() bson.M {
+	return bson.M{
+		"deletedAt": nil,
+		"status":    bson.M{"$in": []interface{}{models.ProductActive, nil}},
 	}
 }
 
 func (h *ProductsHandler) GetProducts(c *fiber.Ctx) error {
 	// Parse query parameters
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "10"))
 	category := c.Query("category")
 	search := c.Query("search")
-	sortBy := c.Query("sortBy", "createdAt")
-	sortOrder := c.Query("sortOrder", "desc")
 
 	// Build filter
-	filter := bson.M{}
+	filter := visibleProductFilter()
 	if category != "" {
 		filter["category"] = category
 	}
@@ -45,24 +136,41 @@ func (h *ProductsHandler) GetProducts(c *fiber.Ctx) error {
 		}
 	}
 
-	// Build sort
-	sort := bson.M{}
-	if sortOrder == "asc" {
-		sort[sortBy] = 1
-	} else {
-		sort[sortBy] = -1
+	// attr.<key> filters are typed by the category's schema
+	if query := c.Queries(); attributes.HasFilters(query) {
+		if category == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Attribute filters require a category"})
+		}
+		defs, err := h.schemas.Definitions(category)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch category schema"})
+		}
+		conditions, err := attributes.Filter(defs, query)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid attribute filter", "attributes": err})
+		}
+		for field, condition := range conditions {
+			filter[field] = condition
+		}
+	}
+
+	page, err := pagination.ParseList(c, productSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
 	}
 
-	// Calculate skip
-	skip := (page - 1) * limit
+	// Every product write moves the catalog generation, so a client holding
+	// this URL's tag for the current generation already has the page
+	generation, err := catalogGeneration()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+	if httpcache.NotModified(c, httpcache.ETag(generation, c.OriginalURL()), time.Time{}) {
+		return c.SendStatus(304)
+	}
 
 	// Find products
-	opts := options.Find().
-		SetSort(sort).
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit))
-
-	cursor, err := h.collection.Find(database.Ctx, filter, opts)
+	cursor, err := h.collection.Find(database.Ctx, page.Where(filter), page.FindOptions())
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
 	}
@@ -73,6 +181,11 @@ func (h *ProductsHandler) GetProducts(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode products"})
 	}
 
+	products, links, err := pagination.Finish(page, products)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
 	// Get total count
 	total, err := h.collection.CountDocuments(database.Ctx, filter)
 	if err != nil {
@@ -80,7 +193,9 @@ func (h *ProductsHandler) GetProducts(c *fiber.Ctx) error {
 	}
 
 	// Get categories for filtering
-	categories, err := h.collection.Distinct(database.Ctx, "category", bson.M{})
+	categories, err := h.categories.get(generation, func() ([]interface{}, error) {
+		return h.collection.Distinct(database.Ctx, "category", visibleProductFilter())
+	})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
 	}
@@ -88,8 +203,10 @@ func (h *ProductsHandler) GetProducts(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"products":   products,
 		"total":      total,
-		"page":       page,
-		"limit":      limit,
+		"page":       page.Number(),
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
 		"categories": categories,
 	})
 }
@@ -102,7 +219,7 @@ func (h *ProductsHandler) GetProduct(c *fiber.Ctx) error {
 	}
 
 	var product models.Product
-	err = h.collection.FindOne(database.Ctx, bson.M{"_id": objectID}).Decode(&product)
+	err = h.collection.FindOne(database.Ctx, linkableProductFilter(bson.M{"_id": objectID})).Decode(&product)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
@@ -110,31 +227,174 @@ func (h *ProductsHandler) GetProduct(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
 	}
 
+	return sendProduct(c, product)
+}
+
+// GetProductBySlug resolves a storefront product URL. A slug the product
+// used to have answers with a permanent redirect to the current one.
+func (h *ProductsHandler) GetProductBySlug(c *fiber.Ctx) error {
+	productSlug := c.Params("slug")
+
+	var product models.Product
+	err := h.collection.FindOne(database.Ctx, linkableProductFilter(bson.M{"slug": productSlug})).Decode(&product)
+	if err == nil {
+		return sendProduct(c, product)
+	}
+	if err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+
+	targetID, ok, err := resolveSlugRedirect(models.SlugProduct, productSlug)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if ok {
+		err = h.collection.FindOne(database.Ctx, linkableProductFilter(bson.M{"_id": targetID})).Decode(&product)
+		if err == nil && product.Slug != "" {
+			c.Location("/api/products/by-slug/" + url.PathEscape(product.Slug))
+			return c.Status(301).JSON(fiber.Map{"id": product.ID, "slug": product.Slug})
+		}
+	}
+	return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+}
+
+// linkableProductFilter matches products that can be opened by direct link.
+// Archived products stay reachable, e.g. from order history.
+func linkableProductFilter(filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	filter["status"] = bson.M{"$ne": models.ProductDraft}
+	return filter
+}
+
+func sendProduct(c *fiber.Ctx, product models.Product) error {
+	// Every change to a product moves its version, prices and stock included
+	if httpcache.NotModified(c, httpcache.VersionETag(product.Version), product.UpdatedAt) {
+		return c.SendStatus(304)
+	}
+
 	return c.JSON(product)
 }
 
+// GetAllProducts lists products for admins, including drafts, archived and
+// soft-deleted products
+func (h *ProductsHandler) GetAllProducts(c *fiber.Ctx) error {
+	status := c.Query("status")
+	deleted := c.Query("deleted", "false")
+
+	filter := bson.M{}
+	if status != "" {
+		if !models.ProductStatus(status).Valid() {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid product status"})
+		}
+		filter["status"] = status
+	}
+	switch deleted {
+	case "true":
+		filter["deletedAt"] = bson.M{"$ne": nil}
+	case "false":
+		filter["deletedAt"] = nil
+	case "all":
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "deleted must be true, false or all"})
+	}
+
+	page, err := pagination.ParseList(c, adminProductSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.collection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode products"})
+	}
+
+	products, links, err := pagination.Finish(page, products)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	total, err := h.collection.CountDocuments(database.Ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count products"})
+	}
+
+	return c.JSON(fiber.Map{
+		"products":   products,
+		"total":      total,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
+
 func (h *ProductsHandler) CreateProduct(c *fiber.Ctx) error {
 	var req models.CreateProductRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	if req.Status == "" {
+		req.Status = models.ProductActive
+	}
+	if !req.Status.Valid() {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product status"})
+	}
+	if req.ReorderThreshold != nil && *req.ReorderThreshold < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Reorder threshold must not be negative"})
+	}
+	attrs, status, body := h.schemas.validateProductAttributes(req.Category, req.Attributes)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+
+	// Slugs are generated from the title unless the admin picks one
+	if req.Slug != "" && !slug.Valid(req.Slug) {
+		return c.Status(400).JSON(fiber.Map{"error": "Slug must be lowercase letters, digits and single hyphens"})
+	}
+	productSlug := req.Slug
+	if productSlug == "" {
+		var err error
+		if productSlug, err = uniqueSlug(h.collection, req.Title, "product", primitive.NilObjectID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
+		}
+	}
+
 	product := models.Product{
-		ID:          primitive.NewObjectID(),
-		Title:       req.Title,
-		Description: req.Description,
-		Price:       req.Price,
-		Stock:       req.Stock,
-		Images:      req.Images,
-		Category:    req.Category,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		ID:               primitive.NewObjectID(),
+		Title:            req.Title,
+		Slug:             productSlug,
+		Description:      req.Description,
+		MetaTitle:        req.MetaTitle,
+		MetaDescription:  req.MetaDescription,
+		Price:            req.Price,
+		EffectivePrice:   req.Price,
+		Stock:            req.Stock,
+		Images:           req.Images,
+		Category:         req.Category,
+		Attributes:       attrs,
+		Status:           req.Status,
+		ReorderThreshold: req.ReorderThreshold,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
 	}
 
 	_, err := h.collection.InsertOne(database.Ctx, product)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "Slug already in use"})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
 	}
+	h.categories.invalidate()
+	bumpCatalog()
+	h.afterWrite("", product)
+	h.inventory.Recheck(product.ID)
 
 	return c.Status(201).JSON(product)
 }
@@ -151,18 +411,69 @@ func (h *ProductsHandler) UpdateProduct(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var existing models.Product
+	err = h.collection.FindOne(database.Ctx, bson.M{"_id": objectID}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if expected != nil && existing.Version != *expected {
+		return versionConflict(c, existing)
+	}
+
 	update := bson.M{"updatedAt": time.Now()}
 	if req.Title != nil {
 		update["title"] = *req.Title
 	}
+	if req.Slug != nil {
+		if !slug.Valid(*req.Slug) {
+			return c.Status(400).JSON(fiber.Map{"error": "Slug must be lowercase letters, digits and single hyphens"})
+		}
+		update["slug"] = *req.Slug
+	}
 	if req.Description != nil {
 		update["description"] = *req.Description
 	}
+	if req.MetaTitle != nil {
+		update["metaTitle"] = *req.MetaTitle
+	}
+	if req.MetaDescription != nil {
+		update["metaDescription"] = *req.MetaDescription
+	}
 	if req.Price != nil {
-		update["price"] = *req.Price
+		if *req.Price < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Price must not be negative"})
+		}
+		repriced := existing
+		repriced.Price = *req.Price
+		update["price"] = repriced.Price
+		update["effectivePrice"] = repriced.PriceAt(time.Now())
 	}
+	// Stock moves by the difference from existing, read earlier in this
+	// request, instead of being overwritten. Units taken by orders or
+	// reservations between that read and this write stay taken, so the
+	// stored count can end up below what the admin entered; the version
+	// check only catches other admin edits, not stock movements. It may
+	// not go below zero.
+	filter := matchVersion(bson.M{"_id": objectID}, expected)
+	inc := bson.M{"version": 1}
 	if req.Stock != nil {
-		update["stock"] = *req.Stock
+		if *req.Stock < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Stock must not be negative"})
+		}
+		if delta := *req.Stock - existing.Stock; delta != 0 {
+			inc["stock"] = delta
+			if delta < 0 {
+				filter["stock"] = bson.M{"$gte": -delta}
+			}
+		}
 	}
 	if req.Images != nil {
 		update["images"] = req.Images
@@ -170,11 +481,76 @@ func (h *ProductsHandler) UpdateProduct(c *fiber.Ctx) error {
 	if req.Category != nil {
 		update["category"] = *req.Category
 	}
+	if req.Status != nil {
+		if !req.Status.Valid() {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid product status"})
+		}
+		update["status"] = *req.Status
+	}
+	if req.ReorderThreshold != nil {
+		if *req.ReorderThreshold < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Reorder threshold must not be negative"})
+		}
+		update["reorderThreshold"] = *req.ReorderThreshold
+	}
+	// Attributes replace the whole set. A category change revalidates the
+	// current attributes against the new category's schema.
+	if req.Attributes != nil || (req.Category != nil && *req.Category != existing.Category) {
+		category, values := existing.Category, existing.Attributes
+		if req.Category != nil {
+			category = *req.Category
+		}
+		if req.Attributes != nil {
+			values = req.Attributes
+		}
+		attrs, status, body := h.schemas.validateProductAttributes(category, values)
+		if status != 0 {
+			return c.Status(status).JSON(body)
+		}
+		update["attributes"] = attrs
+	}
 
-	_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	result, err := h.collection.UpdateOne(database.Ctx, filter, bson.M{
+		"$set": update,
+		"$inc": inc,
+	})
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "Slug already in use"})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product"})
 	}
+	if result.MatchedCount == 0 {
+		// Lost a race with another write after the version check above, or
+		// the stock left is less than the reduction
+		return staleWrite(c, h.collection, objectID, &models.Product{}, "Product")
+	}
+	h.categories.invalidate()
+	bumpCatalog()
+	if req.Stock != nil || req.ReorderThreshold != nil || req.Status != nil {
+		h.inventory.Recheck(objectID)
+	}
+	if req.Stock != nil && existing.Stock <= 0 && *req.Stock > 0 {
+		h.restock.NotifyRestockedAsync(objectID)
+	}
+
+	if req.Price != nil && *req.Price != existing.Price {
+		recordPriceChange(models.PriceHistory{
+			ProductID: objectID,
+			Kind:      models.PriceChangeRegular,
+			OldPrice:  existing.Price,
+			NewPrice:  *req.Price,
+			Source:    "admin",
+			ChangedBy: currentUserID(c),
+		})
+	}
+
+	// Drop uploaded images the admin removed from the list
+	if req.Images != nil {
+		if err := h.images.PruneProductImages(objectID, req.Images); err != nil {
+			log.Printf("Failed to prune images for product %s: %v", objectID.Hex(), err)
+		}
+	}
 
 	// Return updated product
 	var product models.Product
@@ -182,10 +558,62 @@ func (h *ProductsHandler) UpdateProduct(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated product"})
 	}
+	h.afterWrite(existing.Slug, product)
 
 	return c.JSON(product)
 }
 
+// afterWrite keeps slug redirects and category records in step with a
+// created or updated product. Failures only cost an old link or a category
+// slug, so they are logged rather than failing the write.
+func (h *ProductsHandler) afterWrite(oldSlug string, product models.Product) {
+	if product.Slug != oldSlug {
+		if err := recordSlugChange(models.SlugProduct, oldSlug, product.Slug, product.ID); err != nil {
+			log.Printf("Failed to record slug change for product %s: %v", product.ID.Hex(), err)
+		}
+	}
+	if err := h.catalog.Ensure(product.Category); err != nil {
+		log.Printf("Failed to create category %q: %v", product.Category, err)
+	}
+}
+
+// BackfillSlugs gives products created before slugs existed one derived
+// from their title. It only fills missing slugs, so it is safe on every start.
+func (h *ProductsHandler) BackfillSlugs() (int, error) {
+	cursor, err := h.collection.Find(database.Ctx, bson.M{"slug": nil}, options.Find().SetProjection(bson.M{"title": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return 0, err
+	}
+
+	filled := 0
+	for _, product := range products {
+		productSlug, err := uniqueSlug(h.collection, product.Title, "product", product.ID)
+		if err != nil {
+			return filled, err
+		}
+		// The slug is part of the product, so the version moves with it
+		_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": product.ID, "slug": nil}, bson.M{
+			"$set": bson.M{"slug": productSlug, "updatedAt": time.Now()},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			log.Printf("Failed to backfill slug for product %s: %v", product.ID.Hex(), err)
+			continue
+		}
+		filled++
+	}
+	if filled > 0 {
+		bumpCatalog()
+	}
+	return filled, nil
+}
+
+// DeleteProduct soft-deletes a product. It disappears from the catalog but
+// stays resolvable for order history until the purge job removes it.
 func (h *ProductsHandler) DeleteProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -193,10 +621,144 @@ func (h *ProductsHandler) DeleteProduct(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
 	}
 
-	_, err = h.collection.DeleteOne(database.Ctx, bson.M{"_id": objectID})
+	now := time.Now()
+	result, err := h.collection.UpdateOne(database.Ctx, bson.M{"_id": objectID, "deletedAt": nil}, bson.M{
+		"$set": bson.M{
+			"deletedAt": now,
+			"updatedAt": now,
+		},
+		"$inc": bson.M{"version": 1},
+	})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete product"})
 	}
+	if result.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	h.categories.invalidate()
+	bumpCatalog()
+	h.inventory.Recheck(objectID)
 
 	return c.JSON(fiber.Map{"message": "Product deleted successfully"})
 }
+
+func (h *ProductsHandler) RestoreProduct(c *fiber.Ctx) error {
+	id := c.Params("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filter := matchVersion(bson.M{"_id": objectID, "deletedAt": bson.M{"$ne": nil}}, expected)
+	result, err := h.collection.UpdateOne(database.Ctx, filter, bson.M{
+		"$unset": bson.M{"deletedAt": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$inc":   bson.M{"version": 1},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore product"})
+	}
+	if result.MatchedCount == 0 {
+		if expected != nil {
+			return staleWrite(c, h.collection, objectID, &models.Product{}, "Product")
+		}
+		return c.Status(404).JSON(fiber.Map{"error": "Deleted product not found"})
+	}
+	h.categories.invalidate()
+	bumpCatalog()
+	h.inventory.Recheck(objectID)
+
+	var product models.Product
+	err = h.collection.FindOne(database.Ctx, bson.M{"_id": objectID}).Decode(&product)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch restored product"})
+	}
+
+	return c.JSON(product)
+}
+
+// PurgeDeletedProducts permanently removes products that were soft-deleted
+// before the cutoff and are not referenced by any order, along with what
+// refers to them: cart, saved and wishlist lines, reviews, pricing records,
+// associations, alerts, subscriptions and images. It returns the number of
+// products removed.
+func (h *ProductsHandler) PurgeDeletedProducts(olderThan time.Duration) (int, error) {
+	orderCollection := database.Database.Collection("orders")
+
+	cursor, err := h.collection.Find(database.Ctx, bson.M{
+		"deletedAt": bson.M{"$lt": time.Now().Add(-olderThan)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, product := range products {
+		referenced, err := orderCollection.CountDocuments(database.Ctx, bson.M{"items.productId": product.ID}, options.Count().SetLimit(1))
+		if err != nil {
+			return purged, err
+		}
+		if referenced > 0 {
+			continue
+		}
+
+		result, err := h.collection.DeleteOne(database.Ctx, bson.M{"_id": product.ID, "deletedAt": bson.M{"$ne": nil}})
+		if err != nil {
+			return purged, err
+		}
+		if result.DeletedCount == 0 {
+			continue // restored in the meantime
+		}
+
+		// Lines holding the product, in carts, saved lists and wishlists
+		line := bson.M{"productId": product.ID}
+		for _, name := range []string{"carts", "guest_carts"} {
+			_, err = database.Database.Collection(name).UpdateMany(database.Ctx,
+				bson.M{"$or": bson.A{bson.M{"items.productId": product.ID}, bson.M{"savedForLater.productId": product.ID}}},
+				bson.M{"$pull": bson.M{"items": line, "savedForLater": line}},
+			)
+			if err != nil {
+				log.Printf("Failed to remove purged product %s from %s: %v", product.ID.Hex(), name, err)
+			}
+		}
+		_, err = database.Database.Collection("wishlists").UpdateMany(database.Ctx, bson.M{"items.productId": product.ID}, bson.M{
+			"$pull": bson.M{"items": line},
+		})
+		if err != nil {
+			log.Printf("Failed to remove purged product %s from wishlists: %v", product.ID.Hex(), err)
+		}
+
+		// Records that only describe the product
+		for name, filter := range map[string]bson.M{
+			"slug_redirects":        {"kind": models.SlugProduct, "targetId": product.ID},
+			"restock_subscriptions": {"productId": product.ID},
+			"reviews":               {"productId": product.ID},
+			"product_associations":  {"$or": bson.A{bson.M{"productId": product.ID}, bson.M{"relatedId": product.ID}}},
+			"price_schedules":       {"productId": product.ID},
+			"price_history":         {"productId": product.ID},
+			"stock_alerts":          {"productId": product.ID},
+		} {
+			if _, err := database.Database.Collection(name).DeleteMany(database.Ctx, filter); err != nil {
+				log.Printf("Failed to delete %s for product %s: %v", name, product.ID.Hex(), err)
+			}
+		}
+
+		if err := h.images.DeleteProductImages(product.ID); err != nil {
+			log.Printf("Failed to delete images for product %s: %v", product.ID.Hex(), err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
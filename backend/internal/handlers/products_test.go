@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/storage"
+)
+
+// Purging a product leaves nothing behind that refers to it, and leaves
+// other products' records alone
+func TestPurgeDeletedProductsCleansUp(t *testing.T) {
+	requireMongo(t)
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewProductsHandler(NewImagesHandler(store, 1<<20), nil, nil, nil, nil)
+
+	purged, kept := insertProduct(t, 5), insertProduct(t, 5)
+	_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": purged}, bson.M{
+		"$set": bson.M{"deletedAt": time.Now().Add(-48 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []models.CartItem{{ProductID: purged, Quantity: 1}, {ProductID: kept, Quantity: 1}}
+	userCartID, guestCartID := primitive.NewObjectID(), primitive.NewObjectID()
+	seed := map[string][]interface{}{
+		"carts":                 {models.Cart{ID: userCartID, UserID: primitive.NewObjectID(), Items: lines, SavedForLater: lines}},
+		"guest_carts":           {models.Cart{ID: guestCartID, Items: lines, SavedForLater: lines}},
+		"reviews":               {bson.M{"productId": purged, "userId": primitive.NewObjectID()}, bson.M{"productId": kept, "userId": primitive.NewObjectID()}},
+		"product_associations":  {bson.M{"productId": purged, "relatedId": kept}, bson.M{"productId": kept, "relatedId": purged}},
+		"price_schedules":       {bson.M{"productId": purged}, bson.M{"productId": kept}},
+		"price_history":         {bson.M{"productId": purged}, bson.M{"productId": kept}},
+		"stock_alerts":          {bson.M{"productId": purged, "kind": "low", "open": true}, bson.M{"productId": kept, "kind": "low", "open": true}},
+		"restock_subscriptions": {bson.M{"productId": purged, "userId": primitive.NewObjectID(), "token": primitive.NewObjectID().Hex()}},
+	}
+	for name, docs := range seed {
+		if _, err := database.Database.Collection(name).InsertMany(database.Ctx, docs); err != nil {
+			t.Fatalf("seeding %s: %v", name, err)
+		}
+	}
+
+	if _, err := h.PurgeDeletedProducts(24 * time.Hour); err != nil {
+		t.Fatalf("PurgeDeletedProducts: %v", err)
+	}
+	if count, _ := h.collection.CountDocuments(database.Ctx, bson.M{"_id": purged}); count != 0 {
+		t.Fatal("product was not purged")
+	}
+
+	for name := range seed {
+		if name == "carts" || name == "guest_carts" {
+			continue
+		}
+		filter := bson.M{"$or": bson.A{bson.M{"productId": purged}, bson.M{"relatedId": purged}}}
+		if count, _ := database.Database.Collection(name).CountDocuments(database.Ctx, filter); count != 0 {
+			t.Errorf("%s: %d records left for the purged product", name, count)
+		}
+		if name == "restock_subscriptions" || name == "product_associations" {
+			continue
+		}
+		if count, _ := database.Database.Collection(name).CountDocuments(database.Ctx, bson.M{"productId": kept}); count != 1 {
+			t.Errorf("%s: %d records for the other product, want 1", name, count)
+		}
+	}
+
+	for name, id := range map[string]primitive.ObjectID{"carts": userCartID, "guest_carts": guestCartID} {
+		var cart models.Cart
+		if err := database.Database.Collection(name).FindOne(database.Ctx, bson.M{"_id": id}).Decode(&cart); err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		for list, items := range map[string][]models.CartItem{"items": cart.Items, "savedForLater": cart.SavedForLater} {
+			if len(items) != 1 || items[0].ProductID != kept {
+				t.Errorf("%s %s = %+v, want only the other product", name, list, items)
+			}
+		}
+	}
+}
+
+// A conditional catalog request is answered from the catalog generation,
+// and any product write makes the next one fetch the page again
+func TestGetProductsNotModified(t *testing.T) {
+	requireMongo(t)
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewProductsHandler(NewImagesHandler(store, 1<<20), nil, nil, nil, nil)
+	app := fiber.New()
+	app.Get("/products", h.GetProducts)
+
+	get := func(etag string) (int, string) {
+		req := httptest.NewRequest("GET", "/products?limit=5", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Header.Get("ETag")
+	}
+
+	insertProduct(t, 5)
+	bumpCatalog()
+	status, etag := get("")
+	if status != 200 || etag == "" {
+		t.Fatalf("first GET: status %d, ETag %q", status, etag)
+	}
+	if status, _ := get(etag); status != 304 {
+		t.Errorf("unchanged catalog: status %d, want 304", status)
+	}
+
+	// Any product write moves the generation
+	reviews := &ReviewsHandler{productCollection: h.collection, reviewCollection: database.Database.Collection("reviews")}
+	if err := reviews.refreshRating(insertProduct(t, 5)); err != nil {
+		t.Fatalf("refreshRating: %v", err)
+	}
+	status, next := get(etag)
+	if status != 200 || next == etag {
+		t.Errorf("after a product write: status %d, ETag %q (was %q), want 200 and a new tag", status, next, etag)
+	}
+}
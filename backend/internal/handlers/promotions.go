@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pagination"
+)
+
+// generatedCouponPrefix starts the codes of coupons issued by the system,
+// such as those in cart reminders, and is reserved for them
+const generatedCouponPrefix = "CART-"
+
+var promotionSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"name":      pagination.StringKey("name"),
+}
+
+type PromotionsHandler struct {
+	collection           *mongo.Collection
+	usageCollection      *mongo.Collection
+	redemptionCollection *mongo.Collection
+}
+
+func NewPromotionsHandler() *PromotionsHandler {
+	return &PromotionsHandler{
+		collection:           database.Database.Collection("promotions"),
+		usageCollection:      database.Database.Collection("promotion_usage"),
+		redemptionCollection: database.Database.Collection("promotion_redemptions"),
+	}
+}
+
+// promotionUnavailable aborts an order whose promotion was used up by
+// other orders after the cart was priced
+type promotionUnavailable struct {
+	name string
+}
+
+func (e *promotionUnavailable) Error() string {
+	return "promotion no longer available: " + e.name
+}
+
+// normalizeCoupon makes coupon codes case-insensitive
+func normalizeCoupon(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// GetPromotions lists the promotions admins created. Single-use coupons
+// issued by the system pile up quickly, so they are only listed on their
+// own, with ?generated=true.
+func (h *PromotionsHandler) GetPromotions(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if active := c.Query("active"); active != "" {
+		filter["active"] = active == "true"
+	}
+	generated := bson.M{"$regex": "^" + generatedCouponPrefix}
+	if c.Query("generated") == "true" {
+		filter["code"] = generated
+	} else {
+		filter["code"] = bson.M{"$not": generated}
+	}
+
+	page, err := pagination.ParseList(c, promotionSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.collection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	promotions := []models.Promotion{}
+	if err = cursor.All(database.Ctx, &promotions); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode promotions"})
+	}
+
+	promotions, links, err := pagination.Finish(page, promotions)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	return c.JSON(fiber.Map{
+		"promotions": promotions,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
+
+func (h *PromotionsHandler) GetPromotion(c *fiber.Ctx) error {
+	promotionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid promotion ID"})
+	}
+
+	var promotion models.Promotion
+	err = h.collection.FindOne(database.Ctx, bson.M{"_id": promotionID}).Decode(&promotion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotion"})
+	}
+
+	c.Set(fiber.HeaderETag, httpcache.VersionETag(promotion.Version))
+	return c.JSON(promotion)
+}
+
+func (h *PromotionsHandler) CreatePromotion(c *fiber.Ctx) error {
+	var req models.PromotionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := validatePromotion(&req); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	promotion := promotionFromRequest(&req)
+	promotion.ID = primitive.NewObjectID()
+	promotion.CreatedAt = time.Now()
+	promotion.UpdatedAt = promotion.CreatedAt
+
+	_, err := h.collection.InsertOne(database.Ctx, promotion)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "Coupon code already in use"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create promotion"})
+	}
+
+	return c.Status(201).JSON(promotion)
+}
+
+// UpdatePromotion replaces a promotion's settings; its redemption count is
+// kept
+func (h *PromotionsHandler) UpdatePromotion(c *fiber.Ctx) error {
+	promotionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid promotion ID"})
+	}
+
+	var req models.PromotionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := validatePromotion(&req); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	promotion := promotionFromRequest(&req)
+	set := bson.M{
+		"name":             promotion.Name,
+		"type":             promotion.Type,
+		"value":            promotion.Value,
+		"minSubtotal":      promotion.MinSubtotal,
+		"stackable":        promotion.Stackable,
+		"usageLimit":       promotion.UsageLimit,
+		"perCustomerLimit": promotion.PerCustomerLimit,
+		"active":           promotion.Active,
+		"updatedAt":        time.Now(),
+	}
+	// Optional settings left out of the request are removed
+	unset := bson.M{}
+	setOrUnset := func(field string, value interface{}, present bool) {
+		if present {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+	setOrUnset("code", promotion.Code, promotion.Code != "")
+	setOrUnset("buyQuantity", promotion.BuyQuantity, promotion.BuyQuantity > 0)
+	setOrUnset("getQuantity", promotion.GetQuantity, promotion.GetQuantity > 0)
+	setOrUnset("categories", promotion.Categories, len(promotion.Categories) > 0)
+	setOrUnset("startsAt", promotion.StartsAt, promotion.StartsAt != nil)
+	setOrUnset("endsAt", promotion.EndsAt, promotion.EndsAt != nil)
+
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated models.Promotion
+	err = h.collection.FindOneAndUpdate(database.Ctx,
+		matchVersion(bson.M{"_id": promotionID}, expected),
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return staleWrite(c, h.collection, promotionID, &models.Promotion{}, "Promotion")
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "Coupon code already in use"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update promotion"})
+	}
+
+	return c.JSON(updated)
+}
+
+// DeletePromotion removes a promotion. Orders keep the discounts they were
+// given, and its redemption records stay for reporting.
+func (h *PromotionsHandler) DeletePromotion(c *fiber.Ctx) error {
+	promotionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid promotion ID"})
+	}
+
+	result, err := h.collection.DeleteOne(database.Ctx, bson.M{"_id": promotionID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete promotion"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+	}
+
+	h.usageCollection.DeleteMany(database.Ctx, bson.M{"promotionId": promotionID})
+
+	return c.JSON(fiber.Map{"message": "Promotion deleted successfully"})
+}
+
+func (h *PromotionsHandler) GetRedemptions(c *fiber.Ctx) error {
+	promotionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid promotion ID"})
+	}
+
+	cursor, err := h.redemptionCollection.Find(database.Ctx,
+		bson.M{"promotionId": promotionID},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch redemptions"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	redemptions := []models.PromotionRedemption{}
+	if err = cursor.All(database.Ctx, &redemptions); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode redemptions"})
+	}
+
+	return c.JSON(redemptions)
+}
+
+func validatePromotion(req *models.PromotionRequest) string {
+	if strings.TrimSpace(req.Name) == "" {
+		return "Name is required"
+	}
+	switch req.Type {
+	case models.PromotionPercentage:
+		if req.Value <= 0 || req.Value > 100 {
+			return "Percentage must be between 0 and 100"
+		}
+	case models.PromotionFixed:
+		if req.Value <= 0 {
+			return "Amount must be positive"
+		}
+	case models.PromotionBuyXGetY:
+		if req.BuyQuantity <= 0 || req.GetQuantity <= 0 {
+			return "Buy and get quantities must be positive"
+		}
+	case models.PromotionFreeShipping:
+	default:
+		return "Type must be percentage, fixed, buy_x_get_y or free_shipping"
+	}
+	if req.MinSubtotal < 0 || req.UsageLimit < 0 || req.PerCustomerLimit < 0 {
+		return "Limits must not be negative"
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return "Promotion must end after it starts"
+	}
+	if strings.HasPrefix(normalizeCoupon(req.Code), generatedCouponPrefix) {
+		return "Coupon codes starting with " + generatedCouponPrefix + " are reserved"
+	}
+	return ""
+}
+
+func promotionFromRequest(req *models.PromotionRequest) models.Promotion {
+	promotion := models.Promotion{
+		Name:             strings.TrimSpace(req.Name),
+		Code:             normalizeCoupon(req.Code),
+		Type:             req.Type,
+		Value:            req.Value,
+		MinSubtotal:      req.MinSubtotal,
+		Stackable:        req.Stackable,
+		UsageLimit:       req.UsageLimit,
+		PerCustomerLimit: req.PerCustomerLimit,
+		StartsAt:         req.StartsAt,
+		EndsAt:           req.EndsAt,
+		Active:           req.Active == nil || *req.Active,
+	}
+	for _, category := range req.Categories {
+		if category = strings.TrimSpace(category); category != "" {
+			promotion.Categories = append(promotion.Categories, category)
+		}
+	}
+	if req.Type == models.PromotionBuyXGetY {
+		promotion.BuyQuantity = req.BuyQuantity
+		promotion.GetQuantity = req.GetQuantity
+	}
+	return promotion
+}
+
+// issueCoupon creates a single-use percentage coupon with a generated code,
+// valid until expires
+func (h *PromotionsHandler) issueCoupon(name string, percent float64, expires time.Time) (*models.Promotion, error) {
+	for attempt := 0; ; attempt++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		promotion := models.Promotion{
+			ID:               primitive.NewObjectID(),
+			Name:             name,
+			Code:             generatedCouponPrefix + strings.ToUpper(hex.EncodeToString(buf)),
+			Type:             models.PromotionPercentage,
+			Value:            percent,
+			UsageLimit:       1,
+			PerCustomerLimit: 1,
+			EndsAt:           &expires,
+			Active:           true,
+			CreatedAt:        now,
+			UpdatedAt:        now,
+		}
+		_, err := h.collection.InsertOne(database.Ctx, promotion)
+		if err == nil {
+			return &promotion, nil
+		}
+		// Generated codes rarely collide; try another
+		if !mongo.IsDuplicateKeyError(err) || attempt == 2 {
+			return nil, err
+		}
+	}
+}
+
+// Applicable returns the promotions a cart may use at the given time: every
+// running automatic promotion plus the coupons among codes, leaving out
+// those used up overall or, when userID is known, by that customer
+func (h *PromotionsHandler) Applicable(codes []string, userID *primitive.ObjectID, at time.Time) ([]models.Promotion, error) {
+	if codes == nil {
+		codes = []string{}
+	}
+	cursor, err := h.collection.Find(database.Ctx, bson.M{
+		"active": true,
+		"$or": bson.A{
+			bson.M{"code": bson.M{"$exists": false}},
+			bson.M{"code": bson.M{"$in": codes}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Promotion
+	if err := cursor.All(database.Ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	used := map[primitive.ObjectID]int{}
+	if userID != nil && len(candidates) > 0 {
+		ids := make([]primitive.ObjectID, len(candidates))
+		for i, promotion := range candidates {
+			ids[i] = promotion.ID
+		}
+		cursor, err := h.usageCollection.Find(database.Ctx, bson.M{"userId": *userID, "promotionId": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, err
+		}
+		var usage []models.PromotionUsage
+		if err := cursor.All(database.Ctx, &usage); err != nil {
+			return nil, err
+		}
+		for _, u := range usage {
+			used[u.PromotionID] = u.Count
+		}
+	}
+
+	var promotions []models.Promotion
+	for _, promotion := range candidates {
+		if !promotion.RunningAt(at) || promotion.UsedUp() {
+			continue
+		}
+		if promotion.PerCustomerLimit > 0 && used[promotion.ID] >= promotion.PerCustomerLimit {
+			continue
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, nil
+}
+
+// checkCoupon looks up a coupon code a customer wants to apply and explains
+// why it cannot be used. userID is nil for guests, whose per-customer limit
+// is checked when they order.
+func (h *PromotionsHandler) checkCoupon(code string, userID *primitive.ObjectID) (*models.Promotion, *cartError) {
+	var promotion models.Promotion
+	err := h.collection.FindOne(database.Ctx, bson.M{"code": code}).Decode(&promotion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &cartError{404, "Coupon not found"}
+		}
+		return nil, &cartError{500, "Failed to fetch coupon"}
+	}
+
+	now := time.Now()
+	switch {
+	case !promotion.Active:
+		return nil, &cartError{400, "Coupon is not active"}
+	case promotion.StartsAt != nil && now.Before(*promotion.StartsAt):
+		return nil, &cartError{400, "Coupon is not valid yet"}
+	case promotion.EndsAt != nil && !now.Before(*promotion.EndsAt):
+		return nil, &cartError{400, "Coupon has expired"}
+	case promotion.UsedUp():
+		return nil, &cartError{400, "Coupon has been fully redeemed"}
+	}
+
+	if userID != nil && promotion.PerCustomerLimit > 0 {
+		var usage models.PromotionUsage
+		err := h.usageCollection.FindOne(database.Ctx, bson.M{"promotionId": promotion.ID, "userId": *userID}).Decode(&usage)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, &cartError{500, "Failed to fetch coupon"}
+		}
+		if usage.Count >= promotion.PerCustomerLimit {
+			return nil, &cartError{400, "You have already used this coupon"}
+		}
+	}
+
+	return &promotion, nil
+}
+
+// redeem records the promotions an order used, inside the order's
+// transaction. Each counter only moves while it is below its limit, so
+// concurrent orders cannot redeem a promotion past its limits; the loser
+// gets a promotionUnavailable error and its transaction is aborted.
+func (h *PromotionsHandler) redeem(ctx mongo.SessionContext, promotions []models.Promotion, discounts []models.PriceAdjustment, userID, orderID primitive.ObjectID) error {
+	byID := map[primitive.ObjectID]models.Promotion{}
+	for _, promotion := range promotions {
+		byID[promotion.ID] = promotion
+	}
+
+	for _, discount := range discounts {
+		promotion, ok := byID[discount.PromotionID]
+		if !ok {
+			continue
+		}
+
+		result, err := h.collection.UpdateOne(ctx,
+			bson.M{
+				"_id": promotion.ID,
+				"$or": bson.A{
+					bson.M{"usageLimit": 0},
+					bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$usageLimit"}}},
+				},
+			},
+			bson.M{"$inc": bson.M{"redemptions": 1}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return &promotionUnavailable{promotion.Name}
+		}
+
+		// A customer at their limit fails the filter, and the upsert then
+		// collides with their existing usage document
+		usageFilter := bson.M{"promotionId": promotion.ID, "userId": userID}
+		if promotion.PerCustomerLimit > 0 {
+			usageFilter["count"] = bson.M{"$lt": promotion.PerCustomerLimit}
+		}
+		_, err = h.usageCollection.UpdateOne(ctx, usageFilter,
+			bson.M{"$inc": bson.M{"count": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return &promotionUnavailable{promotion.Name}
+			}
+			return err
+		}
+
+		_, err = h.redemptionCollection.InsertOne(ctx, models.PromotionRedemption{
+			ID:          primitive.NewObjectID(),
+			PromotionID: promotion.ID,
+			Code:        promotion.Code,
+			UserID:      userID,
+			OrderID:     orderID,
+			Amount:      discount.Amount,
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("record redemption of %s: %w", promotion.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+// Coupons issued in cart reminders stay out of the admin's promotion list
+// and are listed on their own when asked for
+func TestGetPromotionsSeparatesGeneratedCoupons(t *testing.T) {
+	requireMongo(t)
+	h := NewPromotionsHandler()
+	app := fiber.New()
+	app.Get("/api/promotions", h.GetPromotions)
+
+	if _, err := h.collection.DeleteMany(database.Ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	_, err := h.collection.InsertMany(database.Ctx, []interface{}{
+		models.Promotion{Name: "Summer sale", Code: "SUMMER", Type: models.PromotionPercentage, Value: 10, Active: true, CreatedAt: now},
+		models.Promotion{Name: "Storewide", Type: models.PromotionFreeShipping, Active: true, CreatedAt: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coupon, err := h.issueCoupon("Cart reminder", 5, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("issueCoupon: %v", err)
+	}
+
+	list := func(query string) []string {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/promotions"+query, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Promotions []models.Promotion `json:"promotions"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("GET %s: status %d: %v", query, resp.StatusCode, err)
+		}
+		names := []string{}
+		for _, promotion := range body.Promotions {
+			names = append(names, promotion.Name)
+		}
+		return names
+	}
+
+	if got := list(""); len(got) != 2 || got[0] == coupon.Name || got[1] == coupon.Name {
+		t.Errorf("promotions = %v, want the two created by admins", got)
+	}
+	if got := list("?generated=true"); len(got) != 1 || got[0] != coupon.Name {
+		t.Errorf("generated promotions = %v, want [%s]", got, coupon.Name)
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+const (
+	// coPurchaseWindow limits the statistics to recent orders so they
+	// follow what customers buy now
+	coPurchaseWindow = 180 * 24 * time.Hour
+
+	// maxAssociations is how many co-purchased products are kept per product
+	maxAssociations = 20
+
+	defaultRecommendations = 4
+	maxRecommendations     = 20
+)
+
+type RecommendationsHandler struct {
+	associationCollection *mongo.Collection
+	orderCollection       *mongo.Collection
+	productCollection     *mongo.Collection
+}
+
+func NewRecommendationsHandler() *RecommendationsHandler {
+	return &RecommendationsHandler{
+		associationCollection: database.Database.Collection("product_associations"),
+		orderCollection:       database.Database.Collection("orders"),
+		productCollection:     database.Database.Collection("products"),
+	}
+}
+
+// Compute rebuilds the co-purchase statistics from recent orders: for every
+// pair of products bought together it counts the orders containing both,
+// keeping each product's top pairs. It returns the number of pairs stored.
+func (h *RecommendationsHandler) Compute() (int, error) {
+	computedAt := time.Now()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":    bson.M{"$ne": models.OrderCancelled},
+			"createdAt": bson.M{"$gte": computedAt.Add(-coPurchaseWindow)},
+		}}},
+		// Each product counts once per order however many lines it has
+		{{Key: "$project", Value: bson.M{"ids": bson.M{"$setUnion": bson.A{"$items.productId", bson.A{}}}}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+		{{Key: "$project", Value: bson.M{"a": "$ids", "b": "$ids"}}},
+		{{Key: "$unwind", Value: "$a"}},
+		{{Key: "$unwind", Value: "$b"}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$ne": bson.A{"$a", "$b"}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"productId": "$a", "relatedId": "$b"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.productId", Value: 1}, {Key: "count", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$_id.productId",
+			"related": bson.M{"$push": bson.M{"relatedId": "$_id.relatedId", "count": "$count"}},
+		}}},
+		{{Key: "$project", Value: bson.M{"related": bson.M{"$slice": bson.A{"$related", maxAssociations}}}}},
+		{{Key: "$unwind", Value: "$related"}},
+		{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"productId":  "$_id",
+			"relatedId":  "$related.relatedId",
+			"count":      "$related.count",
+			"computedAt": computedAt,
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           "product_associations",
+			"on":             bson.A{"productId", "relatedId"},
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}}},
+	}
+
+	cursor, err := h.orderCollection.Aggregate(database.Ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	cursor.Close(database.Ctx)
+
+	// Pairs the run did not touch no longer occur in recent orders
+	if _, err := h.associationCollection.DeleteMany(database.Ctx, bson.M{"computedAt": bson.M{"$lt": computedAt}}); err != nil {
+		return 0, err
+	}
+	count, err := h.associationCollection.CountDocuments(database.Ctx, bson.M{})
+	return int(count), err
+}
+
+// GetBoughtTogether lists in-stock products most often ordered with this
+// one, topped up with products from its category
+func (h *RecommendationsHandler) GetBoughtTogether(c *fiber.Ctx) error {
+	return h.respond(c, false)
+}
+
+// GetRelated lists in-stock products from the same category, putting those
+// often ordered with this one first
+func (h *RecommendationsHandler) GetRelated(c *fiber.Ctx) error {
+	return h.respond(c, true)
+}
+
+func (h *RecommendationsHandler) respond(c *fiber.Ctx, sameCategory bool) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	limit := c.QueryInt("limit", defaultRecommendations)
+	if limit < 1 || limit > maxRecommendations {
+		return c.Status(400).JSON(fiber.Map{"error": "limit must be between 1 and 20"})
+	}
+
+	var product models.Product
+	err = h.productCollection.FindOne(database.Ctx, linkableProductFilter(bson.M{"_id": productID})).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+
+	products, err := h.recommend(product, sameCategory, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch recommendations"})
+	}
+
+	return c.JSON(fiber.Map{"products": products})
+}
+
+func (h *RecommendationsHandler) recommend(product models.Product, sameCategory bool, limit int) ([]models.Product, error) {
+	cursor, err := h.associationCollection.Find(database.Ctx,
+		bson.M{"productId": product.ID},
+		options.Find().SetSort(bson.M{"count": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var associations []models.ProductAssociation
+	if err = cursor.All(database.Ctx, &associations); err != nil {
+		return nil, err
+	}
+
+	ranked := make([]primitive.ObjectID, len(associations))
+	for i, association := range associations {
+		ranked[i] = association.RelatedID
+	}
+
+	filter := recommendableFilter()
+	filter["_id"] = bson.M{"$in": ranked}
+	if sameCategory {
+		filter["category"] = product.Category
+	}
+	cursor, err = h.productCollection.Find(database.Ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Product
+	if err = cursor.All(database.Ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	// Restore the co-purchase ranking Find does not preserve
+	byID := map[primitive.ObjectID]models.Product{}
+	for _, candidate := range candidates {
+		byID[candidate.ID] = candidate
+	}
+	products := []models.Product{}
+	exclude := []primitive.ObjectID{product.ID}
+	for _, id := range ranked {
+		if candidate, ok := byID[id]; ok && len(products) < limit {
+			products = append(products, candidate)
+			exclude = append(exclude, id)
+		}
+	}
+	if len(products) == limit {
+		return products, nil
+	}
+
+	// Fall back to the category's best rated products
+	filter = recommendableFilter()
+	filter["_id"] = bson.M{"$nin": exclude}
+	filter["category"] = product.Category
+	cursor, err = h.productCollection.Find(database.Ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "rating.average", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit-len(products))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var fallback []models.Product
+	if err = cursor.All(database.Ctx, &fallback); err != nil {
+		return nil, err
+	}
+	return append(products, fallback...), nil
+}
+
+// recommendableFilter matches catalog products a customer can buy now
+func recommendableFilter() bson.M {
+	filter := visibleProductFilter()
+	filter["stock"] = bson.M{"$gt": 0}
+	return filter
+}
@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pagination"
+)
+
+var stockSorts = pagination.Sortable{
+	"reserved": pagination.NumberKey("reserved").ThenBy("stock", "title"),
+	"stock":    pagination.NumberKey("stock").ThenBy("title"),
+	"title":    pagination.StringKey("title"),
+}
+
+type ReservationsHandler struct {
+	collection        *mongo.Collection
+	productCollection *mongo.Collection
+	cartCollection    *mongo.Collection
+	inventory         *InventoryHandler
+	ttl               time.Duration
+}
+
+// NewReservationsHandler holds reserved stock for ttl before it is
+// released again
+func NewReservationsHandler(inventory *InventoryHandler, ttl time.Duration) *ReservationsHandler {
+	return &ReservationsHandler{
+		collection:        database.Database.Collection("stock_reservations"),
+		productCollection: database.Database.Collection("products"),
+		cartCollection:    database.Database.Collection("carts"),
+		inventory:         inventory,
+		ttl:               ttl,
+	}
+}
+
+// stockShortage aborts a transaction that tried to take more of a product
+// than is in stock
+type stockShortage struct {
+	productID primitive.ObjectID
+}
+
+func (e *stockShortage) Error() string {
+	return "insufficient stock for product " + e.productID.Hex()
+}
+
+// takeStock moves quantity units of a product from stock to reserved,
+// or out of stock entirely when reserve is false. It only succeeds while
+// enough stock is left.
+func (h *ReservationsHandler) takeStock(ctx mongo.SessionContext, productID primitive.ObjectID, quantity int, reserve bool) error {
+	inc := bson.M{"stock": -quantity, "version": 1}
+	if reserve {
+		inc["reserved"] = quantity
+	}
+	result, err := h.productCollection.UpdateOne(ctx,
+		bson.M{"_id": productID, "stock": bson.M{"$gte": quantity}},
+		bson.M{"$set": bson.M{"updatedAt": time.Now()}, "$inc": inc},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &stockShortage{productID}
+	}
+	return nil
+}
+
+// moveReserved takes quantity units off a product's reserved count, back
+// into stock when restock is set
+func (h *ReservationsHandler) moveReserved(ctx mongo.SessionContext, productID primitive.ObjectID, quantity int, restock bool) error {
+	inc := bson.M{"reserved": -quantity, "version": 1}
+	if restock {
+		inc["stock"] = quantity
+	}
+	_, err := h.productCollection.UpdateOne(ctx,
+		bson.M{"_id": productID},
+		bson.M{"$set": bson.M{"updatedAt": time.Now()}, "$inc": inc},
+	)
+	return err
+}
+
+// commit takes the stock for an order inside the order's transaction. Units
+// the customer reserved are used first and the rest are taken from stock;
+// reserved units the order does not use go back to stock. An order that
+// needs more than is left fails with a stockShortage error. A reservation
+// that expired but was not released yet is used the same way, so its units
+// are never left deducted from stock.
+func (h *ReservationsHandler) commit(ctx mongo.SessionContext, userID, orderID primitive.ObjectID, items []models.CartItem) error {
+	held := map[primitive.ObjectID]int{}
+	var reservation models.StockReservation
+	err := h.collection.FindOneAndUpdate(ctx,
+		bson.M{"userId": userID, "status": models.ReservationActive},
+		bson.M{"$set": bson.M{"status": models.ReservationCommitted, "orderId": orderID, "updatedAt": time.Now()}},
+	).Decode(&reservation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	for _, item := range reservation.Items {
+		held[item.ProductID] += item.Quantity
+	}
+
+	for _, item := range items {
+		covered := min(held[item.ProductID], item.Quantity)
+		if covered > 0 {
+			if err := h.moveReserved(ctx, item.ProductID, covered, false); err != nil {
+				return err
+			}
+			held[item.ProductID] -= covered
+		}
+		if rest := item.Quantity - covered; rest > 0 {
+			if err := h.takeStock(ctx, item.ProductID, rest, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for productID, quantity := range held {
+		if quantity > 0 {
+			if err := h.moveReserved(ctx, productID, quantity, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// heldBy returns the units per product the customer's active reservation
+// holds, which count as in stock for their own order
+func (h *ReservationsHandler) heldBy(userID primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	held := map[primitive.ObjectID]int{}
+	var reservation models.StockReservation
+	err := h.collection.FindOne(database.Ctx, bson.M{
+		"userId":    userID,
+		"status":    models.ReservationActive,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&reservation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	for _, item := range reservation.Items {
+		held[item.ProductID] += item.Quantity
+	}
+	return held, nil
+}
+
+// release returns the stock of the active reservation matching filter.
+// The status change claims the reservation, so a reservation released by
+// the worker and by its customer at once is only returned once.
+func (h *ReservationsHandler) release(filter bson.M) (*models.StockReservation, error) {
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(database.Ctx)
+
+	result, err := session.WithTransaction(database.Ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		filter["status"] = models.ReservationActive
+		var reservation models.StockReservation
+		err := h.collection.FindOneAndUpdate(ctx, filter,
+			bson.M{"$set": bson.M{"status": models.ReservationReleased, "updatedAt": time.Now()}},
+		).Decode(&reservation)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range reservation.Items {
+			if err := h.moveReserved(ctx, item.ProductID, item.Quantity, true); err != nil {
+				return nil, err
+			}
+		}
+		return &reservation, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := result.(*models.StockReservation)
+	bumpCatalog()
+	h.inventory.Recheck(reservedProducts(reservation.Items)...)
+	return reservation, nil
+}
+
+// ReleaseExpired returns the stock of reservations whose time ran out
+func (h *ReservationsHandler) ReleaseExpired() (int, error) {
+	return h.releaseExpired(bson.M{})
+}
+
+// releaseLapsed returns the stock of expired reservations holding any of
+// products. Reserving and checking out call it first, so units held by
+// abandoned checkouts come back even where the release job does not run.
+func (h *ReservationsHandler) releaseLapsed(products []primitive.ObjectID) error {
+	_, err := h.releaseExpired(bson.M{"items.productId": bson.M{"$in": products}})
+	return err
+}
+
+// releaseExpired releases the expired reservations matching filter
+func (h *ReservationsHandler) releaseExpired(filter bson.M) (int, error) {
+	filter["status"] = models.ReservationActive
+	filter["expiresAt"] = bson.M{"$lte": time.Now()}
+	cursor, err := h.collection.Find(database.Ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var expired []models.StockReservation
+	if err := cursor.All(database.Ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, reservation := range expired {
+		_, err := h.release(bson.M{"_id": reservation.ID})
+		if err == mongo.ErrNoDocuments {
+			continue // Committed or released in the meantime
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// Reserve holds the stock for the customer's cart while they pay,
+// replacing any reservation they already hold
+func (h *ReservationsHandler) Reserve(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var cart models.Cart
+	err = h.cartCollection.FindOne(database.Ctx, bson.M{"userId": userID}).Decode(&cart)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if len(cart.Items) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+	for _, item := range cart.Items {
+		if item.Quantity <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Quantity must be positive"})
+		}
+	}
+
+	if _, err := h.release(bson.M{"userId": userID}); err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to release previous reservation"})
+	}
+	if err := h.releaseLapsed(reservedProducts(cart.Items)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to release expired reservations"})
+	}
+
+	now := time.Now()
+	reservation := models.StockReservation{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Items:     cart.Items,
+		Status:    models.ReservationActive,
+		ExpiresAt: now.Add(h.ttl),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer session.EndSession(database.Ctx)
+
+	_, err = session.WithTransaction(database.Ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		for _, item := range reservation.Items {
+			if err := h.takeStock(ctx, item.ProductID, item.Quantity, true); err != nil {
+				return nil, err
+			}
+		}
+		_, err := h.collection.InsertOne(ctx, reservation)
+		return nil, err
+	})
+	if err != nil {
+		var shortage *stockShortage
+		if errors.As(err, &shortage) {
+			return c.Status(409).JSON(fiber.Map{
+				"error":    "Insufficient stock",
+				"problems": []models.CheckoutProblem{h.shortageProblem(shortage.productID, cart.Items)},
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reserve stock"})
+	}
+
+	bumpCatalog()
+	h.inventory.Recheck(reservedProducts(reservation.Items)...)
+	return c.Status(201).JSON(reservation)
+}
+
+// GetReservation returns the customer's active reservation
+func (h *ReservationsHandler) GetReservation(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var reservation models.StockReservation
+	err = h.collection.FindOne(database.Ctx, bson.M{
+		"userId":    userID,
+		"status":    models.ReservationActive,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&reservation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "No active reservation"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reservation"})
+	}
+
+	return c.JSON(reservation)
+}
+
+// ReleaseReservation gives up the customer's reservation, e.g. when they
+// leave checkout
+func (h *ReservationsHandler) ReleaseReservation(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if _, err := h.release(bson.M{"userId": userID}); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "No active reservation"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to release reservation"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Reservation released"})
+}
+
+// GetStockLevels lists available and reserved stock per product
+func (h *ReservationsHandler) GetStockLevels(c *fiber.Ctx) error {
+	filter := visibleProductFilter()
+	if c.Query("reserved") == "true" {
+		filter["reserved"] = bson.M{"$gt": 0}
+	}
+
+	page, err := pagination.ParseList(c, stockSorts, []pagination.SortField{{Field: "reserved", Desc: true}})
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.productCollection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var products []models.Product
+	if err = cursor.All(database.Ctx, &products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode products"})
+	}
+
+	products, links, err := pagination.Finish(page, products)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	levels := make([]models.StockLevel, len(products))
+	for i, product := range products {
+		levels[i] = models.StockLevel{
+			ProductID: product.ID,
+			Title:     product.Title,
+			Category:  product.Category,
+			Available: product.Stock,
+			Reserved:  product.Reserved,
+			OnHand:    product.Stock + product.Reserved,
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"products":   levels,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
+
+// shortageProblem describes a stock shortage found while taking stock
+func (h *ReservationsHandler) shortageProblem(productID primitive.ObjectID, items []models.CartItem) models.CheckoutProblem {
+	problem := models.CheckoutProblem{
+		ProductID: productID,
+		Code:      models.ProblemInsufficientStock,
+		Message:   "Insufficient stock for product: " + productID.Hex(),
+	}
+	for _, item := range items {
+		if item.ProductID == productID {
+			problem.Quantity += item.Quantity
+		}
+	}
+
+	var product models.Product
+	if err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID}).Decode(&product); err == nil {
+		available := max(product.Stock, 0)
+		problem.Available = &available
+		problem.Message = "Insufficient stock for product: " + product.Title
+		if available == 0 {
+			problem.Code = models.ProblemOutOfStock
+			problem.Message = product.Title + " is out of stock"
+		}
+	}
+	return problem
+}
+
+func reservedProducts(items []models.CartItem) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	return ids
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/feeds"
+	"ecom-backend/internal/mailer"
+	"ecom-backend/internal/models"
+)
+
+// restockBatchSize bounds how many subscribers are claimed and mailed at a
+// time, so a popular product cannot hold one huge batch in memory
+const restockBatchSize = 100
+
+type RestockHandler struct {
+	subscriptionCollection *mongo.Collection
+	productCollection      *mongo.Collection
+	userCollection         *mongo.Collection
+	mail                   mailer.Mailer
+	publicURL              string
+	frontendURL            string
+}
+
+// NewRestockHandler builds unsubscribe links from publicURL, the address of
+// this API, and product links from frontendURL when it is set
+func NewRestockHandler(mail mailer.Mailer, publicURL, frontendURL string) *RestockHandler {
+	return &RestockHandler{
+		subscriptionCollection: database.Database.Collection("restock_subscriptions"),
+		productCollection:      database.Database.Collection("products"),
+		userCollection:         database.Database.Collection("users"),
+		mail:                   mail,
+		publicURL:              strings.TrimRight(publicURL, "/"),
+		frontendURL:            strings.TrimRight(frontendURL, "/"),
+	}
+}
+
+// Subscribe asks to be told when an out-of-stock product is back.
+// Subscribing twice is harmless and re-arms a subscription that was
+// already notified.
+func (h *RestockHandler) Subscribe(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	userID := currentUserID(c)
+	if userID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	filter := visibleProductFilter()
+	filter["_id"] = productID
+	var product models.Product
+	if err := h.productCollection.FindOne(database.Ctx, filter).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	if product.Stock > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Product is in stock"})
+	}
+
+	var user models.User
+	if err := h.userCollection.FindOne(database.Ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create subscription"})
+	}
+
+	var subscription models.RestockSubscription
+	err = h.subscriptionCollection.FindOneAndUpdate(database.Ctx,
+		bson.M{"productId": productID, "userId": userID},
+		bson.M{
+			"$set":   bson.M{"email": user.Email},
+			"$unset": bson.M{"notifiedAt": ""},
+			"$setOnInsert": bson.M{
+				"token":     token,
+				"createdAt": time.Now(),
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&subscription)
+	if err != nil {
+		// A concurrent subscribe won the upsert; the subscription exists either way
+		if !mongo.IsDuplicateKeyError(err) {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create subscription"})
+		}
+		err = h.subscriptionCollection.FindOne(database.Ctx, bson.M{"productId": productID, "userId": userID}).Decode(&subscription)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch subscription"})
+		}
+	}
+
+	return c.JSON(subscription)
+}
+
+func (h *RestockHandler) Unsubscribe(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	userID := currentUserID(c)
+	if userID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	result, err := h.subscriptionCollection.DeleteOne(database.Ctx, bson.M{"productId": productID, "userId": userID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unsubscribe"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Unsubscribed"})
+}
+
+// unsubscribePage is what the unsubscribe link in restock emails opens.
+// Following the link only asks; the form's POST does the unsubscribing.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}<p>You will no longer be emailed about {{.Title}}.</p>
+{{else if .Title}}<p>Stop emailing you when {{.Title}} is back in stock?</p>
+<form method="post" action="unsubscribe?token={{.Token}}"><button type="submit">Unsubscribe</button></form>
+{{else}}<p>This unsubscribe link has expired or was already used.</p>
+{{end}}</body>
+</html>
+`))
+
+type unsubscribePageData struct {
+	Token string
+	Title string
+	Done  bool
+}
+
+func renderUnsubscribePage(c *fiber.Ctx, status int, data unsubscribePageData) error {
+	var page strings.Builder
+	if err := unsubscribePage.Execute(&page, data); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to render page"})
+	}
+	c.Type("html", "utf-8")
+	return c.Status(status).SendString(page.String())
+}
+
+// ConfirmUnsubscribe serves the unsubscribe link in restock emails, so it
+// needs no login. It only shows a confirmation form: link scanners and
+// prefetchers follow links with GET, and must not unsubscribe anyone.
+func (h *RestockHandler) ConfirmUnsubscribe(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return renderUnsubscribePage(c, 400, unsubscribePageData{})
+	}
+
+	var subscription models.RestockSubscription
+	err := h.subscriptionCollection.FindOne(database.Ctx, bson.M{"token": token}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return renderUnsubscribePage(c, 404, unsubscribePageData{})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch subscription"})
+	}
+
+	return renderUnsubscribePage(c, 200, unsubscribePageData{Token: token, Title: h.productTitle(subscription.ProductID)})
+}
+
+// UnsubscribeByToken removes the subscription behind an unsubscribe link.
+// It answers both the confirmation form and one-click unsubscribe requests
+// from mail clients (RFC 8058), which POST to the List-Unsubscribe URL.
+func (h *RestockHandler) UnsubscribeByToken(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return renderUnsubscribePage(c, 400, unsubscribePageData{})
+	}
+
+	var subscription models.RestockSubscription
+	err := h.subscriptionCollection.FindOneAndDelete(database.Ctx, bson.M{"token": token}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return renderUnsubscribePage(c, 404, unsubscribePageData{})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unsubscribe"})
+	}
+
+	return renderUnsubscribePage(c, 200, unsubscribePageData{Title: h.productTitle(subscription.ProductID), Done: true})
+}
+
+// productTitle names a subscription's product on the unsubscribe pages,
+// falling back to a generic name when it is gone
+func (h *RestockHandler) productTitle(productID primitive.ObjectID) string {
+	var product models.Product
+	err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID},
+		options.FindOne().SetProjection(bson.M{"title": 1}),
+	).Decode(&product)
+	if err != nil || product.Title == "" {
+		return "this product"
+	}
+	return product.Title
+}
+
+// NotifyRestocked mails every pending subscriber of a product that is back
+// in stock, a batch at a time. Each subscription is claimed before it is
+// mailed, so overlapping runs never mail anyone twice. It returns the
+// number of emails sent.
+func (h *RestockHandler) NotifyRestocked(productID primitive.ObjectID) (int, error) {
+	var product models.Product
+	sent := 0
+	for {
+		// Stop early if the product sold out again while mailing
+		if err := h.productCollection.FindOne(database.Ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+			return sent, err
+		}
+		if product.Stock <= 0 {
+			return sent, nil
+		}
+
+		batch, err := h.claimBatch(productID)
+		if err != nil {
+			return sent, err
+		}
+		if len(batch) == 0 {
+			return sent, nil
+		}
+
+		for i, subscription := range batch {
+			if err := h.sendRestockMail(product, subscription); err != nil {
+				// Release the unsent claims so NotifyPending retries them
+				h.release(batch[i:])
+				return sent, err
+			}
+			sent++
+		}
+
+		if len(batch) < restockBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// NotifyRestockedAsync runs NotifyRestocked in the background so the admin
+// edit that restocked the product does not wait on mail
+func (h *RestockHandler) NotifyRestockedAsync(productID primitive.ObjectID) {
+	go func() {
+		sent, err := h.NotifyRestocked(productID)
+		if err != nil {
+			log.Printf("Restock notifications for product %s failed: %v", productID.Hex(), err)
+		}
+		if sent > 0 {
+			log.Printf("Sent %d restock notifications for product %s", sent, productID.Hex())
+		}
+	}()
+}
+
+// NotifyPending mails the pending subscribers of every product that is in
+// stock, picking up mail that a failed or interrupted run left unsent. It
+// returns the number of emails sent.
+func (h *RestockHandler) NotifyPending() (int, error) {
+	productIDs, err := h.subscriptionCollection.Distinct(database.Ctx, "productId", bson.M{"notifiedAt": nil})
+	if err != nil || len(productIDs) == 0 {
+		return 0, err
+	}
+
+	filter := visibleProductFilter()
+	filter["_id"] = bson.M{"$in": productIDs}
+	filter["stock"] = bson.M{"$gt": 0}
+	cursor, err := h.productCollection.Find(database.Ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var restocked []models.Product
+	if err := cursor.All(database.Ctx, &restocked); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, product := range restocked {
+		n, err := h.NotifyRestocked(product.ID)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// claimBatch marks up to restockBatchSize pending subscriptions as notified
+// and returns the ones this call claimed
+func (h *RestockHandler) claimBatch(productID primitive.ObjectID) ([]models.RestockSubscription, error) {
+	cursor, err := h.subscriptionCollection.Find(database.Ctx,
+		bson.M{"productId": productID, "notifiedAt": nil},
+		options.Find().SetSort(bson.M{"createdAt": 1}).SetLimit(restockBatchSize).SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var pending []models.RestockSubscription
+	if err = cursor.All(database.Ctx, &pending); err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(pending))
+	for i, subscription := range pending {
+		ids[i] = subscription.ID
+	}
+
+	// Tag the claim so we can read back exactly what this call won when
+	// another run claims some of the same documents
+	claim := primitive.NewObjectID()
+	_, err = h.subscriptionCollection.UpdateMany(database.Ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "notifiedAt": nil},
+		bson.M{"$set": bson.M{"notifiedAt": time.Now(), "claim": claim}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = h.subscriptionCollection.Find(database.Ctx, bson.M{"claim": claim})
+	if err != nil {
+		return nil, err
+	}
+	var claimed []models.RestockSubscription
+	if err = cursor.All(database.Ctx, &claimed); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (h *RestockHandler) release(subscriptions []models.RestockSubscription) {
+	ids := make([]primitive.ObjectID, len(subscriptions))
+	for i, subscription := range subscriptions {
+		ids[i] = subscription.ID
+	}
+	_, err := h.subscriptionCollection.UpdateMany(database.Ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+		"$unset": bson.M{"notifiedAt": "", "claim": ""},
+	})
+	if err != nil {
+		log.Printf("Failed to release restock subscriptions: %v", err)
+	}
+}
+
+func (h *RestockHandler) sendRestockMail(product models.Product, subscription models.RestockSubscription) error {
+	unsubscribeURL := h.publicURL + "/api/restock/unsubscribe?token=" + url.QueryEscape(subscription.Token)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Good news: %s is back in stock.\n\n", product.Title)
+	if h.frontendURL != "" {
+		fmt.Fprintf(&body, "Get it here: %s\n\n", feeds.Site{StorefrontURL: h.frontendURL}.ProductURL(product))
+	}
+	fmt.Fprintf(&body, "You asked us to let you know once. To stop these emails, visit %s\n", unsubscribeURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	return h.mail.Send(ctx, mailer.Message{
+		To:      []string{subscription.Email},
+		Subject: product.Title + " is back in stock",
+		Body:    body.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
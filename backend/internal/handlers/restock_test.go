@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/mailer"
+)
+
+// Opening the unsubscribe link only asks; a link scanner following it
+// leaves the subscription in place. The POST, from the form or a mail
+// client's one-click unsubscribe, removes it.
+func TestUnsubscribeByTokenNeedsPost(t *testing.T) {
+	requireMongo(t)
+	h := NewRestockHandler(nil, "http://localhost", "")
+	app := fiber.New()
+	app.Get("/api/restock/unsubscribe", h.ConfirmUnsubscribe)
+	app.Post("/api/restock/unsubscribe", h.UnsubscribeByToken)
+
+	token := primitive.NewObjectID().Hex()
+	_, err := h.subscriptionCollection.InsertOne(database.Ctx, bson.M{
+		"productId": insertProduct(t, 0),
+		"userId":    primitive.NewObjectID(),
+		"token":     token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/restock/unsubscribe?token=" + token
+	remaining := func() int64 {
+		count, _ := h.subscriptionCollection.CountDocuments(database.Ctx, bson.M{"token": token})
+		return count
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("GET: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if remaining() != 1 {
+		t.Fatal("GET removed the subscription")
+	}
+
+	req := httptest.NewRequest("POST", path, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, err = app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("POST: status %d", resp.StatusCode)
+	}
+	if remaining() != 0 {
+		t.Error("POST left the subscription")
+	}
+	if status := send(t, app, "GET", path, nil, nil); status != 404 {
+		t.Errorf("GET after unsubscribing: status %d, want 404", status)
+	}
+}
+
+// Subscribers a failed or interrupted run left unmailed are picked up once
+// their product is in stock, and left alone while it is sold out
+func TestNotifyPending(t *testing.T) {
+	requireMongo(t)
+	h := NewRestockHandler(mailer.NewLogMailer(), "http://localhost", "")
+
+	subscribe := func(productID primitive.ObjectID) primitive.ObjectID {
+		id := primitive.NewObjectID()
+		_, err := h.subscriptionCollection.InsertOne(database.Ctx, bson.M{
+			"_id":       id,
+			"productId": productID,
+			"userId":    primitive.NewObjectID(),
+			"email":     "shopper@example.com",
+			"token":     id.Hex(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	notified := func(id primitive.ObjectID) bool {
+		count, _ := h.subscriptionCollection.CountDocuments(database.Ctx, bson.M{"_id": id, "notifiedAt": bson.M{"$ne": nil}})
+		return count == 1
+	}
+
+	restocked := subscribe(insertProduct(t, 3))
+	soldOut := subscribe(insertProduct(t, 0))
+
+	if _, err := h.NotifyPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !notified(restocked) {
+		t.Error("subscriber of a restocked product was not mailed")
+	}
+	if notified(soldOut) {
+		t.Error("subscriber of a sold out product was mailed")
+	}
+}
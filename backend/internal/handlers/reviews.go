@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pagination"
+)
+
+var reviewSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"helpful":   pagination.NumberKey("helpfulCount").ThenBy("createdAt"),
+	"rating":    pagination.NumberKey("rating").ThenBy("createdAt", "helpful"),
+}
+
+type ReviewsHandler struct {
+	reviewCollection  *mongo.Collection
+	productCollection *mongo.Collection
+	orderCollection   *mongo.Collection
+}
+
+func NewReviewsHandler() *ReviewsHandler {
+	return &ReviewsHandler{
+		reviewCollection:  database.Database.Collection("reviews"),
+		productCollection: database.Database.Collection("products"),
+		orderCollection:   database.Database.Collection("orders"),
+	}
+}
+
+func (h *ReviewsHandler) GetProductReviews(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	filter := bson.M{"productId": productID, "status": models.ReviewApproved}
+	if rating, err := strconv.Atoi(c.Query("rating")); err == nil && rating >= 1 && rating <= 5 {
+		filter["rating"] = rating
+	}
+
+	return h.listReviews(c, filter)
+}
+
+func (h *ReviewsHandler) CreateReview(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var req models.CreateReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Rating < 1 || req.Rating > 5 {
+		return c.Status(400).JSON(fiber.Map{"error": "Rating must be between 1 and 5"})
+	}
+
+	// Only customers who received the product may review it
+	delivered, err := h.orderCollection.CountDocuments(database.Ctx, bson.M{
+		"userId":          userID,
+		"status":          models.OrderDelivered,
+		"items.productId": productID,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify purchase"})
+	}
+	if delivered == 0 {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only review products from a delivered order"})
+	}
+
+	review := models.Review{
+		ID:            primitive.NewObjectID(),
+		ProductID:     productID,
+		UserID:        userID,
+		Rating:        req.Rating,
+		Title:         req.Title,
+		Body:          req.Body,
+		Status:        models.ReviewPending,
+		HelpfulVoters: []primitive.ObjectID{},
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	_, err = h.reviewCollection.InsertOne(database.Ctx, review)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "You have already reviewed this product"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create review"})
+	}
+
+	return c.Status(201).JSON(review)
+}
+
+func (h *ReviewsHandler) UpdateReview(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	reviewID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+	}
+
+	var req models.UpdateReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	// Edited reviews go back through moderation
+	update := bson.M{"status": models.ReviewPending, "updatedAt": time.Now()}
+	if req.Rating != nil {
+		if *req.Rating < 1 || *req.Rating > 5 {
+			return c.Status(400).JSON(fiber.Map{"error": "Rating must be between 1 and 5"})
+		}
+		update["rating"] = *req.Rating
+	}
+	if req.Title != nil {
+		update["title"] = *req.Title
+	}
+	if req.Body != nil {
+		update["body"] = *req.Body
+	}
+
+	var review models.Review
+	err = h.reviewCollection.FindOneAndUpdate(database.Ctx,
+		bson.M{"_id": reviewID, "userId": userID},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update review"})
+	}
+
+	if err := h.refreshRating(review.ProductID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product rating"})
+	}
+
+	return c.JSON(review)
+}
+
+func (h *ReviewsHandler) DeleteReview(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	reviewID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+	}
+
+	// Admins may delete any review, customers only their own
+	filter := bson.M{"_id": reviewID}
+	if c.Locals("userRole") != models.RoleAdmin {
+		filter["userId"] = userID
+	}
+
+	var review models.Review
+	err = h.reviewCollection.FindOneAndDelete(database.Ctx, filter).Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete review"})
+	}
+
+	if err := h.refreshRating(review.ProductID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product rating"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Review deleted successfully"})
+}
+
+func (h *ReviewsHandler) MarkHelpful(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userId").(string))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	reviewID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+	}
+
+	// The filter makes the vote idempotent and stops authors voting for themselves
+	result, err := h.reviewCollection.UpdateOne(database.Ctx, bson.M{
+		"_id":           reviewID,
+		"status":        models.ReviewApproved,
+		"userId":        bson.M{"$ne": userID},
+		"helpfulVoters": bson.M{"$ne": userID},
+	}, bson.M{
+		"$addToSet": bson.M{"helpfulVoters": userID},
+		"$inc":      bson.M{"helpfulCount": 1},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record vote"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Review not found or already voted"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Vote recorded"})
+}
+
+func (h *ReviewsHandler) GetAllReviews(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	return h.listReviews(c, filter)
+}
+
+func (h *ReviewsHandler) ModerateReview(c *fiber.Ctx) error {
+	reviewID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+	}
+
+	var req models.ModerateReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	switch req.Status {
+	case models.ReviewApproved, models.ReviewRejected, models.ReviewFlagged:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be approved, rejected or flagged"})
+	}
+
+	var review models.Review
+	err = h.reviewCollection.FindOneAndUpdate(database.Ctx,
+		bson.M{"_id": reviewID},
+		bson.M{"$set": bson.M{
+			"status":         req.Status,
+			"moderationNote": req.Note,
+			"updatedAt":      time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to moderate review"})
+	}
+
+	if err := h.refreshRating(review.ProductID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product rating"})
+	}
+
+	return c.JSON(review)
+}
+
+func (h *ReviewsHandler) listReviews(c *fiber.Ctx, filter bson.M) error {
+	page, err := pagination.ParseList(c, reviewSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.reviewCollection.Find(database.Ctx, page.Where(filter), page.FindOptions())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var reviews []models.Review
+	if err = cursor.All(database.Ctx, &reviews); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode reviews"})
+	}
+
+	reviews, links, err := pagination.Finish(page, reviews)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
+	total, err := h.reviewCollection.CountDocuments(database.Ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count reviews"})
+	}
+
+	return c.JSON(fiber.Map{
+		"reviews":    reviews,
+		"total":      total,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
+}
+
+// refreshRating recomputes the rating summary stored on the product from
+// its approved reviews
+func (h *ReviewsHandler) refreshRating(productID primitive.ObjectID) error {
+	cursor, err := h.reviewCollection.Aggregate(database.Ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"productId": productID, "status": models.ReviewApproved}}},
+		{{Key: "$group", Value: bson.M{"_id": "$rating", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(database.Ctx)
+
+	var buckets []struct {
+		Rating int `bson:"_id"`
+		Count  int `bson:"count"`
+	}
+	if err = cursor.All(database.Ctx, &buckets); err != nil {
+		return err
+	}
+
+	var summary models.RatingSummary
+	sum := 0
+	for _, b := range buckets {
+		if b.Rating < 1 || b.Rating > 5 {
+			continue
+		}
+		summary.Histogram[b.Rating-1] = b.Count
+		summary.Count += b.Count
+		sum += b.Rating * b.Count
+	}
+	if summary.Count > 0 {
+		summary.Average = float64(sum) / float64(summary.Count)
+	}
+
+	_, err = h.productCollection.UpdateOne(database.Ctx, bson.M{"_id": productID}, bson.M{
+		"$set": bson.M{"rating": summary, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if err == nil {
+		bumpCatalog()
+	}
+	return err
+}
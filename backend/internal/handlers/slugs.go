@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/slug"
+)
+
+// maxSlugAttempts bounds the numbered suffixes tried before falling back
+// to a random one
+const maxSlugAttempts = 50
+
+// uniqueSlug derives a slug from text that no other document in collection
+// uses, numbering it -2, -3, ... on collisions. The unique index still has
+// the final say when two writers pick the same slug at once.
+func uniqueSlug(collection *mongo.Collection, text, fallback string, self primitive.ObjectID) (string, error) {
+	base := slug.Make(text)
+	if base == "" {
+		base = fallback
+	}
+
+	candidate := base
+	for n := 2; n <= maxSlugAttempts; n++ {
+		taken, err := slugTaken(collection, candidate, self)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = slug.WithSuffix(base, n)
+	}
+	return slug.WithSuffix(base, int(time.Now().UnixNano()%1e6)), nil
+}
+
+func slugTaken(collection *mongo.Collection, candidate string, self primitive.ObjectID) (bool, error) {
+	count, err := collection.CountDocuments(database.Ctx,
+		bson.M{"slug": candidate, "_id": bson.M{"$ne": self}},
+		options.Count().SetLimit(1),
+	)
+	return count > 0, err
+}
+
+// recordSlugChange points the old slug at its document and releases any
+// redirect that held the new slug, which now belongs to a live document
+func recordSlugChange(kind models.SlugKind, oldSlug, newSlug string, targetID primitive.ObjectID) error {
+	redirects := database.Database.Collection("slug_redirects")
+	if _, err := redirects.DeleteOne(database.Ctx, bson.M{"kind": kind, "slug": newSlug}); err != nil {
+		return err
+	}
+	if oldSlug == "" {
+		return nil
+	}
+	_, err := redirects.UpdateOne(database.Ctx,
+		bson.M{"kind": kind, "slug": oldSlug},
+		bson.M{"$set": bson.M{"targetId": targetID, "createdAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// resolveSlugRedirect returns the document an old slug used to name
+func resolveSlugRedirect(kind models.SlugKind, oldSlug string) (primitive.ObjectID, bool, error) {
+	var redirect models.SlugRedirect
+	err := database.Database.Collection("slug_redirects").FindOne(database.Ctx, bson.M{"kind": kind, "slug": oldSlug}).Decode(&redirect)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, false, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, false, err
+	}
+	return redirect.TargetID, true, nil
+}
@@ -10,12 +10,20 @@ import (
 
 	"ecom-backend/internal/models"
 	"ecom-backend/internal/database"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/pagination"
 )
 
 type UsersHandler struct {
 	collection *mongo.Collection
 }
 
+var userSorts = pagination.Sortable{
+	"createdAt": pagination.TimeKey("createdAt"),
+	"email":     pagination.StringKey("email"),
+	"role":      pagination.StringKey("role").ThenBy("createdAt", "email"),
+}
+
 func NewUsersHandler() *UsersHandler {
 	return &UsersHandler{
 		collection: database.Database.Collection("users"),
@@ -23,7 +31,17 @@ func NewUsersHandler() *UsersHandler {
 }
 
 func (h *UsersHandler) GetUsers(c *fiber.Ctx) error {
-	cursor, err := h.collection.Find(database.Ctx, bson.M{})
+	filter := bson.M{}
+	if role := c.Query("role"); role != "" {
+		filter["role"] = role
+	}
+
+	page, err := pagination.ParseList(c, userSorts, newestFirst)
+	if err != nil {
+		return c.Status(400).JSON(pagination.ErrorBody(err))
+	}
+
+	cursor, err := h.collection.Find(database.Ctx, page.Where(filter), page.FindOptions())
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch users"})
 	}
@@ -34,12 +52,22 @@ func (h *UsersHandler) GetUsers(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode users"})
 	}
 
+	users, links, err := pagination.Finish(page, users)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build page cursors"})
+	}
+
 	// Remove passwords from response
 	for i := range users {
 		users[i].Password = ""
 	}
 
-	return c.JSON(users)
+	return c.JSON(fiber.Map{
+		"users":      users,
+		"limit":      page.Limit,
+		"nextCursor": links.Next,
+		"prevCursor": links.Prev,
+	})
 }
 
 func (h *UsersHandler) GetUser(c *fiber.Ctx) error {
@@ -61,6 +89,7 @@ func (h *UsersHandler) GetUser(c *fiber.Ctx) error {
 	// Remove password from response
 	user.Password = ""
 
+	c.Set(fiber.HeaderETag, httpcache.VersionETag(user.Version))
 	return c.JSON(user)
 }
 
@@ -71,16 +100,25 @@ func (h *UsersHandler) BlockUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.collection.UpdateOne(database.Ctx, matchVersion(bson.M{"_id": objectID}, expected), bson.M{
 		"$set": bson.M{
 			"isActive":  false,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to block user"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, h.collection, objectID, &models.User{}, "User")
+	}
 
 	return c.JSON(fiber.Map{"message": "User blocked successfully"})
 }
@@ -92,16 +130,25 @@ func (h *UsersHandler) UnblockUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	_, err = h.collection.UpdateOne(database.Ctx, bson.M{"_id": objectID}, bson.M{
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.collection.UpdateOne(database.Ctx, matchVersion(bson.M{"_id": objectID}, expected), bson.M{
 		"$set": bson.M{
 			"isActive":  true,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to unblock user"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, h.collection, objectID, &models.User{}, "User")
+	}
 
 	return c.JSON(fiber.Map{"message": "User unblocked successfully"})
 }
@@ -119,6 +166,11 @@ func (h *UsersHandler) AssignOrderToDelivery(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid delivery ID"})
 	}
 
+	expected, err := httpcache.IfMatchVersion(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Check if delivery agent exists and has delivery role
 	var deliveryUser models.User
 	err = h.collection.FindOne(database.Ctx, bson.M{
@@ -136,16 +188,20 @@ func (h *UsersHandler) AssignOrderToDelivery(c *fiber.Ctx) error {
 
 	// Update order with assigned delivery agent
 	orderCollection := database.Database.Collection("orders")
-	_, err = orderCollection.UpdateOne(database.Ctx, bson.M{"_id": orderObjectID}, bson.M{
+	result, err := orderCollection.UpdateOne(database.Ctx, matchVersion(bson.M{"_id": orderObjectID}, expected), bson.M{
 		"$set": bson.M{
 			"assignedTo": deliveryObjectID,
 			"updatedAt":  time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to assign order"})
 	}
+	if result.MatchedCount == 0 {
+		return staleWrite(c, orderCollection, orderObjectID, &models.Order{}, "Order")
+	}
 
 	return c.JSON(fiber.Map{"message": "Order assigned to delivery agent"})
 }
@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+const maxWishlistNameLength = 100
+
+type WishlistsHandler struct {
+	wishlistCollection *mongo.Collection
+	productCollection  *mongo.Collection
+	cart               *CartHandler
+}
+
+func NewWishlistsHandler(cart *CartHandler) *WishlistsHandler {
+	return &WishlistsHandler{
+		wishlistCollection: database.Database.Collection("wishlists"),
+		productCollection:  database.Database.Collection("products"),
+		cart:               cart,
+	}
+}
+
+func (h *WishlistsHandler) GetWishlists(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	if userID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	cursor, err := h.wishlistCollection.Find(database.Ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlists"})
+	}
+	defer cursor.Close(database.Ctx)
+
+	var wishlists []models.Wishlist
+	if err = cursor.All(database.Ctx, &wishlists); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode wishlists"})
+	}
+
+	views := make([]fiber.Map, 0, len(wishlists))
+	for _, wishlist := range wishlists {
+		view, err := h.populate(wishlist, true)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist products"})
+		}
+		views = append(views, view)
+	}
+
+	return c.JSON(views)
+}
+
+func (h *WishlistsHandler) GetWishlist(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var wishlist models.Wishlist
+	if err := h.wishlistCollection.FindOne(database.Ctx, filter).Decode(&wishlist); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Wishlist not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist"})
+	}
+
+	return h.respond(c, wishlist, true)
+}
+
+func (h *WishlistsHandler) CreateWishlist(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	if userID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req models.WishlistRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	name, err := wishlistName(req.Name)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	wishlist := models.Wishlist{
+		ID:        primitive.NewObjectID(),
+		UserID:    *userID,
+		Name:      name,
+		Items:     []models.WishlistItem{},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if _, err := h.wishlistCollection.InsertOne(database.Ctx, wishlist); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "You already have a wishlist with this name"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create wishlist"})
+	}
+
+	return c.Status(201).JSON(wishlist)
+}
+
+func (h *WishlistsHandler) RenameWishlist(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req models.WishlistRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	name, err := wishlistName(req.Name)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return h.update(c, filter, bson.M{"$set": bson.M{"name": name, "updatedAt": time.Now()}})
+}
+
+func (h *WishlistsHandler) DeleteWishlist(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.wishlistCollection.DeleteOne(database.Ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete wishlist"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Wishlist not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Wishlist deleted"})
+}
+
+// AddItem saves a product to a wishlist. Adding a product that is already
+// on the list keeps the original entry and its remembered price.
+func (h *WishlistsHandler) AddItem(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req models.AddToWishlistRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	productFilter := visibleProductFilter()
+	productFilter["_id"] = req.ProductID
+	var product models.Product
+	if err := h.productCollection.FindOne(database.Ctx, productFilter).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+
+	filter["items.productId"] = bson.M{"$ne": req.ProductID}
+	_, err = h.wishlistCollection.UpdateOne(database.Ctx, filter, bson.M{
+		"$push": bson.M{"items": models.WishlistItem{
+			ProductID:  req.ProductID,
+			PriceAtAdd: product.PriceAt(time.Now()),
+			AddedAt:    time.Now(),
+		}},
+		"$set": bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update wishlist"})
+	}
+	delete(filter, "items.productId")
+
+	return h.update(c, filter, nil)
+}
+
+func (h *WishlistsHandler) RemoveItem(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	return h.update(c, filter, bson.M{
+		"$pull": bson.M{"items": bson.M{"productId": productID}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+}
+
+// MoveToCart adds a wishlist item to the cart with the cart's own stock
+// checks, and takes it off the list only once that succeeded
+func (h *WishlistsHandler) MoveToCart(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	productID, err := primitive.ObjectIDFromHex(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var req models.MoveToCartRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.Quantity < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Quantity must be positive"})
+	}
+
+	filter["items.productId"] = productID
+	count, err := h.wishlistCollection.CountDocuments(database.Ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist"})
+	}
+	if count == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not found in wishlist"})
+	}
+	delete(filter, "items.productId")
+
+	if cerr := h.cart.addItem(h.cart.userCart(filter["userId"].(primitive.ObjectID)), productID, req.Quantity); cerr != nil {
+		return c.Status(cerr.status).JSON(fiber.Map{"error": cerr.message})
+	}
+
+	return h.update(c, filter, bson.M{
+		"$pull": bson.M{"items": bson.M{"productId": productID}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+}
+
+// ShareWishlist turns on the read-only link for a wishlist. Sharing an
+// already shared list keeps its existing link.
+func (h *WishlistsHandler) ShareWishlist(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to share wishlist"})
+	}
+
+	filter["shareToken"] = bson.M{"$exists": false}
+	_, err = h.wishlistCollection.UpdateOne(database.Ctx, filter, bson.M{
+		"$set": bson.M{"shareToken": token, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to share wishlist"})
+	}
+	delete(filter, "shareToken")
+
+	return h.update(c, filter, nil)
+}
+
+func (h *WishlistsHandler) UnshareWishlist(c *fiber.Ctx) error {
+	filter, err := wishlistFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return h.update(c, filter, bson.M{
+		"$unset": bson.M{"shareToken": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	})
+}
+
+// GetSharedWishlist serves the read-only link, so it needs no login and
+// leaves out who owns the list
+func (h *WishlistsHandler) GetSharedWishlist(c *fiber.Ctx) error {
+	var wishlist models.Wishlist
+	err := h.wishlistCollection.FindOne(database.Ctx, bson.M{"shareToken": c.Params("token")}).Decode(&wishlist)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Wishlist not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist"})
+	}
+
+	return h.respond(c, wishlist, false)
+}
+
+// update applies change to the user's wishlist, if any, and responds with
+// the populated result
+func (h *WishlistsHandler) update(c *fiber.Ctx, filter bson.M, change bson.M) error {
+	var wishlist models.Wishlist
+	var err error
+	if change == nil {
+		err = h.wishlistCollection.FindOne(database.Ctx, filter).Decode(&wishlist)
+	} else {
+		err = h.wishlistCollection.FindOneAndUpdate(database.Ctx, filter, change,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&wishlist)
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "Wishlist not found"})
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"error": "You already have a wishlist with this name"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update wishlist"})
+	}
+
+	return h.respond(c, wishlist, true)
+}
+
+func (h *WishlistsHandler) respond(c *fiber.Ctx, wishlist models.Wishlist, owner bool) error {
+	view, err := h.populate(wishlist, owner)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist products"})
+	}
+	return c.JSON(view)
+}
+
+// populate attaches current product details to a wishlist and flags items
+// whose price fell since they were saved. Products that left the catalog
+// are skipped. The owner view also carries the share token.
+func (h *WishlistsHandler) populate(wishlist models.Wishlist, owner bool) (fiber.Map, error) {
+	ids := make([]primitive.ObjectID, len(wishlist.Items))
+	for i, item := range wishlist.Items {
+		ids[i] = item.ProductID
+	}
+
+	products := map[primitive.ObjectID]models.Product{}
+	if len(ids) > 0 {
+		filter := visibleProductFilter()
+		filter["_id"] = bson.M{"$in": ids}
+		cursor, err := h.productCollection.Find(database.Ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		var found []models.Product
+		if err = cursor.All(database.Ctx, &found); err != nil {
+			return nil, err
+		}
+		for _, product := range found {
+			products[product.ID] = product
+		}
+	}
+
+	now := time.Now()
+	items := []fiber.Map{}
+	priceDrops := 0
+	for _, item := range wishlist.Items {
+		product, ok := products[item.ProductID]
+		if !ok {
+			continue
+		}
+
+		price := product.PriceAt(now)
+		dropped := price < item.PriceAtAdd
+		if dropped {
+			priceDrops++
+		}
+
+		items = append(items, fiber.Map{
+			"productId":    item.ProductID,
+			"addedAt":      item.AddedAt,
+			"priceAtAdd":   item.PriceAtAdd,
+			"price":        price,
+			"priceDropped": dropped,
+			"inStock":      product.Stock > 0,
+			"product":      product,
+		})
+	}
+
+	view := fiber.Map{
+		"id":         wishlist.ID,
+		"name":       wishlist.Name,
+		"items":      items,
+		"priceDrops": priceDrops,
+		"updatedAt":  wishlist.UpdatedAt,
+	}
+	if owner {
+		view["shareToken"] = wishlist.ShareToken
+		view["createdAt"] = wishlist.CreatedAt
+	}
+	return view, nil
+}
+
+// wishlistFilter scopes the wishlist ID in the path to the current user
+func wishlistFilter(c *fiber.Ctx) (bson.M, error) {
+	userID := currentUserID(c)
+	if userID == nil {
+		return nil, errors.New("Invalid user ID")
+	}
+	wishlistID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, errors.New("Invalid wishlist ID")
+	}
+	return bson.M{"_id": wishlistID, "userId": *userID}, nil
+}
+
+func wishlistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Wishlist name is required")
+	}
+	if len(name) > maxWishlistNameLength {
+		return "", errors.New("Wishlist name is too long")
+	}
+	return name, nil
+}
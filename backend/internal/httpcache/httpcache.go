@@ -0,0 +1,104 @@
+package httpcache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var ErrInvalidIfMatch = errors.New(`If-Match must be the ETag of the resource, e.g. "3"`)
+
+// VersionETag is the strong entity tag of a versioned document, e.g. "3".
+// Every write to the document moves its version, so writes take the tag
+// back in If-Match.
+func VersionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ETag builds a weak entity tag from the values that determine a response
+func ETag(parts ...interface{}) string {
+	h := sha1.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%v|", part)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// NotModified sets the ETag and Last-Modified validators on the response and
+// reports whether the request's conditional headers show the client already
+// has this version. If-None-Match takes precedence over If-Modified-Since.
+func NotModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	c.Set(fiber.HeaderETag, etag)
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		return matchesETag(inm, etag)
+	}
+
+	if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// HTTP dates have second precision
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// CacheControl sets the Cache-Control policy for a route's successful
+// responses. Errors are never cached.
+func CacheControl(policy string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if status := c.Response().StatusCode(); status < 400 {
+			c.Set(fiber.HeaderCacheControl, policy)
+		} else {
+			c.Set(fiber.HeaderCacheControl, "no-store")
+		}
+		return nil
+	}
+}
+
+// matchesETag applies the weak comparison used for If-None-Match
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatchVersion reads the document version a client expects from If-Match,
+// sent as the VersionETag a GET returned, e.g. `If-Match: "3"`. It returns
+// nil when the header is absent or "*", in which case the write is
+// unconditional. Weak tags never match under If-Match and are refused.
+func IfMatchVersion(c *fiber.Ctx) (*int64, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	if strings.HasPrefix(header, "W/") {
+		return nil, ErrInvalidIfMatch
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version < 0 {
+		return nil, ErrInvalidIfMatch
+	}
+	return &version, nil
+}
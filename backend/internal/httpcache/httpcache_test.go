@@ -0,0 +1,59 @@
+package httpcache
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The ETag a GET returns is what a write sends back in If-Match
+func TestVersionETagRoundTrip(t *testing.T) {
+	cases := []struct {
+		ifMatch string
+		want    *int64
+		invalid bool
+	}{
+		{ifMatch: "", want: nil},
+		{ifMatch: "*", want: nil},
+		{ifMatch: VersionETag(3), want: ptr(3)},
+		{ifMatch: "3", want: ptr(3)},
+		{ifMatch: `W/"3"`, invalid: true},
+		{ifMatch: ETag("abc", 1), invalid: true},
+		{ifMatch: `"abc"`, invalid: true},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		var got *int64
+		var err error
+		app.Put("/", func(c *fiber.Ctx) error {
+			got, err = IfMatchVersion(c)
+			return nil
+		})
+		req := httptest.NewRequest("PUT", "/", nil)
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		if _, testErr := app.Test(req, -1); testErr != nil {
+			t.Fatalf("If-Match %q: %v", tc.ifMatch, testErr)
+		}
+
+		if tc.invalid {
+			if err != ErrInvalidIfMatch {
+				t.Errorf("If-Match %q: err = %v, want ErrInvalidIfMatch", tc.ifMatch, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("If-Match %q: %v", tc.ifMatch, err)
+		}
+		if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+			t.Errorf("If-Match %q: version = %v, want %v", tc.ifMatch, got, tc.want)
+		}
+	}
+}
+
+func ptr(v int64) *int64 {
+	return &v
+}
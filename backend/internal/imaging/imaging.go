@@ -0,0 +1,123 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// AllowedTypes lists the upload content types we accept, mapped to file extensions
+var AllowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// MaxPixels bounds the width times height of images we decode. A small
+// compressed file can declare huge dimensions, and decoding allocates for
+// every pixel.
+const MaxPixels = 40_000_000
+
+var ErrTooManyPixels = errors.New("image dimensions are too large")
+
+// Size is a named variant width; height follows the source aspect ratio
+type Size struct {
+	Name  string
+	Width int
+}
+
+var DefaultSizes = []Size{
+	{Name: "thumb", Width: 200},
+	{Name: "medium", Width: 600},
+}
+
+// Variant is one encoded rendition of the source image
+type Variant struct {
+	Name        string
+	ContentType string
+	Ext         string
+	Data        []byte
+	Width       int
+	Height      int
+}
+
+// Decode parses an uploaded image in any of the allowed formats. The
+// dimensions in the header are checked against MaxPixels before the
+// pixels are decoded.
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return img, nil
+}
+
+// Variants renders every size as both JPEG and WebP. Sizes wider than the
+// source are rendered at the source width instead of being upscaled.
+func Variants(src image.Image, sizes []Size) ([]Variant, error) {
+	var variants []Variant
+	for _, size := range sizes {
+		resized := resize(src, size.Width)
+		bounds := resized.Bounds()
+
+		var jpg bytes.Buffer
+		if err := jpeg.Encode(&jpg, resized, &jpeg.Options{Quality: 82}); err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{
+			Name:        size.Name,
+			ContentType: "image/jpeg",
+			Ext:         ".jpg",
+			Data:        jpg.Bytes(),
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+		})
+
+		var webp bytes.Buffer
+		if err := nativewebp.Encode(&webp, resized, nil); err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{
+			Name:        size.Name,
+			ContentType: "image/webp",
+			Ext:         ".webp",
+			Data:        webp.Bytes(),
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+		})
+	}
+	return variants, nil
+}
+
+func resize(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
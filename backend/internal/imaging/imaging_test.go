@@ -0,0 +1,51 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader is the start of a PNG declaring width by height pixels, which
+// is all DecodeConfig reads
+func pngHeader(width, height uint32) []byte {
+	var ihdr bytes.Buffer
+	ihdr.WriteString("IHDR")
+	binary.Write(&ihdr, binary.BigEndian, width)
+	binary.Write(&ihdr, binary.BigEndian, height)
+	ihdr.Write([]byte{8, 0, 0, 0, 0}) // 8-bit grayscale, not interlaced
+
+	var data bytes.Buffer
+	data.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&data, binary.BigEndian, uint32(ihdr.Len()-4))
+	data.Write(ihdr.Bytes())
+	binary.Write(&data, binary.BigEndian, crc32.ChecksumIEEE(ihdr.Bytes()))
+	return data.Bytes()
+}
+
+func TestDecodeRejectsHugeDimensions(t *testing.T) {
+	if _, err := Decode(pngHeader(50000, 50000)); err != ErrTooManyPixels {
+		t.Errorf("Decode of a 50000x50000 PNG: err = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(data.Bytes())
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 30 || bounds.Dy() != 20 {
+		t.Errorf("decoded %dx%d, want 30x20", bounds.Dx(), bounds.Dy())
+	}
+
+	if _, err := Decode([]byte("not an image")); err != ErrUnsupportedFormat {
+		t.Errorf("Decode of garbage: err = %v, want ErrUnsupportedFormat", err)
+	}
+}
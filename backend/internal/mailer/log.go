@@ -0,0 +1,20 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// LogMailer writes messages to the server log instead of sending them. It is
+// the default so development setups need no mail server.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	return nil
+}
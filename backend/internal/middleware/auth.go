@@ -64,6 +64,18 @@ func AuthRequired(jwtSecret string) fiber.Handler {
 	}
 }
 
+// OptionalAuth authenticates requests that carry a token, like
+// AuthRequired, and lets anonymous requests through without user info
+func OptionalAuth(jwtSecret string) fiber.Handler {
+	required := AuthRequired(jwtSecret)
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+		return required(c)
+	}
+}
+
 func RequireRole(role models.UserRole) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userRole := c.Locals("userRole")
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyLockDuration   = time.Minute
+	maxIdempotencyClaimChecks = 3
+)
+
+// replayedHeaders are the response headers stored with a response so a
+// replay carries them too
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderLocation, "X-Cart-Token"}
+
+// Idempotency makes POST requests carrying an Idempotency-Key safe to
+// retry. The first request with a key runs and its response is stored for
+// ttl; a retry with the same key and body gets the stored response back,
+// the same key with a different body gets 409, and a retry arriving while
+// the first request is still running gets 409 with Retry-After. Server
+// errors are not stored, so a request that failed with one can be retried
+// with the same key.
+//
+// Keys are scoped to the signed-in user, or to the guest cart guestCartID
+// verifies, so it must run after AuthRequired or OptionalAuth. Requests
+// with neither, like a guest's first add to cart, are scoped to the client
+// address. Routes whose responses carry credentials, like login, must not
+// use it, as responses are stored as sent.
+func Idempotency(ttl time.Duration, guestCartID func(*fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if c.Method() != fiber.MethodPost || key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(400).JSON(fiber.Map{"error": "Idempotency-Key must be at most 255 characters"})
+		}
+
+		scope := idempotencyScope(c, guestCartID)
+		collection := database.Database.Collection("idempotency_keys")
+		fingerprint := requestFingerprint(c)
+
+		for attempt := 0; ; attempt++ {
+			now := time.Now()
+			record := models.IdempotencyRecord{
+				Scope:       scope,
+				Key:         key,
+				Fingerprint: fingerprint,
+				LockedUntil: now.Add(idempotencyLockDuration),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			}
+			_, err := collection.InsertOne(database.Ctx, record)
+			if err == nil {
+				break
+			}
+			if !mongo.IsDuplicateKeyError(err) || attempt >= maxIdempotencyClaimChecks {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to record idempotency key"})
+			}
+
+			var existing models.IdempotencyRecord
+			err = collection.FindOne(database.Ctx, bson.M{"scope": scope, "key": key}).Decode(&existing)
+			if err == mongo.ErrNoDocuments {
+				continue // Released in between; claim it
+			}
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to read idempotency key"})
+			}
+
+			// Expired keys, and keys whose request died without finishing,
+			// are given up and claimed again
+			if now.After(existing.ExpiresAt) || (!existing.Completed && now.After(existing.LockedUntil)) {
+				collection.DeleteOne(database.Ctx, bson.M{"_id": existing.ID})
+				continue
+			}
+
+			if existing.Fingerprint != fingerprint {
+				return c.Status(409).JSON(fiber.Map{"error": "Idempotency-Key was already used for a different request"})
+			}
+			if !existing.Completed {
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return c.Status(409).JSON(fiber.Map{"error": "A request with this Idempotency-Key is still in progress"})
+			}
+			return replay(c, &existing)
+		}
+
+		release := func() {
+			collection.DeleteOne(database.Ctx, bson.M{"scope": scope, "key": key, "completed": false})
+		}
+
+		if err := c.Next(); err != nil {
+			release()
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= 500 {
+			release()
+			return nil
+		}
+
+		headers := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := c.GetRespHeader(name); value != "" {
+				headers[name] = value
+			}
+		}
+		_, err := collection.UpdateOne(database.Ctx,
+			bson.M{"scope": scope, "key": key, "completed": false},
+			bson.M{"$set": bson.M{
+				"completed": true,
+				"status":    status,
+				"headers":   headers,
+				"body":      append([]byte(nil), c.Response().Body()...),
+			}},
+		)
+		if err != nil {
+			// The response still goes out; a retry will run the request again
+			release()
+		}
+		return nil
+	}
+}
+
+func replay(c *fiber.Ctx, record *models.IdempotencyRecord) error {
+	for name, value := range record.Headers {
+		c.Set(name, value)
+	}
+	c.Set(IdempotentReplayedHeader, strconv.FormatBool(true))
+	return c.Status(record.Status).Send(record.Body)
+}
+
+// idempotencyScope keeps callers from seeing each other's responses: keys
+// are scoped to the authenticated user, which survives token refreshes, or
+// else to the guest's cart. Anonymous callers without a cart are scoped to
+// their address, hashed so it is not stored; a retry from another address
+// runs again rather than replaying someone else's response.
+func idempotencyScope(c *fiber.Ctx, guestCartID func(*fiber.Ctx) string) string {
+	if userID, ok := c.Locals("userId").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	if guestCartID != nil {
+		if cartID := guestCartID(c); cartID != "" {
+			return "guest:" + cartID
+		}
+	}
+	client := sha256.Sum256([]byte(c.IP()))
+	return "anonymous:" + hex.EncodeToString(client[:])
+}
+
+// requestFingerprint identifies what a request asks for, so a key reused
+// for a different request is caught
+func requestFingerprint(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method() + " " + c.OriginalURL()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
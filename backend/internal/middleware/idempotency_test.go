@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ecom-backend/internal/database"
+)
+
+// The idempotency tests that store keys run against a real MongoDB named by
+// MONGO_TEST_URI, in a throwaway database; they are skipped when it is not
+// set
+func TestMain(m *testing.M) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		os.Exit(m.Run())
+	}
+
+	name := fmt.Sprintf("ecom_test_%d", time.Now().UnixNano())
+	if err := database.Connect(uri, name); err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to %s: %v\n", uri, err)
+		os.Exit(1)
+	}
+	if err := database.EnsureIndexes(); err != nil {
+		fmt.Fprintf(os.Stderr, "creating indexes: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	database.Database.Drop(database.Ctx)
+	database.Disconnect()
+	os.Exit(code)
+}
+
+func requireMongo(t *testing.T) {
+	t.Helper()
+	if database.Database == nil {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+}
+
+// Keys follow the caller, not the credentials presented on each request
+func TestIdempotencyScope(t *testing.T) {
+	// app.Test requests come from 0.0.0.0
+	client := sha256.Sum256([]byte("0.0.0.0"))
+	anonymous := "anonymous:" + hex.EncodeToString(client[:])
+
+	guestCartID := func(c *fiber.Ctx) string {
+		if c.Get("X-Cart-Token") == "valid" {
+			return "64b0c0ffee64b0c0ffee64b0"
+		}
+		return ""
+	}
+
+	cases := []struct {
+		name    string
+		userID  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "user",
+			userID:  "64b000000000000000000001",
+			headers: map[string]string{"Authorization": "Bearer first-token"},
+			want:    "user:64b000000000000000000001",
+		},
+		{
+			name:    "same user after a token refresh",
+			userID:  "64b000000000000000000001",
+			headers: map[string]string{"Authorization": "Bearer refreshed-token"},
+			want:    "user:64b000000000000000000001",
+		},
+		{
+			name:    "user with a leftover cart token",
+			userID:  "64b000000000000000000001",
+			headers: map[string]string{"X-Cart-Token": "valid"},
+			want:    "user:64b000000000000000000001",
+		},
+		{
+			name:    "guest",
+			headers: map[string]string{"X-Cart-Token": "valid"},
+			want:    "guest:64b0c0ffee64b0c0ffee64b0",
+		},
+		{
+			name:    "guest with a forged cart token",
+			headers: map[string]string{"X-Cart-Token": "forged"},
+			want:    anonymous,
+		},
+		{
+			name: "anonymous",
+			want: anonymous,
+		},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		var got string
+		app.Post("/", func(c *fiber.Ctx) error {
+			if tc.userID != "" {
+				c.Locals("userId", tc.userID)
+			}
+			got = idempotencyScope(c, guestCartID)
+			return nil
+		})
+		req := httptest.NewRequest("POST", "/", nil)
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: scope = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// idempotentApp serves POST /orders behind the middleware. Each run of the
+// handler is counted and answered with the run number. When hold is set,
+// the handler reports on started and waits on hold before answering.
+func idempotentApp(runs *int, mu *sync.Mutex, started, hold chan struct{}) *fiber.App {
+	app := fiber.New()
+	app.Post("/orders", func(c *fiber.Ctx) error {
+		c.Locals("userId", c.Get("X-Test-User"))
+		return c.Next()
+	}, Idempotency(time.Hour, nil), func(c *fiber.Ctx) error {
+		if hold != nil {
+			started <- struct{}{}
+			<-hold
+		}
+		mu.Lock()
+		*runs++
+		run := *runs
+		mu.Unlock()
+		c.Set("X-Cart-Token", "token")
+		return c.Status(201).JSON(fiber.Map{"run": run})
+	})
+	return app
+}
+
+type idempotentResponse struct {
+	status   int
+	body     string
+	replayed string
+	cart     string
+}
+
+func postOrder(t *testing.T, app *fiber.App, user, key, body string) idempotentResponse {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, key)
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST /orders: %v", err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	return idempotentResponse{resp.StatusCode, string(out), resp.Header.Get(IdempotentReplayedHeader), resp.Header.Get("X-Cart-Token")}
+}
+
+// A retry gets the stored response, headers included, without running the
+// handler again; the same key with another body is refused
+func TestIdempotencyReplay(t *testing.T) {
+	requireMongo(t)
+	var runs int
+	var mu sync.Mutex
+	app := idempotentApp(&runs, &mu, nil, nil)
+	key := fmt.Sprintf("replay-%d", time.Now().UnixNano())
+
+	first := postOrder(t, app, "64b000000000000000000001", key, `{"items":1}`)
+	if first.status != 201 || first.replayed != "" {
+		t.Fatalf("first request: %+v", first)
+	}
+	retry := postOrder(t, app, "64b000000000000000000001", key, `{"items":1}`)
+	if retry.status != 201 || retry.body != first.body || retry.replayed != "true" || retry.cart != "token" {
+		t.Errorf("retry: %+v, want a replay of %+v", retry, first)
+	}
+	if conflict := postOrder(t, app, "64b000000000000000000001", key, `{"items":2}`); conflict.status != 409 {
+		t.Errorf("same key, different body: status %d, want 409", conflict.status)
+	}
+	// Another caller's key is their own
+	if other := postOrder(t, app, "64b000000000000000000002", key, `{"items":1}`); other.status != 201 || other.replayed != "" {
+		t.Errorf("same key, other user: %+v, want a fresh run", other)
+	}
+	// Anonymous callers are covered too
+	anonymous := postOrder(t, app, "", key, `{"items":1}`)
+	if again := postOrder(t, app, "", key, `{"items":1}`); again.replayed != "true" || again.body != anonymous.body {
+		t.Errorf("anonymous retry: %+v, want a replay of %+v", again, anonymous)
+	}
+
+	if runs != 3 {
+		t.Errorf("handler ran %d times, want 3", runs)
+	}
+}
+
+// A retry arriving while the first request still runs is told to wait
+func TestIdempotencyInFlight(t *testing.T) {
+	requireMongo(t)
+	var runs int
+	var mu sync.Mutex
+	started, hold := make(chan struct{}), make(chan struct{})
+	app := idempotentApp(&runs, &mu, started, hold)
+	key := fmt.Sprintf("in-flight-%d", time.Now().UnixNano())
+
+	done := make(chan idempotentResponse)
+	go func() { done <- postOrder(t, app, "64b000000000000000000001", key, `{}`) }()
+
+	<-started
+	retry := postOrder(t, app, "64b000000000000000000001", key, `{}`)
+	if retry.status != 409 || !strings.Contains(retry.body, "in progress") {
+		t.Errorf("retry while running: %+v, want 409 in progress", retry)
+	}
+
+	close(hold)
+	if first := <-done; first.status != 201 {
+		t.Fatalf("first request: %+v", first)
+	}
+	if after := postOrder(t, app, "64b000000000000000000001", key, `{}`); after.replayed != "true" {
+		t.Errorf("retry after it finished: %+v, want a replay", after)
+	}
+	if runs != 1 {
+		t.Errorf("handler ran %d times, want 1", runs)
+	}
+}
@@ -20,25 +20,337 @@ type User struct {
 	Role      UserRole           `bson:"role" json:"role"`
 	IsActive  bool               `bson:"isActive" json:"isActive"`
 	Address   string             `bson:"address,omitempty" json:"address,omitempty"`
+	Version   int64              `bson:"version" json:"version"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
+type ProductStatus string
+
+const (
+	ProductDraft    ProductStatus = "draft"
+	ProductActive   ProductStatus = "active"
+	ProductArchived ProductStatus = "archived"
+)
+
+func (s ProductStatus) Valid() bool {
+	return s == ProductDraft || s == ProductActive || s == ProductArchived
+}
+
+// Product is a catalog entry. Reserved counts units held for customers
+// checking out; only the admin stock report shows it.
 type Product struct {
+	ID               primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Title            string                 `bson:"title" json:"title"`
+	Slug             string                 `bson:"slug,omitempty" json:"slug"`
+	Description      string                 `bson:"description" json:"description"`
+	MetaTitle        string                 `bson:"metaTitle,omitempty" json:"metaTitle,omitempty"`
+	MetaDescription  string                 `bson:"metaDescription,omitempty" json:"metaDescription,omitempty"`
+	Price            float64                `bson:"price" json:"price"`
+	Stock            int                    `bson:"stock" json:"stock"`
+	Reserved         int                    `bson:"reserved,omitempty" json:"-"`
+	ReorderThreshold *int                   `bson:"reorderThreshold,omitempty" json:"reorderThreshold,omitempty"`
+	Images           []string               `bson:"images" json:"images"`
+	Category         string                 `bson:"category" json:"category"`
+	Attributes       map[string]interface{} `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	Status           ProductStatus          `bson:"status,omitempty" json:"status"`
+	Rating           RatingSummary          `bson:"rating" json:"rating"`
+	Sale             *Sale                  `bson:"sale,omitempty" json:"sale,omitempty"`
+	EffectivePrice   float64                `bson:"effectivePrice" json:"effectivePrice"`
+	DeletedAt        *time.Time             `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	Version          int64                  `bson:"version" json:"version"`
+	CreatedAt        time.Time              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time              `bson:"updatedAt" json:"updatedAt"`
+}
+
+type AttributeType string
+
+const (
+	AttributeString  AttributeType = "string"
+	AttributeNumber  AttributeType = "number"
+	AttributeEnum    AttributeType = "enum"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeUnit    AttributeType = "unit"
+)
+
+// AttributeDefinition describes one custom product attribute of a category.
+// Options lists the allowed enum values; Unit is the unit that unit values
+// are stored in; Min and Max bound number and unit values.
+type AttributeDefinition struct {
+	Key      string        `bson:"key" json:"key"`
+	Label    string        `bson:"label" json:"label"`
+	Type     AttributeType `bson:"type" json:"type"`
+	Required bool          `bson:"required" json:"required"`
+	Options  []string      `bson:"options,omitempty" json:"options,omitempty"`
+	Unit     string        `bson:"unit,omitempty" json:"unit,omitempty"`
+	Min      *float64      `bson:"min,omitempty" json:"min,omitempty"`
+	Max      *float64      `bson:"max,omitempty" json:"max,omitempty"`
+}
+
+// Category holds the storefront details of a category name used by
+// products. Categories are created on first use.
+type Category struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name            string             `bson:"name" json:"name"`
+	Slug            string             `bson:"slug" json:"slug"`
+	MetaTitle       string             `bson:"metaTitle,omitempty" json:"metaTitle,omitempty"`
+	MetaDescription string             `bson:"metaDescription,omitempty" json:"metaDescription,omitempty"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+type SlugKind string
+
+const (
+	SlugProduct  SlugKind = "product"
+	SlugCategory SlugKind = "category"
+)
+
+// SlugRedirect remembers a slug that was replaced, so old links keep
+// resolving to the product or category that used it
+type SlugRedirect struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind      SlugKind           `bson:"kind" json:"kind"`
+	Slug      string             `bson:"slug" json:"slug"`
+	TargetID  primitive.ObjectID `bson:"targetId" json:"targetId"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ProductAssociation counts the recent orders that contained both
+// products, as computed by the recommendations job
+type ProductAssociation struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductID  primitive.ObjectID `bson:"productId" json:"productId"`
+	RelatedID  primitive.ObjectID `bson:"relatedId" json:"relatedId"`
+	Count      int                `bson:"count" json:"count"`
+	ComputedAt time.Time          `bson:"computedAt" json:"computedAt"`
+}
+
+// FeedEntry is a product pre-rendered for the shopping feeds and sitemap.
+// Only products visible in the catalog have one.
+type FeedEntry struct {
+	ProductID primitive.ObjectID `bson:"_id" json:"productId"`
+	Item      string             `bson:"item" json:"item"`
+	Row       []string           `bson:"row" json:"row"`
+	Loc       string             `bson:"loc" json:"loc"`
+	LastMod   time.Time          `bson:"lastMod" json:"lastMod"`
+}
+
+// FeedState tracks how far feed entries have caught up with the catalog.
+// Format is the rendering the entries were last fully built with.
+type FeedState struct {
+	ID        string    `bson:"_id" json:"id"`
+	SyncedAt  time.Time `bson:"syncedAt" json:"syncedAt"`
+	ChangedAt time.Time `bson:"changedAt" json:"changedAt"`
+	Format    int       `bson:"format" json:"format"`
+}
+
+// CategorySchema lists the attributes products in a category may carry
+type CategorySchema struct {
+	ID         primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	Category   string                `bson:"category" json:"category"`
+	Attributes []AttributeDefinition `bson:"attributes" json:"attributes"`
+	UpdatedAt  time.Time             `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Sale is a time-bounded price override. CompareAtPrice is the struck-through
+// price shown next to it and defaults to the regular price.
+type Sale struct {
+	Price          float64    `bson:"price" json:"price"`
+	CompareAtPrice float64    `bson:"compareAtPrice" json:"compareAtPrice"`
+	StartsAt       *time.Time `bson:"startsAt,omitempty" json:"startsAt,omitempty"`
+	EndsAt         *time.Time `bson:"endsAt,omitempty" json:"endsAt,omitempty"`
+}
+
+func (s *Sale) ActiveAt(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+	if s.StartsAt != nil && t.Before(*s.StartsAt) {
+		return false
+	}
+	if s.EndsAt != nil && !t.Before(*s.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// PriceAt returns what a customer pays for the product at time t.
+// EffectivePrice stores PriceAt(now) so the catalog can sort on it; the
+// pricing job keeps it current as sales start and end.
+func (p *Product) PriceAt(t time.Time) float64 {
+	if p.Sale.ActiveAt(t) {
+		return p.Sale.Price
+	}
+	return p.Price
+}
+
+type PriceChangeKind string
+
+const (
+	PriceChangeRegular   PriceChangeKind = "price"
+	PriceChangeSaleStart PriceChangeKind = "sale_set"
+	PriceChangeSaleEnd   PriceChangeKind = "sale_removed"
+)
+
+// PriceHistory records every change to a product's regular or sale price
+type PriceHistory struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductID primitive.ObjectID  `bson:"productId" json:"productId"`
+	Kind      PriceChangeKind     `bson:"kind" json:"kind"`
+	OldPrice  float64             `bson:"oldPrice" json:"oldPrice"`
+	NewPrice  float64             `bson:"newPrice" json:"newPrice"`
+	Sale      *Sale               `bson:"sale,omitempty" json:"sale,omitempty"`
+	Source    string              `bson:"source" json:"source"`
+	ChangedBy *primitive.ObjectID `bson:"changedBy,omitempty" json:"changedBy,omitempty"`
+	ChangedAt time.Time           `bson:"changedAt" json:"changedAt"`
+}
+
+type PriceScheduleStatus string
+
+const (
+	PriceSchedulePending   PriceScheduleStatus = "pending"
+	PriceScheduleApplied   PriceScheduleStatus = "applied"
+	PriceScheduleCancelled PriceScheduleStatus = "cancelled"
+	PriceScheduleFailed    PriceScheduleStatus = "failed"
+)
+
+// PriceSchedule is a regular price change queued for a future time. It is
+// failed when its product was gone by then.
+type PriceSchedule struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductID   primitive.ObjectID  `bson:"productId" json:"productId"`
+	Price       float64             `bson:"price" json:"price"`
+	EffectiveAt time.Time           `bson:"effectiveAt" json:"effectiveAt"`
+	Status      PriceScheduleStatus `bson:"status" json:"status"`
+	CreatedBy   primitive.ObjectID  `bson:"createdBy" json:"createdBy"`
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+	AppliedAt   *time.Time          `bson:"appliedAt,omitempty" json:"appliedAt,omitempty"`
+}
+
+// RatingSummary aggregates approved reviews. Histogram[0] counts 1-star
+// reviews and Histogram[4] counts 5-star reviews.
+type RatingSummary struct {
+	Average   float64 `bson:"average" json:"average"`
+	Count     int     `bson:"count" json:"count"`
+	Histogram [5]int  `bson:"histogram" json:"histogram"`
+}
+
+type ImageVariant struct {
+	Name        string `bson:"name" json:"name"`
+	Key         string `bson:"key" json:"-"`
+	URL         string `bson:"url" json:"url"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+}
+
+type ProductImage struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Title       string             `bson:"title" json:"title"`
-	Description string             `bson:"description" json:"description"`
-	Price       float64            `bson:"price" json:"price"`
-	Stock       int                `bson:"stock" json:"stock"`
-	Images      []string           `bson:"images" json:"images"`
-	Category    string             `bson:"category" json:"category"`
+	ProductID   primitive.ObjectID `bson:"productId" json:"productId"`
+	Key         string             `bson:"key" json:"-"`
+	URL         string             `bson:"url" json:"url"`
+	ContentType string             `bson:"contentType" json:"contentType"`
+	Size        int64              `bson:"size" json:"size"`
+	Width       int                `bson:"width" json:"width"`
+	Height      int                `bson:"height" json:"height"`
+	Variants    []ImageVariant     `bson:"variants" json:"variants"`
 	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
+type StockAlertKind string
+
+const (
+	StockAlertLow StockAlertKind = "low_stock"
+	StockAlertOut StockAlertKind = "out_of_stock"
+)
+
+// StockAlert is raised when a product falls to its reorder threshold or runs
+// out, and resolved once it is restocked. At most one alert of each kind is
+// open per product.
+type StockAlert struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductID      primitive.ObjectID  `bson:"productId" json:"productId"`
+	ProductTitle   string              `bson:"productTitle" json:"productTitle"`
+	Kind           StockAlertKind      `bson:"kind" json:"kind"`
+	Stock          int                 `bson:"stock" json:"stock"`
+	Threshold      int                 `bson:"threshold" json:"threshold"`
+	Open           bool                `bson:"open" json:"open"`
+	AcknowledgedBy *primitive.ObjectID `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time          `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	ResolvedAt     *time.Time          `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "active"
+	ReservationCommitted ReservationStatus = "committed"
+	ReservationReleased  ReservationStatus = "released"
+)
+
+// StockReservation holds stock for a customer while they pay. Held units
+// are moved from the products' stock to their reserved count until an
+// order commits the reservation or it is released, at the latest once it
+// expires. A customer has at most one active reservation.
+type StockReservation struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"userId" json:"userId"`
+	Items     []CartItem          `bson:"items" json:"items"`
+	Status    ReservationStatus   `bson:"status" json:"status"`
+	OrderID   *primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	ExpiresAt time.Time           `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// StockLevel is one row of the stock report. Available units can be
+// bought; reserved ones are held for customers checking out.
+type StockLevel struct {
+	ProductID primitive.ObjectID `json:"productId"`
+	Title     string             `json:"title"`
+	Category  string             `json:"category"`
+	Available int                `json:"available"`
+	Reserved  int                `json:"reserved"`
+	OnHand    int                `json:"onHand"`
+}
+
+// LowStockItem is one row of the low-stock report. DaysOfCover is nil when
+// the product has not sold in the report window.
+type LowStockItem struct {
+	ProductID   primitive.ObjectID `json:"productId"`
+	Title       string             `json:"title"`
+	Category    string             `json:"category"`
+	Stock       int                `json:"stock"`
+	Threshold   int                `json:"threshold"`
+	UnitsSold   int                `json:"unitsSold"`
+	DailyRate   float64            `json:"dailyRate"`
+	DaysOfCover *float64           `json:"daysOfCover"`
+}
+
+// RestockSubscription asks for one email when a product is back in stock.
+// Token authorises the unsubscribe link in that email.
+type RestockSubscription struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductID  primitive.ObjectID `bson:"productId" json:"productId"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Email      string             `bson:"email" json:"email"`
+	Token      string             `bson:"token" json:"-"`
+	NotifiedAt *time.Time         `bson:"notifiedAt,omitempty" json:"notifiedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// CartItem is a line of a cart or order. Note and Gift are set by the
+// customer on the cart line and carried into the order. In a cart,
+// UnitPrice is the price the customer saw when adding the line or last
+// accepted; in an order it is the price charged.
 type CartItem struct {
 	ProductID primitive.ObjectID `bson:"productId" json:"productId"`
 	Quantity  int                `bson:"quantity" json:"quantity"`
+	UnitPrice float64            `bson:"unitPrice,omitempty" json:"unitPrice,omitempty"`
+	Note      string             `bson:"note,omitempty" json:"note,omitempty"`
+	Gift      bool               `bson:"gift,omitempty" json:"gift,omitempty"`
 	Product   *Product           `bson:"product,omitempty" json:"product,omitempty"`
 }
 
@@ -56,18 +368,261 @@ type Order struct {
 	UserID     primitive.ObjectID  `bson:"userId" json:"userId"`
 	Items      []CartItem          `bson:"items" json:"items"`
 	Total      float64             `bson:"total" json:"total"`
+	Pricing    *PriceBreakdown     `bson:"pricing,omitempty" json:"pricing,omitempty"`
 	Status     OrderStatus         `bson:"status" json:"status"`
 	Address    string              `bson:"address" json:"address"`
 	AssignedTo *primitive.ObjectID `bson:"assignedTo,omitempty" json:"assignedTo,omitempty"`
+	Version    int64               `bson:"version" json:"version"`
 	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt  time.Time           `bson:"updatedAt" json:"updatedAt"`
 }
 
+// PricedLine is one cart or order line as the pricing engine charged it.
+// CompareAtPrice is the regular price when the line is on sale.
+type PricedLine struct {
+	ProductID      primitive.ObjectID `bson:"productId" json:"productId"`
+	Title          string             `bson:"title" json:"title"`
+	Quantity       int                `bson:"quantity" json:"quantity"`
+	UnitPrice      float64            `bson:"unitPrice" json:"unitPrice"`
+	CompareAtPrice float64            `bson:"compareAtPrice,omitempty" json:"compareAtPrice,omitempty"`
+	Subtotal       float64            `bson:"subtotal" json:"subtotal"`
+}
+
+// PriceAdjustment is an order-level discount. Shipping marks a discount
+// that waives the shipping charge rather than reducing the taxable amount.
+type PriceAdjustment struct {
+	PromotionID primitive.ObjectID `bson:"promotionId,omitempty" json:"promotionId,omitempty"`
+	Code        string             `bson:"code,omitempty" json:"code,omitempty"`
+	Label       string             `bson:"label" json:"label"`
+	Amount      float64            `bson:"amount" json:"amount"`
+	Shipping    bool               `bson:"shipping,omitempty" json:"shipping,omitempty"`
+}
+
+// PriceBreakdown is what a cart costs: Total = Subtotal - Discount + Tax +
+// Shipping, with every amount rounded to cents
+type PriceBreakdown struct {
+	Lines     []PricedLine      `bson:"lines" json:"lines"`
+	Subtotal  float64           `bson:"subtotal" json:"subtotal"`
+	Discounts []PriceAdjustment `bson:"discounts" json:"discounts"`
+	Discount  float64           `bson:"discount" json:"discount"`
+	Tax       float64           `bson:"tax" json:"tax"`
+	Shipping  float64           `bson:"shipping" json:"shipping"`
+	Total     float64           `bson:"total" json:"total"`
+}
+
+type CheckoutProblemCode string
+
+const (
+	ProblemInvalidQuantity   CheckoutProblemCode = "invalid_quantity"
+	ProblemUnavailable       CheckoutProblemCode = "unavailable"
+	ProblemOutOfStock        CheckoutProblemCode = "out_of_stock"
+	ProblemInsufficientStock CheckoutProblemCode = "insufficient_stock"
+	ProblemPriceChanged      CheckoutProblemCode = "price_changed"
+	ProblemTotalChanged      CheckoutProblemCode = "total_changed"
+)
+
+// CheckoutProblem is something the customer must fix or confirm before an
+// order is placed. Available is the stock left for stock problems;
+// Expected and Current are the old and new amounts for price problems.
+type CheckoutProblem struct {
+	ProductID primitive.ObjectID  `json:"productId,omitempty"`
+	Code      CheckoutProblemCode `json:"code"`
+	Message   string              `json:"message"`
+	Quantity  int                 `json:"quantity,omitempty"`
+	Available *int                `json:"available,omitempty"`
+	Expected  float64             `json:"expected,omitempty"`
+	Current   float64             `json:"current,omitempty"`
+}
+
+type PromotionType string
+
+const (
+	PromotionPercentage   PromotionType = "percentage"
+	PromotionFixed        PromotionType = "fixed"
+	PromotionBuyXGetY     PromotionType = "buy_x_get_y"
+	PromotionFreeShipping PromotionType = "free_shipping"
+)
+
+// Promotion is a discount rule. Promotions without a code apply to every
+// cart; coded ones are coupons that apply once the code is entered.
+// Categories, when set, limits the rule to products in those categories.
+// Value is a percentage for percentage promotions and an amount for fixed
+// ones; buy X get Y promotions give GetQuantity of every BuyQuantity +
+// GetQuantity units of a product free. Stackable promotions combine with
+// each other; any other promotion applies alone. A zero UsageLimit or
+// PerCustomerLimit means unlimited.
+type Promotion struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name             string             `bson:"name" json:"name"`
+	Code             string             `bson:"code,omitempty" json:"code,omitempty"`
+	Type             PromotionType      `bson:"type" json:"type"`
+	Value            float64            `bson:"value" json:"value"`
+	BuyQuantity      int                `bson:"buyQuantity,omitempty" json:"buyQuantity,omitempty"`
+	GetQuantity      int                `bson:"getQuantity,omitempty" json:"getQuantity,omitempty"`
+	Categories       []string           `bson:"categories,omitempty" json:"categories,omitempty"`
+	MinSubtotal      float64            `bson:"minSubtotal" json:"minSubtotal"`
+	Stackable        bool               `bson:"stackable" json:"stackable"`
+	UsageLimit       int                `bson:"usageLimit" json:"usageLimit"`
+	PerCustomerLimit int                `bson:"perCustomerLimit" json:"perCustomerLimit"`
+	Redemptions      int                `bson:"redemptions" json:"redemptions"`
+	StartsAt         *time.Time         `bson:"startsAt,omitempty" json:"startsAt,omitempty"`
+	EndsAt           *time.Time         `bson:"endsAt,omitempty" json:"endsAt,omitempty"`
+	Active           bool               `bson:"active" json:"active"`
+	Version          int64              `bson:"version" json:"version"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RunningAt reports whether the promotion is enabled and within its
+// validity window at t
+func (p *Promotion) RunningAt(t time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.StartsAt != nil && t.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !t.Before(*p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// UsedUp reports whether the promotion reached its usage limit
+func (p *Promotion) UsedUp() bool {
+	return p.UsageLimit > 0 && p.Redemptions >= p.UsageLimit
+}
+
+// PromotionUsage counts one customer's redemptions of a promotion
+type PromotionUsage struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PromotionID primitive.ObjectID `bson:"promotionId" json:"promotionId"`
+	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	Count       int                `bson:"count" json:"count"`
+}
+
+// PromotionRedemption records a promotion used by an order
+type PromotionRedemption struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PromotionID primitive.ObjectID `bson:"promotionId" json:"promotionId"`
+	Code        string             `bson:"code,omitempty" json:"code,omitempty"`
+	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	OrderID     primitive.ObjectID `bson:"orderId" json:"orderId"`
+	Amount      float64            `bson:"amount" json:"amount"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// IdempotencyRecord remembers a POST made with an Idempotency-Key and,
+// once it completed, its response. Scope identifies the caller and
+// Fingerprint the request; LockedUntil bounds how long an unfinished
+// request holds the key.
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Scope       string             `bson:"scope" json:"scope"`
+	Key         string             `bson:"key" json:"key"`
+	Fingerprint string             `bson:"fingerprint" json:"fingerprint"`
+	Completed   bool               `bson:"completed" json:"completed"`
+	Status      int                `bson:"status,omitempty" json:"status,omitempty"`
+	Headers     map[string]string  `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body        []byte             `bson:"body,omitempty" json:"-"`
+	LockedUntil time.Time          `bson:"lockedUntil" json:"lockedUntil"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt   time.Time          `bson:"expiresAt" json:"expiresAt"`
+}
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+	ReviewFlagged  ReviewStatus = "flagged"
+)
+
+type Review struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	ProductID      primitive.ObjectID   `bson:"productId" json:"productId"`
+	UserID         primitive.ObjectID   `bson:"userId" json:"userId"`
+	Rating         int                  `bson:"rating" json:"rating"`
+	Title          string               `bson:"title" json:"title"`
+	Body           string               `bson:"body" json:"body"`
+	Status         ReviewStatus         `bson:"status" json:"status"`
+	ModerationNote string               `bson:"moderationNote,omitempty" json:"moderationNote,omitempty"`
+	HelpfulCount   int                  `bson:"helpfulCount" json:"helpfulCount"`
+	HelpfulVoters  []primitive.ObjectID `bson:"helpfulVoters" json:"-"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Cart belongs to a user, or to a guest holding a signed cart token. Guest
+// carts live in their own collection and expire at ExpiresAt.
+// SavedForLater holds lines moved out of the cart, which are neither priced
+// nor ordered. RemindedAt is when the owner was last reminded of the cart;
+// a cart changed since then can be reminded of again.
 type Cart struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
-	Items     []CartItem         `bson:"items" json:"items"`
-	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	Items         []CartItem         `bson:"items" json:"items"`
+	SavedForLater []CartItem         `bson:"savedForLater,omitempty" json:"savedForLater,omitempty"`
+	Coupons       []string           `bson:"coupons,omitempty" json:"coupons,omitempty"`
+	UpdatedAt     time.Time          `bson:"updatedAt" json:"updatedAt"`
+	RemindedAt    *time.Time         `bson:"remindedAt,omitempty" json:"-"`
+	ExpiresAt     *time.Time         `bson:"expiresAt,omitempty" json:"-"`
+	// A guest cart being merged on login is claimed until MergingUntil.
+	// The user's cart lists the guest lines merged into it in MergedLines,
+	// so a merge retried after failing part way skips them.
+	MergingUntil *time.Time `bson:"mergingUntil,omitempty" json:"-"`
+	MergedLines  []string   `bson:"mergedLines,omitempty" json:"-"`
+}
+
+// CartReminder records a reminder mailed about an abandoned cart. It is
+// recovered by the customer's first order after it was sent, within the
+// attribution window.
+type CartReminder struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID  `bson:"userId" json:"userId"`
+	Email         string              `bson:"email" json:"email"`
+	CartUpdatedAt time.Time           `bson:"cartUpdatedAt" json:"cartUpdatedAt"`
+	ItemCount     int                 `bson:"itemCount" json:"itemCount"`
+	Subtotal      float64             `bson:"subtotal" json:"subtotal"`
+	CouponCode    string              `bson:"couponCode,omitempty" json:"couponCode,omitempty"`
+	PromotionID   *primitive.ObjectID `bson:"promotionId,omitempty" json:"promotionId,omitempty"`
+	SentAt        time.Time           `bson:"sentAt" json:"sentAt"`
+	RecoveredAt   *time.Time          `bson:"recoveredAt,omitempty" json:"recoveredAt,omitempty"`
+	OrderID       *primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	Revenue       float64             `bson:"revenue,omitempty" json:"revenue,omitempty"`
+	CouponUsed    bool                `bson:"couponUsed,omitempty" json:"couponUsed,omitempty"`
+}
+
+// CartRecoveryReport sums up the cart reminders sent in a report window
+type CartRecoveryReport struct {
+	Days             int     `json:"days"`
+	Sent             int     `json:"sent"`
+	Recovered        int     `json:"recovered"`
+	RecoveryRate     float64 `json:"recoveryRate"`
+	RecoveredRevenue float64 `json:"recoveredRevenue"`
+	CouponsSent      int     `json:"couponsSent"`
+	CouponsUsed      int     `json:"couponsUsed"`
+}
+
+// WishlistItem remembers the price a product had when it was saved, so a
+// later drop can be flagged
+type WishlistItem struct {
+	ProductID  primitive.ObjectID `bson:"productId" json:"productId"`
+	PriceAtAdd float64            `bson:"priceAtAdd" json:"priceAtAdd"`
+	AddedAt    time.Time          `bson:"addedAt" json:"addedAt"`
+}
+
+// Wishlist is one of a user's named lists. ShareToken is set while the list
+// is shared through a read-only link.
+type Wishlist struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Items      []WishlistItem     `bson:"items" json:"items"`
+	ShareToken string             `bson:"shareToken,omitempty" json:"shareToken,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 // Auth request/response models
@@ -92,34 +647,103 @@ type RefreshTokenRequest struct {
 }
 
 // Product request models
+type CategorySchemaRequest struct {
+	Attributes []AttributeDefinition `json:"attributes"`
+}
+
+type UpdateCategoryRequest struct {
+	Slug            *string `json:"slug,omitempty"`
+	MetaTitle       *string `json:"metaTitle,omitempty"`
+	MetaDescription *string `json:"metaDescription,omitempty"`
+}
+
 type CreateProductRequest struct {
-	Title       string   `json:"title" validate:"required"`
-	Description string   `json:"description" validate:"required"`
-	Price       float64  `json:"price" validate:"required,min=0"`
-	Stock       int      `json:"stock" validate:"required,min=0"`
-	Images      []string `json:"images"`
-	Category    string   `json:"category" validate:"required"`
+	Title            string                 `json:"title" validate:"required"`
+	Slug             string                 `json:"slug,omitempty"`
+	Description      string                 `json:"description" validate:"required"`
+	MetaTitle        string                 `json:"metaTitle,omitempty"`
+	MetaDescription  string                 `json:"metaDescription,omitempty"`
+	Price            float64                `json:"price" validate:"required,min=0"`
+	Stock            int                    `json:"stock" validate:"required,min=0"`
+	Images           []string               `json:"images"`
+	Category         string                 `json:"category" validate:"required"`
+	Status           ProductStatus          `json:"status,omitempty"`
+	ReorderThreshold *int                   `json:"reorderThreshold,omitempty"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
 }
 
+// UpdateProductRequest changes the fields that are set. Stock is the
+// available count, not counting reserved units. It is applied as a change
+// from the count the edit was based on, so units reserved or sold meanwhile
+// stay accounted for.
 type UpdateProductRequest struct {
-	Title       *string   `json:"title,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Price       *float64  `json:"price,omitempty"`
-	Stock       *int      `json:"stock,omitempty"`
-	Images      []string  `json:"images,omitempty"`
-	Category    *string   `json:"category,omitempty"`
+	Title            *string                `json:"title,omitempty"`
+	Slug             *string                `json:"slug,omitempty"`
+	Description      *string                `json:"description,omitempty"`
+	MetaTitle        *string                `json:"metaTitle,omitempty"`
+	MetaDescription  *string                `json:"metaDescription,omitempty"`
+	Price            *float64               `json:"price,omitempty"`
+	Stock            *int                   `json:"stock,omitempty"`
+	Images           []string               `json:"images,omitempty"`
+	Category         *string                `json:"category,omitempty"`
+	Status           *ProductStatus         `json:"status,omitempty"`
+	ReorderThreshold *int                   `json:"reorderThreshold,omitempty"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
 }
 
 // Order request models
+// CreateOrderRequest is the body of POST /orders, which checks out the
+// cart like CheckoutRequest. Items are refused rather than ordered.
 type CreateOrderRequest struct {
-	Items   []CartItem `json:"items" validate:"required,min=1"`
-	Address string     `json:"address" validate:"required"`
+	CheckoutRequest
+	Items []CartItem `json:"items,omitempty"`
+}
+
+// CheckoutRequest places an order from the user's cart. ExpectedPrices
+// maps product IDs to the unit prices the customer was shown, defaulting
+// to the prices recorded on the cart lines; with ExpectedTotal, checkout
+// stops at any difference so the customer can confirm the new amounts.
+type CheckoutRequest struct {
+	Address        string             `json:"address" validate:"required"`
+	ExpectedTotal  *float64           `json:"expectedTotal,omitempty"`
+	ExpectedPrices map[string]float64 `json:"expectedPrices,omitempty"`
 }
 
 type UpdateOrderStatusRequest struct {
 	Status OrderStatus `json:"status" validate:"required"`
 }
 
+// Pricing request models
+type SetSaleRequest struct {
+	Price          float64    `json:"price" validate:"required,min=0"`
+	CompareAtPrice float64    `json:"compareAtPrice,omitempty"`
+	StartsAt       *time.Time `json:"startsAt,omitempty"`
+	EndsAt         *time.Time `json:"endsAt,omitempty"`
+}
+
+type SchedulePriceRequest struct {
+	Price       float64   `json:"price" validate:"required,min=0"`
+	EffectiveAt time.Time `json:"effectiveAt" validate:"required"`
+}
+
+// Review request models
+type CreateReviewRequest struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+type UpdateReviewRequest struct {
+	Rating *int    `json:"rating,omitempty"`
+	Title  *string `json:"title,omitempty"`
+	Body   *string `json:"body,omitempty"`
+}
+
+type ModerateReviewRequest struct {
+	Status ReviewStatus `json:"status" validate:"required"`
+	Note   string       `json:"note,omitempty"`
+}
+
 // Cart request models
 type AddToCartRequest struct {
 	ProductID primitive.ObjectID `json:"productId" validate:"required"`
@@ -130,6 +754,49 @@ type UpdateCartItemRequest struct {
 	Quantity int `json:"quantity" validate:"required,min=1"`
 }
 
+// UpdateCartLineRequest changes a cart line's note or gift flag; fields
+// left out are kept
+type UpdateCartLineRequest struct {
+	Note *string `json:"note,omitempty"`
+	Gift *bool   `json:"gift,omitempty"`
+}
+
+type ApplyCouponRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// Promotion request models
+// PromotionRequest creates a promotion or replaces its settings
+type PromotionRequest struct {
+	Name             string        `json:"name" validate:"required"`
+	Code             string        `json:"code,omitempty"`
+	Type             PromotionType `json:"type" validate:"required"`
+	Value            float64       `json:"value"`
+	BuyQuantity      int           `json:"buyQuantity,omitempty"`
+	GetQuantity      int           `json:"getQuantity,omitempty"`
+	Categories       []string      `json:"categories,omitempty"`
+	MinSubtotal      float64       `json:"minSubtotal"`
+	Stackable        bool          `json:"stackable"`
+	UsageLimit       int           `json:"usageLimit"`
+	PerCustomerLimit int           `json:"perCustomerLimit"`
+	StartsAt         *time.Time    `json:"startsAt,omitempty"`
+	EndsAt           *time.Time    `json:"endsAt,omitempty"`
+	Active           *bool         `json:"active,omitempty"`
+}
+
+// Wishlist request models
+type WishlistRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type AddToWishlistRequest struct {
+	ProductID primitive.ObjectID `json:"productId" validate:"required"`
+}
+
+type MoveToCartRequest struct {
+	Quantity int `json:"quantity"`
+}
+
 // User request models
 type UpdateProfileRequest struct {
 	Email   string `json:"email,omitempty" validate:"omitempty,email"`
@@ -0,0 +1,317 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidLimit  = errors.New("limit must be a positive integer")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// secret signs cursors so clients cannot forge the values they carry.
+// Until SetSecret is called it is random, and cursors stop working when
+// the process restarts.
+var secret = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// SetSecret sets the key cursors are signed with. Call it once at startup.
+func SetSecret(key string) {
+	secret = []byte(key)
+}
+
+// SortField is one key of a sort order. _id is always appended as the final
+// tie-breaker so every ordering is total and pages never overlap.
+type SortField struct {
+	Field string
+	Desc  bool
+	Kind  Kind
+}
+
+// cursor is the decoded form of the opaque cursor string handed to clients.
+// Sort records the order it was issued for, so a cursor cannot be replayed
+// against a different sort. Values holds null for documents missing a sort
+// field.
+type cursor struct {
+	Sort     string             `bson:"s"`
+	Values   []bson.RawValue    `bson:"v"`
+	ID       primitive.ObjectID `bson:"id"`
+	Backward bool               `bson:"b"`
+}
+
+// Page describes the slice of a collection a client asked for
+type Page struct {
+	Limit  int
+	Sort   []SortField
+	Offset int
+	cursor *cursor
+}
+
+// Parse reads limit and cursor from the query string. The legacy page
+// parameter is honoured as an offset when no cursor is given.
+func Parse(c *fiber.Ctx, sort []SortField) (*Page, error) {
+	p := &Page{Limit: DefaultLimit, Sort: sort}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return nil, ErrInvalidLimit
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+		p.Limit = limit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decode(raw)
+		if err != nil || cur.Sort != sortKey(sort) || len(cur.Values) != len(sort) {
+			return nil, ErrInvalidCursor
+		}
+		for i, v := range cur.Values {
+			if !sort[i].Kind.accepts(v.Type) {
+				return nil, ErrInvalidCursor
+			}
+		}
+		p.cursor = cur
+	} else if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 1 {
+		p.Offset = (page - 1) * p.Limit
+	}
+
+	return p, nil
+}
+
+// Where narrows filter to the documents after (or before) the cursor
+func (p *Page) Where(filter bson.M) bson.M {
+	if p.cursor == nil {
+		return filter
+	}
+	return bson.M{"$and": []bson.M{filter, p.keyset()}}
+}
+
+// Number is the 1-based page number for requests using the legacy page
+// parameter; cursor requests always report 1
+func (p *Page) Number() int {
+	return p.Offset/p.Limit + 1
+}
+
+// keyset matches documents that sort strictly beyond the cursor position.
+// MongoDB sorts missing and null values before all others, but $gt and $lt
+// never match them, so null positions get clauses of their own.
+func (p *Page) keyset() bson.M {
+	fields := append(append([]SortField{}, p.Sort...), SortField{Field: "_id", Desc: p.idDesc()})
+	values := append(append([]interface{}{}, rawValues(p.cursor.Values)...), p.cursor.ID)
+
+	var or []bson.M
+	for i, f := range fields {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[fields[j].Field] = values[j]
+		}
+		ascending := f.Desc == p.cursor.Backward
+		op := "$gt"
+		if !ascending {
+			op = "$lt"
+		}
+		switch {
+		case values[i] == nil && ascending:
+			// Every value sorts after null
+			clause[f.Field] = bson.M{"$ne": nil}
+		case values[i] == nil:
+			// Nothing sorts before null
+			continue
+		case ascending || i == len(p.Sort):
+			// The _id tie-breaker is never null
+			clause[f.Field] = bson.M{op: values[i]}
+		default:
+			clause["$or"] = bson.A{
+				bson.M{f.Field: bson.M{"$lt": values[i]}},
+				bson.M{f.Field: nil},
+			}
+		}
+		or = append(or, clause)
+	}
+
+	return bson.M{"$or": or}
+}
+
+// rawValues unwraps cursor values for a filter, with nil for null
+func rawValues(values []bson.RawValue) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		if v.Type != bsontype.Null {
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// FindOptions sorts in the direction of travel and fetches one extra
+// document to detect whether another page exists
+func (p *Page) FindOptions() *options.FindOptions {
+	backward := p.cursor != nil && p.cursor.Backward
+
+	sort := bson.D{}
+	for _, f := range p.Sort {
+		sort = append(sort, bson.E{Key: f.Field, Value: direction(f.Desc, backward)})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: direction(p.idDesc(), backward)})
+
+	opts := options.Find().SetSort(sort).SetLimit(int64(p.Limit + 1))
+	if p.Offset > 0 {
+		opts.SetSkip(int64(p.Offset))
+	}
+	return opts
+}
+
+// Links holds the cursors for the neighbouring pages; empty means none
+type Links struct {
+	Next string `json:"nextCursor"`
+	Prev string `json:"prevCursor"`
+}
+
+// Finish trims the look-ahead document, restores display order for
+// backward pages and builds the next/prev cursors
+func Finish[T any](p *Page, items []T) ([]T, Links, error) {
+	if items == nil {
+		items = []T{}
+	}
+
+	hasMore := len(items) > p.Limit
+	if hasMore {
+		items = items[:p.Limit]
+	}
+
+	backward := p.cursor != nil && p.cursor.Backward
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	var links Links
+	if len(items) == 0 {
+		return items, links, nil
+	}
+
+	hasNext := hasMore || backward
+	hasPrev := p.Offset > 0 || (p.cursor != nil && !backward) || (backward && hasMore)
+
+	var err error
+	if hasNext {
+		if links.Next, err = p.encodeAt(items[len(items)-1], false); err != nil {
+			return nil, links, err
+		}
+	}
+	if hasPrev {
+		if links.Prev, err = p.encodeAt(items[0], true); err != nil {
+			return nil, links, err
+		}
+	}
+	return items, links, nil
+}
+
+func (p *Page) encodeAt(item interface{}, backward bool) (string, error) {
+	raw, err := bson.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	cur := cursor{Sort: sortKey(p.Sort), Backward: backward}
+	for _, f := range p.Sort {
+		v, err := bson.Raw(raw).LookupErr(strings.Split(f.Field, ".")...)
+		if err != nil || v.Type == bsontype.Undefined {
+			v = bson.RawValue{Type: bsontype.Null}
+		}
+		cur.Values = append(cur.Values, v)
+	}
+
+	id, err := bson.Raw(raw).LookupErr("_id")
+	if err != nil {
+		return "", err
+	}
+	oid, ok := id.ObjectIDOK()
+	if !ok {
+		return "", errors.New("document _id is not an ObjectID")
+	}
+	cur.ID = oid
+
+	data, err := bson.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + signature(data), nil
+}
+
+func decode(raw string) (*cursor, error) {
+	encoded, sig, found := strings.Cut(raw, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(data))) {
+		return nil, ErrInvalidCursor
+	}
+	var cur cursor
+	if err := bson.Unmarshal(data, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+func signature(data []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("pagination-cursor:"))
+	mac.Write(data)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// idDesc makes the _id tie-breaker follow the direction of the last sort key
+func (p *Page) idDesc() bool {
+	if len(p.Sort) == 0 {
+		return true
+	}
+	return p.Sort[len(p.Sort)-1].Desc
+}
+
+func sortKey(sort []SortField) string {
+	parts := make([]string, len(sort))
+	for i, f := range sort {
+		if f.Desc {
+			parts[i] = "-" + f.Field
+		} else {
+			parts[i] = f.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func direction(desc, backward bool) int {
+	if desc != backward {
+		return -1
+	}
+	return 1
+}
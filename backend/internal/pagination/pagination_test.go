@@ -0,0 +1,259 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testSorts = Sortable{
+	"createdAt": TimeKey("createdAt"),
+	"price":     NumberKey("effectivePrice").ThenBy("title"),
+	"title":     StringKey("title"),
+}
+
+type doc struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Title          string             `bson:"title,omitempty"`
+	EffectivePrice *float64           `bson:"effectivePrice,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt"`
+}
+
+// parseList runs ParseList against a query string
+func parseList(t *testing.T, query url.Values) (*Page, error) {
+	t.Helper()
+	var page *Page
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		page, err = ParseList(c, testSorts, []SortField{{Field: "createdAt", Desc: true}})
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest("GET", "/?"+query.Encode(), nil), -1); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return page, err
+}
+
+// signed encodes a cursor as Finish would, whatever values it holds
+func signed(t *testing.T, cur cursor) string {
+	t.Helper()
+	data, err := bson.Marshal(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + signature(data)
+}
+
+func rawValue(t *testing.T, v interface{}) bson.RawValue {
+	t.Helper()
+	if v == nil {
+		return bson.RawValue{Type: bson.TypeNull}
+	}
+	typ, data, err := bson.MarshalValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.RawValue{Type: typ, Value: data}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	page, err := parseList(t, url.Values{"sort": {"title"}, "limit": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, links, err := Finish(page, []doc{{ID: primitive.NewObjectID(), Title: "a"}, {ID: primitive.NewObjectID(), Title: "b"}})
+	if err != nil || len(items) != 1 || links.Next == "" {
+		t.Fatalf("Finish = %v, %+v, %v", items, links, err)
+	}
+
+	next, err := parseList(t, url.Values{"sort": {"title"}, "cursor": {links.Next}})
+	if err != nil {
+		t.Fatalf("parsing the next cursor: %v", err)
+	}
+	if got := next.cursor.Values[0].StringValue(); got != "a" {
+		t.Errorf("cursor title = %q, want a", got)
+	}
+
+	// The cursor is bound to the sort it was issued for
+	if _, err := parseList(t, url.Values{"sort": {"-title"}, "cursor": {links.Next}}); err != ErrInvalidCursor {
+		t.Errorf("cursor replayed against another sort: err = %v", err)
+	}
+}
+
+func TestParseSortPairs(t *testing.T) {
+	page, err := parseList(t, url.Values{"sort": {"-price,title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortField{{Field: "effectivePrice", Desc: true, Kind: KindNumber}, {Field: "title", Kind: KindString}}
+	if !reflect.DeepEqual(page.Sort, want) {
+		t.Errorf("sort = %+v, want %+v", page.Sort, want)
+	}
+
+	for _, sort := range []string{"title,price", "price,createdAt", "price,price", "price,title,createdAt", "price,nope"} {
+		if _, err := parseList(t, url.Values{"sort": {sort}}); err == nil {
+			t.Errorf("sort=%s accepted", sort)
+		}
+	}
+}
+
+func TestCursorTwoKeys(t *testing.T) {
+	price := 5.0
+	page, err := parseList(t, url.Values{"sort": {"price,title"}, "limit": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, links, err := Finish(page, []doc{
+		{ID: primitive.NewObjectID(), EffectivePrice: &price, Title: "a"},
+		{ID: primitive.NewObjectID(), EffectivePrice: &price, Title: "b"},
+	})
+	if err != nil || links.Next == "" {
+		t.Fatalf("Finish = %+v, %v", links, err)
+	}
+
+	next, err := parseList(t, url.Values{"sort": {"price,title"}, "cursor": {links.Next}})
+	if err != nil {
+		t.Fatalf("parsing the next cursor: %v", err)
+	}
+	if len(next.cursor.Values) != 2 || next.cursor.Values[1].StringValue() != "a" {
+		t.Errorf("cursor values = %v, want price and title a", next.cursor.Values)
+	}
+}
+
+func TestCursorRejectsForgery(t *testing.T) {
+	id := primitive.NewObjectID()
+	valid := signed(t, cursor{Sort: "title", Values: []bson.RawValue{rawValue(t, "a")}, ID: id})
+
+	// Re-signing with another key, or altering the payload, breaks the signature
+	forged := cursor{Sort: "title", Values: []bson.RawValue{rawValue(t, "z")}, ID: id}
+	data, _ := bson.Marshal(forged)
+	tampered := base64.RawURLEncoding.EncodeToString(data) + valid[len(valid)-44:]
+
+	for name, raw := range map[string]string{
+		"unsigned": base64.RawURLEncoding.EncodeToString(data),
+		"tampered": tampered,
+		"garbage":  "not-a-cursor.sig",
+	} {
+		if _, err := parseList(t, url.Values{"sort": {"title"}, "cursor": {raw}}); err == nil {
+			t.Errorf("%s cursor accepted", name)
+		}
+	}
+	if _, err := parseList(t, url.Values{"sort": {"title"}, "cursor": {valid}}); err != nil {
+		t.Errorf("valid cursor: %v", err)
+	}
+}
+
+func TestCursorValueTypes(t *testing.T) {
+	id := primitive.NewObjectID()
+	cases := []struct {
+		sort  string
+		value interface{}
+		ok    bool
+	}{
+		{"title", "a", true},
+		{"title", nil, true},
+		{"title", 3, false},
+		{"title", bson.M{"$gt": ""}, false},
+		{"title", bson.A{"a"}, false},
+		{"effectivePrice", 9.99, true},
+		{"effectivePrice", int32(10), true},
+		{"effectivePrice", int64(10), true},
+		{"effectivePrice", "9.99", false},
+		{"effectivePrice", bson.M{"$ne": nil}, false},
+		{"-createdAt", time.Now(), true},
+		{"-createdAt", "2024-01-01", false},
+	}
+
+	for _, tc := range cases {
+		raw := signed(t, cursor{Sort: tc.sort, Values: []bson.RawValue{rawValue(t, tc.value)}, ID: id})
+		sort := tc.sort
+		if sort == "effectivePrice" {
+			sort = "price"
+		}
+		_, err := parseList(t, url.Values{"sort": {sort}, "cursor": {raw}})
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("sort %s with %T %v: err = %v, want ok %v", tc.sort, tc.value, tc.value, err, tc.ok)
+		}
+	}
+}
+
+// Documents missing the sort field sort first; pages must still reach them
+// going down and move past them going up
+func TestKeysetNullValues(t *testing.T) {
+	id := primitive.NewObjectID()
+	null := bson.RawValue{Type: bson.TypeNull}
+	price := rawValue(t, 5.0)
+	cases := []struct {
+		name string
+		page Page
+		want bson.M
+	}{
+		{
+			name: "ascending from a null",
+			page: Page{Sort: []SortField{{Field: "effectivePrice"}}, cursor: &cursor{Values: []bson.RawValue{null}, ID: id}},
+			want: bson.M{"$or": []bson.M{
+				{"effectivePrice": bson.M{"$ne": nil}},
+				{"effectivePrice": nil, "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			name: "descending from a null",
+			page: Page{Sort: []SortField{{Field: "effectivePrice", Desc: true}}, cursor: &cursor{Values: []bson.RawValue{null}, ID: id}},
+			want: bson.M{"$or": []bson.M{
+				{"effectivePrice": nil, "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			name: "descending from a value",
+			page: Page{Sort: []SortField{{Field: "effectivePrice", Desc: true}}, cursor: &cursor{Values: []bson.RawValue{price}, ID: id}},
+			want: bson.M{"$or": []bson.M{
+				{"$or": bson.A{bson.M{"effectivePrice": bson.M{"$lt": price}}, bson.M{"effectivePrice": nil}}},
+				{"effectivePrice": price, "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			name: "ascending from a value",
+			page: Page{Sort: []SortField{{Field: "effectivePrice"}}, cursor: &cursor{Values: []bson.RawValue{price}, ID: id}},
+			want: bson.M{"$or": []bson.M{
+				{"effectivePrice": bson.M{"$gt": price}},
+				{"effectivePrice": price, "_id": bson.M{"$gt": id}},
+			}},
+		},
+	}
+
+	for _, tc := range cases {
+		got := tc.page.keyset()
+		if !reflect.DeepEqual(got, tc.want) {
+			gotJSON, _ := bson.MarshalExtJSON(got, false, false)
+			wantJSON, _ := bson.MarshalExtJSON(tc.want, false, false)
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, gotJSON, wantJSON)
+		}
+	}
+}
+
+// A legacy document without the sort field yields a null cursor value
+func TestCursorFromMissingField(t *testing.T) {
+	page, err := parseList(t, url.Values{"sort": {"price"}, "limit": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, links, err := Finish(page, []doc{{ID: primitive.NewObjectID()}, {ID: primitive.NewObjectID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := parseList(t, url.Values{"sort": {"price"}, "cursor": {links.Next}})
+	if err != nil {
+		t.Fatalf("parsing a cursor from a document without a price: %v", err)
+	}
+	if typ := next.cursor.Values[0].Type; typ != bson.TypeNull {
+		t.Errorf("cursor value type = %v, want null", typ)
+	}
+}
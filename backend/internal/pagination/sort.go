@@ -0,0 +1,187 @@
+package pagination
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// MaxSortKeys bounds the sort to two keys plus the _id tie-breaker. Every
+// pair needs its own compound index, so only the pairs a Sortable lists
+// are accepted rather than leaving the server to sort in memory.
+const MaxSortKeys = 2
+
+// Sortable maps the sort names a list endpoint accepts to document fields.
+// Every entry must be backed by an index on the field followed by _id, and
+// every pair it allows by an index on both fields followed by _id.
+type Sortable map[string]Key
+
+// Key is a sortable document field and the kind of value it holds. Cursor
+// values of any other BSON type are refused. Then lists the sort names
+// that may follow it as a second key.
+type Key struct {
+	Field string
+	Kind  Kind
+	Then  []string
+}
+
+// Kind is the type of value a sort field holds
+type Kind int
+
+const (
+	KindString Kind = iota + 1
+	KindNumber
+	KindTime
+)
+
+// StringKey, NumberKey and TimeKey declare the entries of a Sortable
+func StringKey(field string) Key { return Key{Field: field, Kind: KindString} }
+func NumberKey(field string) Key { return Key{Field: field, Kind: KindNumber} }
+func TimeKey(field string) Key   { return Key{Field: field, Kind: KindTime} }
+
+// ThenBy allows the named sorts as a second key after this one
+func (k Key) ThenBy(names ...string) Key {
+	k.Then = names
+	return k
+}
+
+// allowsAfter reports whether name may follow this key
+func (k Key) allowsAfter(name string) bool {
+	for _, then := range k.Then {
+		if then == name {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports whether a cursor value of type t can belong to a field
+// of this kind. Null stands for documents missing the field.
+func (k Kind) accepts(t bsontype.Type) bool {
+	switch t {
+	case bsontype.Null:
+		return true
+	case bsontype.String:
+		return k == KindString
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128:
+		return k == KindNumber
+	case bsontype.DateTime:
+		return k == KindTime
+	}
+	return false
+}
+
+// kindOf finds the kind of a field among the allowed sorts
+func (s Sortable) kindOf(field string) Kind {
+	for _, key := range s {
+		if key.Field == field {
+			return key.Kind
+		}
+	}
+	return 0
+}
+
+// Names returns the accepted sort names in a stable order
+func (s Sortable) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SortError reports a sort the endpoint does not allow
+type SortError struct {
+	Reason  string
+	Allowed []string
+}
+
+func (e *SortError) Error() string {
+	return e.Reason
+}
+
+// ParseSort reads the sort order from `sort=-price,title`, where a leading
+// "-" means descending. The older sortBy/sortOrder pair is still accepted
+// for a single key. def is used when neither is given.
+func ParseSort(c *fiber.Ctx, allowed Sortable, def []SortField) ([]SortField, error) {
+	var keys []string
+	if raw := c.Query("sort"); raw != "" {
+		keys = strings.Split(raw, ",")
+	} else if sortBy := c.Query("sortBy"); sortBy != "" {
+		if c.Query("sortOrder", "desc") == "asc" {
+			keys = []string{sortBy}
+		} else {
+			keys = []string{"-" + sortBy}
+		}
+	} else {
+		fields := make([]SortField, len(def))
+		for i, f := range def {
+			fields[i] = SortField{Field: f.Field, Desc: f.Desc, Kind: allowed.kindOf(f.Field)}
+		}
+		return fields, nil
+	}
+
+	if len(keys) > MaxSortKeys {
+		return nil, &SortError{
+			Reason:  fmt.Sprintf("at most %d sort keys are allowed", MaxSortKeys),
+			Allowed: allowed.Names(),
+		}
+	}
+
+	fields := make([]SortField, 0, len(keys))
+	seen := map[string]bool{}
+	var previous *Key
+	var previousName string
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		desc := strings.HasPrefix(key, "-")
+		name := strings.TrimPrefix(strings.TrimPrefix(key, "-"), "+")
+
+		entry, ok := allowed[name]
+		if !ok {
+			return nil, &SortError{
+				Reason:  fmt.Sprintf("cannot sort by %q", name),
+				Allowed: allowed.Names(),
+			}
+		}
+		if seen[entry.Field] {
+			return nil, &SortError{
+				Reason:  fmt.Sprintf("duplicate sort key %q", name),
+				Allowed: allowed.Names(),
+			}
+		}
+		seen[entry.Field] = true
+		if previous != nil && !previous.allowsAfter(name) {
+			return nil, &SortError{
+				Reason:  fmt.Sprintf("cannot sort by %q after %q", name, previousName),
+				Allowed: allowed.Names(),
+			}
+		}
+		previous, previousName = &entry, name
+
+		fields = append(fields, SortField{Field: entry.Field, Desc: desc, Kind: entry.Kind})
+	}
+	return fields, nil
+}
+
+// ParseList reads both the sort order and the page for a list endpoint
+func ParseList(c *fiber.Ctx, allowed Sortable, def []SortField) (*Page, error) {
+	fields, err := ParseSort(c, allowed, def)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(c, fields)
+}
+
+// ErrorBody renders a parse error as a 400 response body, listing the
+// accepted sort names when the sort was rejected
+func ErrorBody(err error) fiber.Map {
+	if sortErr, ok := err.(*SortError); ok {
+		return fiber.Map{"error": sortErr.Reason, "allowedSorts": sortErr.Allowed}
+	}
+	return fiber.Map{"error": err.Error()}
+}
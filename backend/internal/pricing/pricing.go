@@ -0,0 +1,206 @@
+// Package pricing computes what a cart costs. Carts and orders both price
+// through it, so the amount an order charges is the amount the cart showed.
+package pricing
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecom-backend/internal/models"
+)
+
+// Config holds the shop's tax and shipping rules
+type Config struct {
+	// TaxRate is a fraction of the discounted subtotal, e.g. 0.08
+	TaxRate float64
+	// ShippingRate is charged per order unless the discounted subtotal
+	// reaches FreeShippingOver; zero FreeShippingOver never waives it
+	ShippingRate     float64
+	FreeShippingOver float64
+}
+
+// Item is a product and the quantity being bought
+type Item struct {
+	Product  models.Product
+	Quantity int
+}
+
+type Engine struct {
+	cfg Config
+}
+
+func New(cfg Config) *Engine {
+	return &Engine{cfg: cfg}
+}
+
+// Price computes line subtotals at the prices in effect at the given time,
+// applies the combination of promotions that saves the most, then tax and
+// shipping. Callers pass only the promotions the customer may use; Price
+// checks each one's validity window and rules.
+func (e *Engine) Price(items []Item, promotions []models.Promotion, at time.Time) models.PriceBreakdown {
+	breakdown := models.PriceBreakdown{
+		Lines:     []models.PricedLine{},
+		Discounts: []models.PriceAdjustment{},
+	}
+
+	for _, item := range items {
+		unitPrice := item.Product.PriceAt(at)
+		line := models.PricedLine{
+			ProductID: item.Product.ID,
+			Title:     item.Product.Title,
+			Quantity:  item.Quantity,
+			UnitPrice: unitPrice,
+			Subtotal:  Round(unitPrice * float64(item.Quantity)),
+		}
+		if unitPrice < item.Product.Price {
+			line.CompareAtPrice = item.Product.Price
+		}
+		breakdown.Lines = append(breakdown.Lines, line)
+		breakdown.Subtotal += line.Subtotal
+	}
+	breakdown.Subtotal = Round(breakdown.Subtotal)
+
+	// Stackable promotions combine into one option; every other promotion
+	// is an option on its own
+	var stacked []models.PriceAdjustment
+	var options [][]models.PriceAdjustment
+	for i := range promotions {
+		promotion := &promotions[i]
+		if !promotion.RunningAt(at) {
+			continue
+		}
+		adjustment, ok := discount(promotion, items, breakdown)
+		if !ok {
+			continue
+		}
+		if promotion.Stackable {
+			stacked = append(stacked, adjustment)
+		} else {
+			options = append(options, []models.PriceAdjustment{adjustment})
+		}
+	}
+	if len(stacked) > 0 {
+		options = append(options, stacked)
+	}
+
+	best := breakdown
+	e.settle(&best)
+	for _, discounts := range options {
+		candidate := breakdown
+		candidate.Discounts = append([]models.PriceAdjustment{}, discounts...)
+		e.settle(&candidate)
+		if candidate.Total < best.Total {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// discount works out what a promotion takes off the priced lines. Free
+// shipping discounts are sized by settle once shipping is known.
+func discount(promotion *models.Promotion, items []Item, breakdown models.PriceBreakdown) (models.PriceAdjustment, bool) {
+	adjustment := models.PriceAdjustment{
+		PromotionID: promotion.ID,
+		Code:        promotion.Code,
+		Label:       promotion.Name,
+	}
+	if breakdown.Subtotal < promotion.MinSubtotal {
+		return adjustment, false
+	}
+
+	var eligible float64
+	var free float64
+	for i, line := range breakdown.Lines {
+		if !inCategories(items[i].Product.Category, promotion.Categories) {
+			continue
+		}
+		eligible += line.Subtotal
+		if group := promotion.BuyQuantity + promotion.GetQuantity; group > 0 && promotion.GetQuantity > 0 {
+			free += float64(line.Quantity/group*promotion.GetQuantity) * line.UnitPrice
+		}
+	}
+	if eligible == 0 {
+		return adjustment, false
+	}
+
+	switch promotion.Type {
+	case models.PromotionPercentage:
+		adjustment.Amount = eligible * promotion.Value / 100
+	case models.PromotionFixed:
+		adjustment.Amount = math.Min(promotion.Value, eligible)
+	case models.PromotionBuyXGetY:
+		adjustment.Amount = free
+	case models.PromotionFreeShipping:
+		adjustment.Shipping = true
+		return adjustment, true
+	default:
+		return adjustment, false
+	}
+	adjustment.Amount = Round(adjustment.Amount)
+	return adjustment, adjustment.Amount > 0
+}
+
+func inCategories(category string, categories []string) bool {
+	if len(categories) == 0 {
+		return true
+	}
+	for _, c := range categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// settle derives the discount total, tax, shipping and grand total from
+// the lines and discounts
+func (e *Engine) settle(breakdown *models.PriceBreakdown) {
+	var itemDiscount float64
+	for _, discount := range breakdown.Discounts {
+		if !discount.Shipping {
+			itemDiscount += discount.Amount
+		}
+	}
+	// A discount never makes the order pay out
+	itemDiscount = Round(math.Min(itemDiscount, breakdown.Subtotal))
+
+	taxable := breakdown.Subtotal - itemDiscount
+	breakdown.Tax = Round(taxable * e.cfg.TaxRate)
+
+	breakdown.Shipping = 0
+	if len(breakdown.Lines) > 0 && (e.cfg.FreeShippingOver <= 0 || taxable < e.cfg.FreeShippingOver) {
+		breakdown.Shipping = Round(e.cfg.ShippingRate)
+	}
+
+	// Free shipping waives the charge once, however many promotions grant it
+	discounts := []models.PriceAdjustment{}
+	var shippingDiscount float64
+	for _, discount := range breakdown.Discounts {
+		if discount.Shipping {
+			discount.Amount = breakdown.Shipping - shippingDiscount
+			shippingDiscount += discount.Amount
+		}
+		if discount.Amount > 0 {
+			discounts = append(discounts, discount)
+		}
+	}
+	breakdown.Discounts = discounts
+
+	breakdown.Discount = Round(itemDiscount + shippingDiscount)
+	breakdown.Total = Round(taxable + breakdown.Tax + breakdown.Shipping - shippingDiscount)
+}
+
+// Round rounds an amount to cents, halves away from zero. It shifts the
+// shortest decimal form of the amount rather than multiplying, so 1.005,
+// stored as slightly less, still rounds up to 1.01.
+func Round(amount float64) float64 {
+	mantissa, exponent, ok := strings.Cut(strconv.FormatFloat(amount, 'e', -1, 64), "e")
+	if !ok {
+		return amount // NaN or infinite
+	}
+	exp, _ := strconv.Atoi(exponent)
+	cents, _ := strconv.ParseFloat(mantissa+"e"+strconv.Itoa(exp+2), 64)
+	return math.Round(cents) / 100
+}
@@ -0,0 +1,307 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecom-backend/internal/models"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func product(price float64, category string) models.Product {
+	return models.Product{ID: primitive.NewObjectID(), Title: category, Price: price, Category: category}
+}
+
+func promotion(kind models.PromotionType, value float64, stackable bool) models.Promotion {
+	return models.Promotion{ID: primitive.NewObjectID(), Name: string(kind), Type: kind, Value: value, Stackable: stackable, Active: true}
+}
+
+func TestRound(t *testing.T) {
+	cases := []struct {
+		amount float64
+		want   float64
+	}{
+		{amount: 10, want: 10},
+		{amount: 1.004, want: 1},
+		{amount: 1.005, want: 1.01},
+		{amount: 2.675, want: 2.68},
+		{amount: 0.125, want: 0.13},
+		{amount: 0.335 * 3, want: 1.01},
+		{amount: 0.1 + 0.2, want: 0.3},
+		{amount: -1.005, want: -1.01},
+		{amount: 0.004999, want: 0},
+	}
+	for _, tc := range cases {
+		if got := Round(tc.amount); got != tc.want {
+			t.Errorf("Round(%v) = %v, want %v", tc.amount, got, tc.want)
+		}
+	}
+	if got := Round(math.Inf(1)); !math.IsInf(got, 1) {
+		t.Errorf("Round(+Inf) = %v", got)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	engine := New(Config{TaxRate: 0.1, ShippingRate: 5, FreeShippingOver: 50})
+	book := product(10, "books")
+	toy := product(4.99, "toys")
+	onSale := product(12, "books")
+	onSale.Sale = &models.Sale{Price: 8}
+
+	tenPercent := promotion(models.PromotionPercentage, 10, false)
+	tenPercentStacking := promotion(models.PromotionPercentage, 10, true)
+	fiveOff := promotion(models.PromotionFixed, 5, false)
+	fiveOffStacking := promotion(models.PromotionFixed, 5, true)
+	twentyOffToys := promotion(models.PromotionFixed, 20, false)
+	twentyOffToys.Categories = []string{"toys"}
+	freeShipping := promotion(models.PromotionFreeShipping, 0, true)
+	freeShippingAgain := promotion(models.PromotionFreeShipping, 0, true)
+	buyTwoGetOne := promotion(models.PromotionBuyXGetY, 0, false)
+	buyTwoGetOne.BuyQuantity, buyTwoGetOne.GetQuantity = 2, 1
+	inactive := promotion(models.PromotionFixed, 30, false)
+	inactive.Active = false
+	ended := promotion(models.PromotionFixed, 30, false)
+	ended.EndsAt = &now
+	overMinimum := promotion(models.PromotionFixed, 30, false)
+	overMinimum.MinSubtotal = 100
+
+	cases := []struct {
+		name       string
+		items      []Item
+		promotions []models.Promotion
+		// Discounts lists the promotions applied, in order
+		discounts []primitive.ObjectID
+		want      models.PriceBreakdown
+	}{
+		{
+			name:  "line totals",
+			items: []Item{{book, 3}, {toy, 2}},
+			want:  models.PriceBreakdown{Subtotal: 39.98, Tax: 4, Shipping: 5, Total: 48.98},
+		},
+		{
+			name:  "sale price",
+			items: []Item{{onSale, 2}},
+			want:  models.PriceBreakdown{Subtotal: 16, Tax: 1.6, Shipping: 5, Total: 22.6},
+		},
+		{
+			name:       "percentage",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{tenPercent},
+			discounts:  []primitive.ObjectID{tenPercent.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 4, Tax: 3.6, Shipping: 5, Total: 44.58},
+		},
+		{
+			name:       "fixed",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{fiveOff},
+			discounts:  []primitive.ObjectID{fiveOff.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 5, Tax: 3.5, Shipping: 5, Total: 43.48},
+		},
+		{
+			name:       "fixed capped at the eligible lines",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{twentyOffToys},
+			discounts:  []primitive.ObjectID{twentyOffToys.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 9.98, Tax: 3, Shipping: 5, Total: 38},
+		},
+		{
+			name:       "buy two get one",
+			items:      []Item{{book, 7}},
+			promotions: []models.Promotion{buyTwoGetOne},
+			discounts:  []primitive.ObjectID{buyTwoGetOne.ID},
+			want:       models.PriceBreakdown{Subtotal: 70, Discount: 20, Tax: 5, Total: 55},
+		},
+		{
+			name:       "stackable promotions combine",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{tenPercentStacking, fiveOffStacking},
+			discounts:  []primitive.ObjectID{tenPercentStacking.ID, fiveOffStacking.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 9, Tax: 3.1, Shipping: 5, Total: 39.08},
+		},
+		{
+			name:       "exclusive promotions pick the best",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{tenPercent, fiveOff},
+			discounts:  []primitive.ObjectID{fiveOff.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 5, Tax: 3.5, Shipping: 5, Total: 43.48},
+		},
+		{
+			name:       "stacked group beats a single exclusive one",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{fiveOff, tenPercentStacking, fiveOffStacking},
+			discounts:  []primitive.ObjectID{tenPercentStacking.ID, fiveOffStacking.ID},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Discount: 9, Tax: 3.1, Shipping: 5, Total: 39.08},
+		},
+		{
+			name:       "inactive, ended and unmet minimum are ignored",
+			items:      []Item{{book, 3}, {toy, 2}},
+			promotions: []models.Promotion{inactive, ended, overMinimum},
+			want:       models.PriceBreakdown{Subtotal: 39.98, Tax: 4, Shipping: 5, Total: 48.98},
+		},
+		{
+			name:  "free shipping from the threshold",
+			items: []Item{{book, 5}},
+			want:  models.PriceBreakdown{Subtotal: 50, Tax: 5, Total: 55},
+		},
+		{
+			name:       "discount below the threshold brings shipping back",
+			items:      []Item{{book, 5}},
+			promotions: []models.Promotion{tenPercent},
+			discounts:  []primitive.ObjectID{tenPercent.ID},
+			want:       models.PriceBreakdown{Subtotal: 50, Discount: 5, Tax: 4.5, Shipping: 5, Total: 54.5},
+		},
+		{
+			name:       "free shipping promotion waives shipping once",
+			items:      []Item{{book, 4}},
+			promotions: []models.Promotion{freeShipping, freeShippingAgain},
+			discounts:  []primitive.ObjectID{freeShipping.ID},
+			want:       models.PriceBreakdown{Subtotal: 40, Discount: 5, Tax: 4, Shipping: 5, Total: 44},
+		},
+		{
+			name:       "free shipping promotion over the threshold is dropped",
+			items:      []Item{{book, 6}},
+			promotions: []models.Promotion{freeShipping},
+			want:       models.PriceBreakdown{Subtotal: 60, Tax: 6, Total: 66},
+		},
+		{
+			name: "empty cart",
+			want: models.PriceBreakdown{},
+		},
+	}
+
+	for _, tc := range cases {
+		got := engine.Price(tc.items, tc.promotions, now)
+		if got.Subtotal != tc.want.Subtotal || got.Discount != tc.want.Discount || got.Tax != tc.want.Tax ||
+			got.Shipping != tc.want.Shipping || got.Total != tc.want.Total {
+			t.Errorf("%s: subtotal %v, discount %v, tax %v, shipping %v, total %v; want %v, %v, %v, %v, %v", tc.name,
+				got.Subtotal, got.Discount, got.Tax, got.Shipping, got.Total,
+				tc.want.Subtotal, tc.want.Discount, tc.want.Tax, tc.want.Shipping, tc.want.Total)
+		}
+		if len(got.Discounts) != len(tc.discounts) {
+			t.Errorf("%s: %d discounts, want %d", tc.name, len(got.Discounts), len(tc.discounts))
+			continue
+		}
+		for i, id := range tc.discounts {
+			if got.Discounts[i].PromotionID != id {
+				t.Errorf("%s: discount %d is %s, want %s", tc.name, i, got.Discounts[i].Label, id.Hex())
+			}
+		}
+	}
+}
+
+func TestPriceLines(t *testing.T) {
+	engine := New(Config{})
+	onSale := product(12, "books")
+	onSale.Sale = &models.Sale{Price: 8}
+	cheap := product(0.335, "toys")
+
+	got := engine.Price([]Item{{onSale, 2}, {cheap, 3}}, nil, now)
+	want := []models.PricedLine{
+		{ProductID: onSale.ID, Title: "books", Quantity: 2, UnitPrice: 8, CompareAtPrice: 12, Subtotal: 16},
+		{ProductID: cheap.ID, Title: "toys", Quantity: 3, UnitPrice: 0.335, Subtotal: 1.01},
+	}
+	if len(got.Lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(got.Lines), len(want))
+	}
+	for i := range want {
+		if got.Lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got.Lines[i], want[i])
+		}
+	}
+	if got.Subtotal != 17.01 || got.Total != 17.01 {
+		t.Errorf("subtotal %v, total %v, want 17.01", got.Subtotal, got.Total)
+	}
+
+	// After the sale ends the regular price applies and nothing is struck through
+	ends := now.Add(-time.Hour)
+	onSale.Sale.EndsAt = &ends
+	line := engine.Price([]Item{{onSale, 1}}, nil, now).Lines[0]
+	if line.UnitPrice != 12 || line.CompareAtPrice != 0 {
+		t.Errorf("after the sale: unit %v, compare at %v", line.UnitPrice, line.CompareAtPrice)
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	book := product(10, "books")
+	toy := product(4.99, "toys")
+	items := []Item{{book, 3}, {toy, 2}}
+	breakdown := New(Config{}).Price(items, nil, now)
+
+	percentOfToys := promotion(models.PromotionPercentage, 15, false)
+	percentOfToys.Categories = []string{"toys"}
+	garden := promotion(models.PromotionFixed, 5, false)
+	garden.Categories = []string{"garden"}
+	unknown := promotion("bogus", 5, false)
+
+	cases := []struct {
+		name      string
+		promotion models.Promotion
+		amount    float64
+		shipping  bool
+		ok        bool
+	}{
+		{name: "percentage of a category", promotion: percentOfToys, amount: 1.5, ok: true},
+		{name: "no eligible lines", promotion: garden},
+		{name: "free shipping is sized later", promotion: promotion(models.PromotionFreeShipping, 0, false), shipping: true, ok: true},
+		{name: "zero percent", promotion: promotion(models.PromotionPercentage, 0, false)},
+		{name: "unknown type", promotion: unknown},
+	}
+	for _, tc := range cases {
+		got, ok := discount(&tc.promotion, items, breakdown)
+		if ok != tc.ok || got.Amount != tc.amount || got.Shipping != tc.shipping {
+			t.Errorf("%s: amount %v, shipping %v, ok %v; want %v, %v, %v", tc.name, got.Amount, got.Shipping, ok, tc.amount, tc.shipping, tc.ok)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	engine := New(Config{TaxRate: 0.1, ShippingRate: 4.5})
+	lines := []models.PricedLine{{Quantity: 1, UnitPrice: 20, Subtotal: 20}}
+
+	cases := []struct {
+		name      string
+		discounts []models.PriceAdjustment
+		want      models.PriceBreakdown
+		kept      int
+	}{
+		{
+			name: "no discounts",
+			want: models.PriceBreakdown{Tax: 2, Shipping: 4.5, Total: 26.5},
+		},
+		{
+			name:      "discounts never exceed the subtotal",
+			discounts: []models.PriceAdjustment{{Amount: 15}, {Amount: 10}},
+			want:      models.PriceBreakdown{Discount: 20, Shipping: 4.5, Total: 4.5},
+			kept:      2,
+		},
+		{
+			name:      "free shipping takes the shipping charge",
+			discounts: []models.PriceAdjustment{{Amount: 2}, {Shipping: true}},
+			want:      models.PriceBreakdown{Discount: 6.5, Tax: 1.8, Shipping: 4.5, Total: 19.8},
+			kept:      2,
+		},
+		{
+			name:      "half-cent tax rounds up",
+			discounts: []models.PriceAdjustment{{Amount: 19.95}},
+			want:      models.PriceBreakdown{Discount: 19.95, Tax: 0.01, Shipping: 4.5, Total: 4.56},
+			kept:      1,
+		},
+	}
+	for _, tc := range cases {
+		breakdown := models.PriceBreakdown{Lines: lines, Subtotal: 20, Discounts: tc.discounts}
+		engine.settle(&breakdown)
+		if breakdown.Discount != tc.want.Discount || breakdown.Tax != tc.want.Tax ||
+			breakdown.Shipping != tc.want.Shipping || breakdown.Total != tc.want.Total {
+			t.Errorf("%s: discount %v, tax %v, shipping %v, total %v; want %v, %v, %v, %v", tc.name,
+				breakdown.Discount, breakdown.Tax, breakdown.Shipping, breakdown.Total,
+				tc.want.Discount, tc.want.Tax, tc.want.Shipping, tc.want.Total)
+		}
+		if len(breakdown.Discounts) != tc.kept {
+			t.Errorf("%s: kept %d discounts, want %d", tc.name, len(breakdown.Discounts), tc.kept)
+		}
+	}
+}
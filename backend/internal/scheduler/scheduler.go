@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs job once per interval until ctx is cancelled. The first run
+// happens immediately so a restart does not delay overdue work.
+func Every(ctx context.Context, name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run(name, job)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func run(name string, job func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", name, r)
+		}
+	}()
+
+	if err := job(); err != nil {
+		log.Printf("Job %s failed: %v", name, err)
+	}
+}
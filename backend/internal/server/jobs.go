@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/scheduler"
+)
+
+// dueSlack lets an external scheduler firing on the interval run a job
+// even when its last run started a little late
+const dueSlack = 30 * time.Second
+
+// job is a background task and how often it runs
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// jobs lists the background jobs the server is configured for
+func (s *Server) jobs() []job {
+	jobs := []job{
+		{"purge-deleted-products", time.Hour, func() error {
+			purged, err := s.products.PurgeDeletedProducts(time.Duration(s.cfg.ProductPurgeDays) * 24 * time.Hour)
+			if purged > 0 {
+				log.Printf("Purged %d deleted products", purged)
+			}
+			return err
+		}},
+		{"apply-scheduled-prices", time.Minute, func() error {
+			if _, err := s.pricing.ApplyDuePrices(); err != nil {
+				return err
+			}
+			_, err := s.pricing.RefreshEffectivePrices()
+			return err
+		}},
+		{"refresh-feeds", 5 * time.Minute, func() error {
+			refreshed, err := s.feeds.Refresh()
+			if refreshed > 0 {
+				log.Printf("Refreshed feed entries for %d products", refreshed)
+			}
+			return err
+		}},
+		{"compute-recommendations", 6 * time.Hour, func() error {
+			pairs, err := s.recommendations.Compute()
+			if err == nil {
+				log.Printf("Computed %d co-purchase pairs", pairs)
+			}
+			return err
+		}},
+		{"release-stock-reservations", time.Minute, func() error {
+			released, err := s.reservations.ReleaseExpired()
+			if released > 0 {
+				log.Printf("Released %d expired stock reservations", released)
+			}
+			return err
+		}},
+		{"check-stock-levels", 15 * time.Minute, func() error {
+			raised, err := s.inventory.CheckStock()
+			if raised > 0 {
+				log.Printf("Raised %d stock alerts", raised)
+			}
+			return err
+		}},
+		{"retry-restock-notifications", 15 * time.Minute, func() error {
+			sent, err := s.restock.NotifyPending()
+			if sent > 0 {
+				log.Printf("Sent %d pending restock notifications", sent)
+			}
+			return err
+		}},
+	}
+	if s.cfg.AbandonedCartHours > 0 {
+		jobs = append(jobs, job{"remind-abandoned-carts", time.Hour, func() error {
+			recovered, err := s.cartReminders.AttributeRecoveries()
+			if err != nil {
+				return err
+			}
+			if recovered > 0 {
+				log.Printf("Attributed %d orders to cart reminders", recovered)
+			}
+			sent, err := s.cartReminders.SendReminders()
+			if sent > 0 {
+				log.Printf("Sent %d abandoned cart reminders", sent)
+			}
+			return err
+		}})
+	}
+	return jobs
+}
+
+// StartJobs schedules the background jobs until ctx is cancelled. Only a
+// long-running process can run them; serverless deployments call RunJobs
+// from a cron instead.
+func (s *Server) StartJobs(ctx context.Context) {
+	for _, j := range s.jobs() {
+		scheduler.Every(ctx, j.name, j.interval, j.run)
+	}
+}
+
+// RunJobs runs each background job whose interval has passed since its
+// last run, once. Callers send CronSecret as a bearer token, which is how
+// Vercel Cron authenticates its requests; without a secret it is off.
+func (s *Server) RunJobs(c *fiber.Ctx) error {
+	if s.cfg.CronSecret == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "Not found"})
+	}
+	want := "Bearer " + s.cfg.CronSecret
+	if subtle.ConstantTimeCompare([]byte(c.Get("Authorization")), []byte(want)) != 1 {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ran := []string{}
+	failed := fiber.Map{}
+	for _, j := range s.jobs() {
+		due, err := claimJob(j.name, j.interval)
+		if err != nil {
+			failed[j.name] = err.Error()
+			continue
+		}
+		if !due {
+			continue
+		}
+		if err := j.run(); err != nil {
+			log.Printf("Job %s failed: %v", j.name, err)
+			failed[j.name] = err.Error()
+			continue
+		}
+		ran = append(ran, j.name)
+	}
+
+	status := 200
+	if len(failed) > 0 {
+		status = 500
+	}
+	return c.Status(status).JSON(fiber.Map{"ran": ran, "failed": failed})
+}
+
+// claimJob records a run of the named job when its interval has passed
+// since the last one. The record is claimed atomically, so overlapping
+// cron requests do not run a job twice.
+func claimJob(name string, interval time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := database.Database.Collection("job_runs").UpdateOne(database.Ctx,
+		bson.M{"_id": name, "startedAt": bson.M{"$lte": now.Add(-interval + dueSlack)}},
+		bson.M{"$set": bson.M{"startedAt": now}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil // Ran recently
+	}
+	return err == nil, err
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/models"
+)
+
+func seedDemoUsers() {
+	// Check if admin user exists
+	var adminUser models.User
+	err := database.Database.Collection("users").FindOne(database.Ctx, bson.M{"email": "admin@demo.com"}).Decode(&adminUser)
+	if err != nil {
+		// Create admin user
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Admin@123"), bcrypt.DefaultCost)
+		adminUser = models.User{
+			ID:        primitive.NewObjectID(),
+			Email:     "admin@demo.com",
+			Password:  string(hashedPassword),
+			Role:      models.RoleAdmin,
+			IsActive:  true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		database.Database.Collection("users").InsertOne(database.Ctx, adminUser)
+		log.Println("Demo admin user created: admin@demo.com / Admin@123")
+	} else {
+		// Update existing admin user to ensure it's active
+		update := bson.M{
+			"$set": bson.M{
+				"isActive":  true,
+				"role":      models.RoleAdmin,
+				"updatedAt": time.Now(),
+			},
+		}
+		database.Database.Collection("users").UpdateOne(database.Ctx, bson.M{"email": "admin@demo.com"}, update)
+		log.Println("Demo admin user updated: admin@demo.com / Admin@123")
+	}
+
+	// Check if delivery user exists
+	var deliveryUser models.User
+	err = database.Database.Collection("users").FindOne(database.Ctx, bson.M{"email": "delivery@demo.com"}).Decode(&deliveryUser)
+	if err != nil {
+		// Create delivery user
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Delivery@123"), bcrypt.DefaultCost)
+		deliveryUser = models.User{
+			ID:        primitive.NewObjectID(),
+			Email:     "delivery@demo.com",
+			Password:  string(hashedPassword),
+			Role:      models.RoleDelivery,
+			IsActive:  true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		database.Database.Collection("users").InsertOne(database.Ctx, deliveryUser)
+		log.Println("Demo delivery user created: delivery@demo.com / Delivery@123")
+	} else {
+		// Update existing delivery user to ensure it's active
+		update := bson.M{
+			"$set": bson.M{
+				"isActive":  true,
+				"role":      models.RoleDelivery,
+				"updatedAt": time.Now(),
+			},
+		}
+		database.Database.Collection("users").UpdateOne(database.Ctx, bson.M{"email": "delivery@demo.com"}, update)
+		log.Println("Demo delivery user updated: delivery@demo.com / Delivery@123")
+	}
+}
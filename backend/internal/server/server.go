@@ -0,0 +1,356 @@
+// Package server assembles the API: it connects to MongoDB, wires the
+// handlers to their routes and runs the background jobs. The standalone
+// server in cmd and the Vercel function in api both serve it.
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"ecom-backend/internal/config"
+	"ecom-backend/internal/database"
+	"ecom-backend/internal/feeds"
+	"ecom-backend/internal/handlers"
+	"ecom-backend/internal/httpcache"
+	"ecom-backend/internal/mailer"
+	"ecom-backend/internal/middleware"
+	"ecom-backend/internal/models"
+	"ecom-backend/internal/pagination"
+	"ecom-backend/internal/pricing"
+	"ecom-backend/internal/storage"
+)
+
+// Server is the API app along with the handlers its background jobs use
+type Server struct {
+	App *fiber.App
+
+	cfg             *config.Config
+	products        *handlers.ProductsHandler
+	categories      *handlers.CategoriesHandler
+	pricing         *handlers.PricingHandler
+	feeds           *handlers.FeedsHandler
+	recommendations *handlers.RecommendationsHandler
+	reservations    *handlers.ReservationsHandler
+	restock         *handlers.RestockHandler
+	inventory       *handlers.InventoryHandler
+	cartReminders   *handlers.CartRemindersHandler
+}
+
+// New connects to MongoDB and builds the app with every route. Callers
+// disconnect with database.Disconnect when they are done.
+func New(cfg *config.Config) (*Server, error) {
+	if err := database.Connect(cfg.MongoURI, cfg.Database); err != nil {
+		return nil, fmt.Errorf("connect to MongoDB: %w", err)
+	}
+	if err := database.EnsureIndexes(); err != nil {
+		return nil, fmt.Errorf("create indexes: %w", err)
+	}
+
+	// List cursors are signed so clients cannot forge their positions
+	pagination.SetSecret(cfg.JWTSecret)
+
+	// Set up image storage
+	store, err := storage.New(storage.Config{
+		Driver:      cfg.StorageDriver,
+		LocalDir:    cfg.UploadDir,
+		S3Endpoint:  cfg.S3Endpoint,
+		S3Region:    cfg.S3Region,
+		S3Bucket:    cfg.S3Bucket,
+		S3AccessKey: cfg.S3AccessKey,
+		S3SecretKey: cfg.S3SecretKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initialize storage: %w", err)
+	}
+
+	// Set up outgoing mail
+	mail, err := mailer.New(mailer.Config{
+		Driver:       cfg.MailDriver,
+		From:         cfg.MailFrom,
+		SMTPHost:     cfg.SMTPHost,
+		SMTPPort:     cfg.SMTPPort,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initialize mailer: %w", err)
+	}
+
+	// Create Fiber app
+	app := fiber.New(fiber.Config{
+		// Leave headroom for multipart framing around image uploads
+		BodyLimit: int(cfg.MaxUploadSize) + 1<<20,
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			code := fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				code = e.Code
+			}
+			return c.Status(code).JSON(fiber.Map{"error": err.Error()})
+		},
+	})
+
+	// Middleware
+	app.Use(recover.New())
+	app.Use(logger.New())
+	// Configure CORS based on environment
+	var allowedOrigins string
+	if cfg.FrontendURL != "" {
+		allowedOrigins = cfg.FrontendURL + ",http://localhost:5173,http://localhost:5174"
+	} else {
+		allowedOrigins = "*"
+	}
+
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:     allowedOrigins,
+		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,If-Match,X-Cart-Token,Idempotency-Key",
+		ExposeHeaders:    "X-Cart-Token,Idempotent-Replayed",
+		AllowCredentials: cfg.FrontendURL != "",
+	}))
+
+	// Initialize handlers
+	pricingEngine := pricing.New(pricing.Config{
+		TaxRate:          cfg.TaxRate / 100,
+		ShippingRate:     cfg.ShippingRate,
+		FreeShippingOver: cfg.FreeShippingOver,
+	})
+	promotionsHandler := handlers.NewPromotionsHandler()
+	cartHandler := handlers.NewCartHandler(cfg.JWTSecret, time.Duration(cfg.GuestCartDays)*24*time.Hour, cfg.CartMergeStrategy, pricingEngine, promotionsHandler)
+	authHandler := handlers.NewAuthHandler(cfg.JWTSecret, cartHandler)
+	imagesHandler := handlers.NewImagesHandler(store, cfg.MaxUploadSize)
+	inventoryHandler := handlers.NewInventoryHandler(mail, cfg.AlertEmails, cfg.LowStockThreshold)
+	reservationsHandler := handlers.NewReservationsHandler(inventoryHandler, time.Duration(cfg.ReservationMinutes)*time.Minute)
+	restockHandler := handlers.NewRestockHandler(mail, cfg.PublicURL, cfg.FrontendURL)
+	categorySchemasHandler := handlers.NewCategorySchemasHandler()
+	categoriesHandler := handlers.NewCategoriesHandler()
+	// Feed links point at the storefront, or at the API when there is none
+	storefrontURL := cfg.FrontendURL
+	if storefrontURL == "" {
+		storefrontURL = cfg.PublicURL
+	}
+	feedsHandler := handlers.NewFeedsHandler(feeds.Site{
+		Title:         cfg.FeedTitle,
+		Currency:      cfg.FeedCurrency,
+		StorefrontURL: strings.TrimRight(storefrontURL, "/"),
+		APIURL:        strings.TrimRight(cfg.PublicURL, "/"),
+	})
+	cartRemindersHandler := handlers.NewCartRemindersHandler(mail, pricingEngine, promotionsHandler, storefrontURL,
+		time.Duration(cfg.AbandonedCartHours)*time.Hour,
+		handlers.CartCoupon{
+			Percent: cfg.AbandonedCartCouponPercent,
+			Valid:   time.Duration(cfg.AbandonedCartCouponDays) * 24 * time.Hour,
+		},
+	)
+	productsHandler := handlers.NewProductsHandler(imagesHandler, inventoryHandler, restockHandler, categorySchemasHandler, categoriesHandler)
+	wishlistsHandler := handlers.NewWishlistsHandler(cartHandler)
+	ordersHandler := handlers.NewOrdersHandler(inventoryHandler, pricingEngine, promotionsHandler, reservationsHandler)
+	usersHandler := handlers.NewUsersHandler()
+	reviewsHandler := handlers.NewReviewsHandler()
+	pricingHandler := handlers.NewPricingHandler()
+	recommendationsHandler := handlers.NewRecommendationsHandler()
+
+	// Health check
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+
+	// Debug endpoint to check user
+	app.Get("/debug/user/:email", func(c *fiber.Ctx) error {
+		email := c.Params("email")
+		var user models.User
+		err := database.Database.Collection("users").FindOne(database.Ctx, bson.M{"email": email}).Decode(&user)
+		if err != nil {
+			return c.JSON(fiber.Map{"error": "User not found", "details": err.Error()})
+		}
+		return c.JSON(fiber.Map{"user": user})
+	})
+
+	// API routes
+	// Sitemaps live at the site root; the storefront proxies them here
+	app.Get("/sitemap.xml", feedsHandler.GetSitemapIndex)
+	app.Get("/sitemaps/categories.xml", httpcache.CacheControl("public, max-age=300"), feedsHandler.GetCategorySitemap)
+	app.Get("/sitemaps/products-:page.xml", httpcache.CacheControl("public, max-age=300"), feedsHandler.GetProductSitemap)
+
+	api := app.Group("/api")
+	// Retried POSTs with an Idempotency-Key replay the first response. It
+	// goes after the auth middleware so keys are scoped to the user, and is
+	// left off the auth routes, whose responses carry tokens.
+	idempotent := middleware.Idempotency(time.Duration(cfg.IdempotencyKeyHours)*time.Hour, cartHandler.GuestCartID)
+
+	// Auth routes
+	api.Post("/auth/signup", authHandler.Signup)
+	api.Post("/auth/login", authHandler.Login)
+	api.Post("/auth/refresh", authHandler.RefreshToken)
+	api.Post("/auth/logout", middleware.AuthRequired(cfg.JWTSecret), authHandler.Logout)
+	api.Get("/auth/profile", middleware.AuthRequired(cfg.JWTSecret), authHandler.GetProfile)
+	api.Put("/auth/profile", middleware.AuthRequired(cfg.JWTSecret), authHandler.UpdateProfile)
+	api.Put("/auth/password", middleware.AuthRequired(cfg.JWTSecret), authHandler.ChangePassword)
+
+	// Product routes
+	api.Get("/products", httpcache.CacheControl("public, max-age=30"), productsHandler.GetProducts)
+	api.Get("/products/by-slug/:slug", httpcache.CacheControl("public, max-age=60"), productsHandler.GetProductBySlug)
+	api.Get("/products/all", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), productsHandler.GetAllProducts)
+	api.Get("/products/:id", httpcache.CacheControl("public, max-age=60"), productsHandler.GetProduct)
+	api.Post("/products", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), idempotent, productsHandler.CreateProduct)
+	api.Put("/products/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), productsHandler.UpdateProduct)
+	api.Delete("/products/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), productsHandler.DeleteProduct)
+	api.Put("/products/:id/restore", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), productsHandler.RestoreProduct)
+
+	// Product feed routes
+	api.Get("/feeds/products.xml", httpcache.CacheControl("public, max-age=300"), feedsHandler.GetRSSFeed)
+	api.Get("/feeds/products.csv", httpcache.CacheControl("public, max-age=300"), feedsHandler.GetCSVFeed)
+
+	// Category routes
+	api.Get("/categories", categoriesHandler.GetCategories)
+	api.Get("/categories/by-slug/:slug", categoriesHandler.GetCategoryBySlug)
+	api.Put("/categories/:category", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), categoriesHandler.UpdateCategory)
+
+	// Category attribute schema routes
+	api.Get("/category-schemas", categorySchemasHandler.GetSchemas)
+	api.Get("/categories/:category/schema", categorySchemasHandler.GetSchema)
+	api.Put("/categories/:category/schema", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), categorySchemasHandler.PutSchema)
+	api.Delete("/categories/:category/schema", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), categorySchemasHandler.DeleteSchema)
+
+	// Recommendation routes
+	api.Get("/products/:id/related", httpcache.CacheControl("public, max-age=300"), recommendationsHandler.GetRelated)
+	api.Get("/products/:id/bought-together", httpcache.CacheControl("public, max-age=300"), recommendationsHandler.GetBoughtTogether)
+
+	// Product image routes
+	api.Get("/products/:id/images", imagesHandler.GetProductImages)
+	api.Post("/products/:id/images", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), idempotent, imagesHandler.UploadProductImage)
+	api.Delete("/products/:id/images/:imageId", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), imagesHandler.DeleteProductImage)
+	api.Get("/images/*", imagesHandler.ServeImage)
+
+	// Pricing routes (Admin only)
+	api.Put("/products/:id/sale", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), pricingHandler.SetSale)
+	api.Delete("/products/:id/sale", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), pricingHandler.RemoveSale)
+	api.Get("/products/:id/price-schedules", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), pricingHandler.GetPriceSchedules)
+	api.Post("/products/:id/price-schedules", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), idempotent, pricingHandler.SchedulePrice)
+	api.Delete("/price-schedules/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), pricingHandler.CancelPriceSchedule)
+	api.Get("/products/:id/price-history", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), pricingHandler.GetPriceHistory)
+
+	// Review routes
+	api.Get("/products/:id/reviews", reviewsHandler.GetProductReviews)
+	api.Post("/products/:id/reviews", middleware.AuthRequired(cfg.JWTSecret), idempotent, reviewsHandler.CreateReview)
+	api.Get("/reviews", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), reviewsHandler.GetAllReviews)
+	api.Put("/reviews/:id", middleware.AuthRequired(cfg.JWTSecret), reviewsHandler.UpdateReview)
+	api.Delete("/reviews/:id", middleware.AuthRequired(cfg.JWTSecret), reviewsHandler.DeleteReview)
+	api.Post("/reviews/:id/helpful", middleware.AuthRequired(cfg.JWTSecret), idempotent, reviewsHandler.MarkHelpful)
+	api.Put("/reviews/:id/moderate", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), reviewsHandler.ModerateReview)
+
+	// Back-in-stock subscription routes
+	api.Post("/products/:id/restock-subscription", middleware.AuthRequired(cfg.JWTSecret), idempotent, restockHandler.Subscribe)
+	api.Delete("/products/:id/restock-subscription", middleware.AuthRequired(cfg.JWTSecret), restockHandler.Unsubscribe)
+	api.Get("/restock/unsubscribe", restockHandler.ConfirmUnsubscribe)
+	api.Post("/restock/unsubscribe", restockHandler.UnsubscribeByToken)
+
+	// Inventory routes (Admin only)
+	api.Get("/inventory/alerts", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), inventoryHandler.GetAlerts)
+	api.Put("/inventory/alerts/:id/acknowledge", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), inventoryHandler.AcknowledgeAlert)
+	api.Get("/inventory/low-stock", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), inventoryHandler.GetLowStockReport)
+	api.Get("/inventory/stock", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), reservationsHandler.GetStockLevels)
+
+	// Report routes (Admin only)
+	api.Get("/reports/abandoned-carts", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), cartRemindersHandler.GetRecoveryReport)
+
+	// Cart routes
+	// Guests use the cart with an X-Cart-Token instead of signing in
+	api.Get("/cart", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.GetCart)
+	api.Post("/cart", middleware.OptionalAuth(cfg.JWTSecret), idempotent, cartHandler.AddToCart)
+	api.Put("/cart/:productId", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.UpdateCartItem)
+	api.Delete("/cart/:productId", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.RemoveFromCart)
+	api.Delete("/cart", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.ClearCart)
+	api.Post("/cart/coupons", middleware.OptionalAuth(cfg.JWTSecret), idempotent, cartHandler.ApplyCoupon)
+	api.Delete("/cart/coupons/:code", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.RemoveCoupon)
+	api.Post("/cart/accept-prices", middleware.OptionalAuth(cfg.JWTSecret), idempotent, cartHandler.AcceptPrices)
+	api.Put("/cart/:productId/details", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.UpdateCartLine)
+	api.Post("/cart/:productId/save-for-later", middleware.OptionalAuth(cfg.JWTSecret), idempotent, cartHandler.SaveForLater)
+	api.Post("/cart/saved/:productId/move-to-cart", middleware.OptionalAuth(cfg.JWTSecret), idempotent, cartHandler.MoveToCart)
+	api.Delete("/cart/saved/:productId", middleware.OptionalAuth(cfg.JWTSecret), cartHandler.RemoveSavedItem)
+
+	// Promotion routes (Admin only)
+	api.Get("/promotions", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), promotionsHandler.GetPromotions)
+	api.Post("/promotions", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), idempotent, promotionsHandler.CreatePromotion)
+	api.Get("/promotions/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), promotionsHandler.GetPromotion)
+	api.Put("/promotions/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), promotionsHandler.UpdatePromotion)
+	api.Delete("/promotions/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), promotionsHandler.DeletePromotion)
+	api.Get("/promotions/:id/redemptions", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), promotionsHandler.GetRedemptions)
+
+	// Wishlist routes
+	api.Get("/wishlists", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.GetWishlists)
+	api.Post("/wishlists", middleware.AuthRequired(cfg.JWTSecret), idempotent, wishlistsHandler.CreateWishlist)
+	api.Get("/wishlists/shared/:token", wishlistsHandler.GetSharedWishlist)
+	api.Get("/wishlists/:id", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.GetWishlist)
+	api.Put("/wishlists/:id", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.RenameWishlist)
+	api.Delete("/wishlists/:id", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.DeleteWishlist)
+	api.Post("/wishlists/:id/items", middleware.AuthRequired(cfg.JWTSecret), idempotent, wishlistsHandler.AddItem)
+	api.Delete("/wishlists/:id/items/:productId", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.RemoveItem)
+	api.Post("/wishlists/:id/items/:productId/move-to-cart", middleware.AuthRequired(cfg.JWTSecret), idempotent, wishlistsHandler.MoveToCart)
+	api.Post("/wishlists/:id/share", middleware.AuthRequired(cfg.JWTSecret), idempotent, wishlistsHandler.ShareWishlist)
+	api.Delete("/wishlists/:id/share", middleware.AuthRequired(cfg.JWTSecret), wishlistsHandler.UnshareWishlist)
+
+	// Order routes
+	api.Post("/orders", middleware.AuthRequired(cfg.JWTSecret), idempotent, ordersHandler.CreateOrder)
+	api.Post("/checkout", middleware.AuthRequired(cfg.JWTSecret), idempotent, ordersHandler.Checkout)
+	api.Post("/checkout/reservation", middleware.AuthRequired(cfg.JWTSecret), idempotent, reservationsHandler.Reserve)
+	api.Get("/checkout/reservation", middleware.AuthRequired(cfg.JWTSecret), reservationsHandler.GetReservation)
+	api.Delete("/checkout/reservation", middleware.AuthRequired(cfg.JWTSecret), reservationsHandler.ReleaseReservation)
+	api.Get("/orders", middleware.AuthRequired(cfg.JWTSecret), ordersHandler.GetOrders)
+	api.Get("/orders/all", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), ordersHandler.GetAllOrders)
+	api.Get("/orders/:id", middleware.AuthRequired(cfg.JWTSecret), ordersHandler.GetOrder)
+	api.Put("/orders/:id/status", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), ordersHandler.UpdateOrderStatus)
+
+	// User management routes (Admin only)
+	api.Get("/users", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), usersHandler.GetUsers)
+	api.Get("/users/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), usersHandler.GetUser)
+	api.Put("/users/:id/block", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), usersHandler.BlockUser)
+	api.Put("/users/:id/unblock", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), usersHandler.UnblockUser)
+	api.Put("/orders/:orderId/assign/:deliveryId", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleAdmin), usersHandler.AssignOrderToDelivery)
+
+	// Delivery routes
+	api.Get("/delivery/orders", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleDelivery), ordersHandler.GetAssignedOrders)
+	api.Put("/delivery/orders/:id/delivered", middleware.AuthRequired(cfg.JWTSecret), middleware.RequireRole(models.RoleDelivery), ordersHandler.MarkAsDelivered)
+
+	s := &Server{
+		App:             app,
+		cfg:             cfg,
+		products:        productsHandler,
+		categories:      categoriesHandler,
+		pricing:         pricingHandler,
+		feeds:           feedsHandler,
+		recommendations: recommendationsHandler,
+		reservations:    reservationsHandler,
+		restock:         restockHandler,
+		inventory:       inventoryHandler,
+		cartReminders:   cartRemindersHandler,
+	}
+
+	// Runs the background jobs from a scheduler outside the process, such
+	// as Vercel Cron, where StartJobs is never called
+	api.Get("/jobs/run", s.RunJobs)
+
+	// Seed demo users
+	seedDemoUsers()
+
+	// Give catalogs from before slugs existed their product and category slugs
+	if filled, err := s.products.BackfillSlugs(); err != nil {
+		log.Printf("Failed to backfill product slugs: %v", err)
+	} else if filled > 0 {
+		log.Printf("Backfilled slugs for %d products", filled)
+	}
+	if created, err := s.categories.EnsureAll(); err != nil {
+		log.Printf("Failed to create categories: %v", err)
+	} else if created > 0 {
+		log.Printf("Created %d categories", created)
+	}
+
+	return s, nil
+}
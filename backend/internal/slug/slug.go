@@ -0,0 +1,63 @@
+// Package slug turns product titles and category names into URL path segments
+package slug
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength bounds slugs so URLs stay readable
+const MaxLength = 80
+
+var pattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// Make builds a slug from free text: accents are stripped, letters are
+// lowercased and everything else collapses into single hyphens. It returns
+// "" when nothing usable is left.
+func Make(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining accent split off by NFKD
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			hyphen = true
+		}
+	}
+	return truncate(b.String(), MaxLength)
+}
+
+// Valid reports whether s is a well-formed slug, as required of slugs that
+// admins type in
+func Valid(s string) bool {
+	return len(s) <= MaxLength && pattern.MatchString(s)
+}
+
+// WithSuffix appends -n to base, shortening base so the result still fits
+func WithSuffix(base string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	return truncate(base, MaxLength-len(suffix)) + suffix
+}
+
+// truncate cuts s to at most max bytes, preferring a hyphen boundary
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	if i := strings.LastIndexByte(s, '-'); i > 0 {
+		s = s[:i]
+	}
+	return strings.TrimRight(s, "-")
+}
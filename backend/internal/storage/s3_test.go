@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "product-images"
+)
+
+// The key derivation example from the AWS Signature Version 4 docs
+func TestSigningKey(t *testing.T) {
+	got := hex.EncodeToString(signingKey(testSecretKey, "20120215", "us-east-1", "iam"))
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got != want {
+		t.Errorf("signingKey = %s, want %s", got, want)
+	}
+}
+
+// fakeS3 is a local stand-in for an S3 bucket. It checks every request's
+// signature the way S3 does, recomputing it from what arrived on the wire,
+// and answers with status when one is set.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	requests []string
+	status   int
+	t        *testing.T
+}
+
+type fakeObject struct {
+	body        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Storage) {
+	fake := &fakeS3{objects: map[string]fakeObject{}, t: t}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(server.URL, testRegion, testBucket, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	return fake, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.EscapedPath())
+
+	if err := verifySignature(r, body); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	if f.status != 0 {
+		http.Error(w, "<Error><Code>Injected</Code></Error>", f.status)
+		return
+	}
+
+	prefix := "/" + testBucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = fakeObject{body: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Write(object.body)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verifySignature checks a Signature Version 4 Authorization header,
+// following the spec independently of the signer under test
+func verifySignature(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	algorithm, rest, _ := strings.Cut(auth, " ")
+	if algorithm != "AWS4-HMAC-SHA256" {
+		return fmt.Errorf("algorithm %q", algorithm)
+	}
+	for _, part := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != testAccessKey || credential[2] != testRegion ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("credential %q", fields["Credential"])
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("x-amz-date %q", amzDate)
+	}
+	if skew := time.Since(signedAt); skew > 5*time.Minute || skew < -5*time.Minute {
+		return fmt.Errorf("request signed %v ago", skew)
+	}
+	if credential[1] != amzDate[:8] {
+		return fmt.Errorf("credential date %s for request dated %s", credential[1], amzDate)
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	sum := sha256.Sum256(body)
+	if payloadHash != hex.EncodeToString(sum[:]) {
+		return errors.New("x-amz-content-sha256 does not match the body")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	required := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if r.Header.Get("Content-Type") != "" {
+		required = append(required, "content-type")
+	}
+	for _, name := range required {
+		if !contains(signedHeaders, name) {
+			return fmt.Errorf("%s is not signed", name)
+		}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	canonicalRequest := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.Query().Encode() + "\n" +
+		canonicalHeaders.String() + "\n" +
+		fields["SignedHeaders"] + "\n" +
+		payloadHash
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" +
+		strings.Join(credential[1:], "/") + "\n" +
+		hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range credential[1:] {
+		key = mac(key, part)
+	}
+	if want := hex.EncodeToString(mac(key, stringToSign)); fields["Signature"] != want {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+	key := "products/64b0c0ffee/front view.jpg"
+	content := "not really a jpeg"
+
+	if err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	r, info, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != content {
+		t.Errorf("Get body = %q, want %q", got, content)
+	}
+	if info.ContentType != "image/jpeg" || info.Size != int64(len(content)) || info.LastModified.IsZero() {
+		t.Errorf("Get info = %+v", info)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+
+	want := []string{
+		"PUT /product-images/products/64b0c0ffee/front%20view.jpg",
+		"GET /product-images/products/64b0c0ffee/front%20view.jpg",
+		"DELETE /product-images/products/64b0c0ffee/front%20view.jpg",
+		"GET /product-images/products/64b0c0ffee/front%20view.jpg",
+	}
+	if strings.Join(fake.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(fake.requests, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestS3ErrorMapping(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+
+	// A missing key is ErrNotFound on Get and not an error on Delete
+	if _, _, err := store.Get(ctx, "missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing: err = %v, want ErrNotFound", err)
+	}
+	fake.status = http.StatusNotFound
+	if err := store.Delete(ctx, "missing.jpg"); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+
+	// Other failures carry the status and the response body
+	for _, status := range []int{http.StatusForbidden, http.StatusInternalServerError} {
+		fake.status = status
+		checks := map[string]error{
+			"Put":    store.Put(ctx, "a.jpg", strings.NewReader("x"), 1, "image/jpeg"),
+			"Delete": store.Delete(ctx, "a.jpg"),
+		}
+		_, _, checks["Get"] = store.Get(ctx, "a.jpg")
+		for op, err := range checks {
+			if err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("%s with status %d: err = %v", op, status, err)
+				continue
+			}
+			if !strings.Contains(err.Error(), fmt.Sprint(status)) || !strings.Contains(err.Error(), "Injected") {
+				t.Errorf("%s with status %d: err = %q lacks the status or body", op, status, err)
+			}
+		}
+	}
+}
+
+// Keys that could reach outside the image prefix never leave the process
+func TestS3RejectsInvalidKeys(t *testing.T) {
+	fake, store := newFakeS3(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/products/a.jpg", "products/../secrets.env", "products/./a.jpg", "products//a.jpg", "products\\a.jpg"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put %q: err = %v, want ErrInvalidKey", key, err)
+		}
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get %q: err = %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete %q: err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("requests sent for invalid keys: %v", fake.requests)
+	}
+}
@@ -39,7 +39,7 @@ const ProductsAdmin = () => {
     };
 
     if (editingProduct) {
-      await dispatch(updateProduct({ id: editingProduct.id, productData }));
+      await dispatch(updateProduct({ id: editingProduct.id, productData, version: editingProduct.version }));
     } else {
       await dispatch(createProduct(productData));
     }
@@ -12,8 +12,8 @@ const UsersAdmin = () => {
 
   const fetchUsers = async () => {
     try {
-      const response = await api.get('/users');
-      setUsers(response.data);
+      const response = await api.get('/users', { params: { limit: 100 } });
+      setUsers(response.data.users);
     } catch (error) {
       console.error('Error fetching users:', error);
     } finally {
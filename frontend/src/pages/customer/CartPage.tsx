@@ -1,25 +1,43 @@
 import { useEffect, useState } from 'react';
 import { useDispatch, useSelector } from 'react-redux';
-import { useNavigate } from 'react-router-dom';
+import { useNavigate, useSearchParams } from 'react-router-dom';
 import { AppDispatch, RootState } from '../../store';
-import { fetchCart, updateCartItem, removeFromCart, clearCart } from '../../store/slices/cartSlice';
-import { createOrder } from '../../store/slices/orderSlice';
-import { ShoppingCart, Trash2, Plus, Minus } from 'lucide-react';
+import { fetchCart, updateCartItem, removeFromCart, clearCart, applyCoupon, removeCoupon, updateCartLine, saveForLater, moveToCart, removeSavedItem, acceptPrices } from '../../store/slices/cartSlice';
+import { checkout } from '../../store/slices/orderSlice';
+import { ShoppingCart, Trash2, Plus, Minus, X, AlertTriangle, Gift } from 'lucide-react';
 
 const CartPage = () => {
   const dispatch = useDispatch<AppDispatch>();
   const navigate = useNavigate();
-  const { items, loading } = useSelector((state: RootState) => state.cart);
+  const [searchParams, setSearchParams] = useSearchParams();
+  const { items, totals, coupons, savedForLater, warnings, loading, error } = useSelector((state: RootState) => state.cart);
   const { user } = useSelector((state: RootState) => state.auth);
-  const { loading: orderLoading } = useSelector((state: RootState) => state.orders);
+  const { loading: orderLoading, checkoutProblems } = useSelector((state: RootState) => state.orders);
   
   const [address, setAddress] = useState(user?.address || '');
   const [isProcessing, setIsProcessing] = useState(false);
+  const [couponCode, setCouponCode] = useState('');
+  // Kept across retries of an attempt whose answer was lost
+  const [checkoutKey, setCheckoutKey] = useState(() => crypto.randomUUID());
 
   useEffect(() => {
     dispatch(fetchCart());
   }, [dispatch]);
 
+  // Cart reminder emails link here with their coupon, which is applied on
+  // arrival. A code that no longer applies shows from the cart error state.
+  useEffect(() => {
+    const code = searchParams.get('coupon')?.trim();
+    if (!code) {
+      return;
+    }
+    // Drop the code from the URL so reloading does not apply it again
+    const rest = new URLSearchParams(searchParams);
+    rest.delete('coupon');
+    setSearchParams(rest, { replace: true });
+    dispatch(applyCoupon(code));
+  }, [dispatch, searchParams, setSearchParams]);
+
   useEffect(() => {
     if (user?.address) {
       setAddress(user.address);
@@ -38,6 +56,24 @@ const CartPage = () => {
     dispatch(removeFromCart(productId));
   };
 
+  const handleNoteChange = (productId: string, current: string | undefined, note: string) => {
+    if (note.trim() !== (current || '')) {
+      dispatch(updateCartLine({ productId, note }));
+    }
+  };
+
+  const handleApplyCoupon = async () => {
+    if (!couponCode.trim()) {
+      return;
+    }
+    try {
+      await dispatch(applyCoupon(couponCode.trim())).unwrap();
+      setCouponCode('');
+    } catch {
+      // Shown from the cart error state
+    }
+  };
+
   const handleClearCart = () => {
     dispatch(clearCart());
   };
@@ -55,29 +91,44 @@ const CartPage = () => {
 
     setIsProcessing(true);
     try {
-      const orderData = {
-        items: items,
+      // The server orders what is in the stored cart and stops if prices
+      // differ from what is shown here
+      const expectedPrices: Record<string, number> = {};
+      totals?.lines.forEach(line => {
+        expectedPrices[line.productId] = line.unitPrice;
+      });
+
+      await dispatch(checkout({
         address: address.trim(),
-      };
+        expectedTotal: totals?.total,
+        expectedPrices,
+        idempotencyKey: checkoutKey,
+      })).unwrap();
+
+      // The server emptied the cart with the order
+      dispatch(fetchCart());
 
-      await dispatch(createOrder(orderData)).unwrap();
-      
-      // Clear cart after successful order
-      dispatch(clearCart());
-      
       // Redirect to orders page
       navigate('/customer/orders');
-    } catch (error) {
+    } catch (error: any) {
       console.error('Checkout failed:', error);
-      alert('Failed to place order. Please try again.');
+      if (error?.network) {
+        alert('Could not reach the server. Please try again.');
+        return;
+      }
+      setCheckoutKey(crypto.randomUUID());
+      if (error?.problems?.length || error?.error === 'Promotion no longer available' || error?.error?.startsWith('Cart changed')) {
+        // Show the updated cart; checking out again confirms it
+        dispatch(fetchCart());
+      } else {
+        alert('Failed to place order. Please try again.');
+      }
     } finally {
       setIsProcessing(false);
     }
   };
 
-  const total = items.reduce((sum, item) => {
-    return sum + (item.product?.price || 0) * item.quantity;
-  }, 0);
+  const lineFor = (productId: string) => totals?.lines.find(line => line.productId === productId);
 
   if (loading) {
     return (
@@ -110,6 +161,26 @@ const CartPage = () => {
       ) : (
         <div className="grid lg:grid-cols-3 gap-8">
           <div className="lg:col-span-2 space-y-4">
+            {warnings.length > 0 && (
+              <div className="p-3 rounded-lg bg-yellow-900/30 border border-yellow-700">
+                <div className="flex items-center justify-between mb-2">
+                  <div className="flex items-center text-yellow-400 font-medium">
+                    <AlertTriangle className="h-4 w-4 mr-2" />
+                    Some items changed since you added them
+                  </div>
+                  {warnings.some(warning => warning.code === 'price_changed') && (
+                    <button onClick={() => dispatch(acceptPrices())} className="btn-secondary text-sm">
+                      Accept new prices
+                    </button>
+                  )}
+                </div>
+                <ul className="space-y-1 text-sm text-yellow-200">
+                  {warnings.map((warning, index) => (
+                    <li key={`${warning.code}-${warning.productId || index}`}>{warning.message}</li>
+                  ))}
+                </ul>
+              </div>
+            )}
             {items.map((item) => (
               <div key={item.productId} className="card flex items-center space-x-4">
                 <div className="w-20 h-20 bg-dark-700 rounded-lg overflow-hidden flex-shrink-0">
@@ -130,8 +201,48 @@ const CartPage = () => {
                     {item.product?.title}
                   </h3>
                   <p className="text-primary-500 font-semibold">
-                    ${item.product?.price.toFixed(2)}
+                    ${(lineFor(item.productId)?.unitPrice ?? item.product?.price ?? 0).toFixed(2)}
+                    {lineFor(item.productId)?.compareAtPrice && (
+                      <span className="ml-2 text-dark-400 line-through text-sm">
+                        ${lineFor(item.productId)?.compareAtPrice?.toFixed(2)}
+                      </span>
+                    )}
                   </p>
+                  {item.priceChanged && (
+                    <p className="text-yellow-400 text-sm">
+                      Was ${item.unitPrice?.toFixed(2)} when added
+                    </p>
+                  )}
+                  {item.stockChanged && (
+                    <p className="text-yellow-400 text-sm">
+                      {item.product?.stock ? `Only ${item.product.stock} left` : 'Out of stock'}
+                    </p>
+                  )}
+                  <input
+                    defaultValue={item.note || ''}
+                    onBlur={(e) => handleNoteChange(item.productId, item.note, e.target.value)}
+                    placeholder="Add a note"
+                    maxLength={500}
+                    className="input w-full mt-2 text-sm"
+                  />
+                  <div className="flex items-center space-x-4 mt-2 text-sm">
+                    <label className="flex items-center text-dark-300">
+                      <input
+                        type="checkbox"
+                        checked={!!item.gift}
+                        onChange={(e) => dispatch(updateCartLine({ productId: item.productId, gift: e.target.checked }))}
+                        className="mr-2"
+                      />
+                      <Gift className="h-4 w-4 mr-1" />
+                      This is a gift
+                    </label>
+                    <button
+                      onClick={() => dispatch(saveForLater(item.productId))}
+                      className="text-primary-500 hover:text-primary-400 transition-colors duration-200"
+                    >
+                      Save for later
+                    </button>
+                  </div>
                 </div>
                 <div className="flex items-center space-x-2">
                   <button
@@ -152,7 +263,7 @@ const CartPage = () => {
                 </div>
                 <div className="text-right">
                   <p className="text-lg font-semibold text-white">
-                    ${((item.product?.price || 0) * item.quantity).toFixed(2)}
+                    ${(lineFor(item.productId)?.subtotal ?? 0).toFixed(2)}
                   </p>
                   <button
                     onClick={() => handleRemoveItem(item.productId)}
@@ -171,20 +282,80 @@ const CartPage = () => {
               <div className="space-y-3 mb-6">
                 <div className="flex justify-between">
                   <span className="text-dark-300">Subtotal</span>
-                  <span className="text-white">${total.toFixed(2)}</span>
+                  <span className="text-white">${(totals?.subtotal ?? 0).toFixed(2)}</span>
+                </div>
+                {totals?.discounts.map((discount) => (
+                  <div key={discount.code || discount.label} className="flex justify-between">
+                    <span className="text-dark-300">{discount.label}</span>
+                    <span className="text-green-400">-${discount.amount.toFixed(2)}</span>
+                  </div>
+                ))}
+                <div className="flex justify-between">
+                  <span className="text-dark-300">Tax</span>
+                  <span className="text-white">${(totals?.tax ?? 0).toFixed(2)}</span>
                 </div>
                 <div className="flex justify-between">
                   <span className="text-dark-300">Shipping</span>
-                  <span className="text-white">Free</span>
+                  <span className="text-white">
+                    {totals && totals.shipping > 0 ? `$${totals.shipping.toFixed(2)}` : 'Free'}
+                  </span>
                 </div>
                 <div className="border-t border-dark-700 pt-3">
                   <div className="flex justify-between">
                     <span className="text-lg font-semibold text-white">Total</span>
-                    <span className="text-lg font-semibold text-primary-500">${total.toFixed(2)}</span>
+                    <span className="text-lg font-semibold text-primary-500">${(totals?.total ?? 0).toFixed(2)}</span>
                   </div>
                 </div>
               </div>
               
+              {checkoutProblems.length > 0 && (
+                <div className="mb-4 p-3 rounded-lg bg-yellow-900/30 border border-yellow-700">
+                  <div className="flex items-center text-yellow-400 font-medium mb-2">
+                    <AlertTriangle className="h-4 w-4 mr-2" />
+                    Please review your cart
+                  </div>
+                  <ul className="space-y-1 text-sm text-yellow-200">
+                    {checkoutProblems.map((problem, index) => (
+                      <li key={`${problem.code}-${problem.productId || index}`}>{problem.message}</li>
+                    ))}
+                  </ul>
+                </div>
+              )}
+
+              {/* Coupons */}
+              <div className="mb-4">
+                <label className="block text-sm font-medium text-white mb-2">
+                  Coupon Code
+                </label>
+                <div className="flex space-x-2">
+                  <input
+                    value={couponCode}
+                    onChange={(e) => setCouponCode(e.target.value)}
+                    placeholder="Enter a code"
+                    className="input flex-1"
+                  />
+                  <button onClick={handleApplyCoupon} className="btn-secondary">
+                    Apply
+                  </button>
+                </div>
+                {error && <p className="text-red-400 text-sm mt-2">{error}</p>}
+                {coupons.length > 0 && (
+                  <div className="flex flex-wrap gap-2 mt-2">
+                    {coupons.map((code) => (
+                      <span key={code} className="inline-flex items-center px-2 py-1 bg-dark-700 rounded text-sm text-white">
+                        {code}
+                        <button
+                          onClick={() => dispatch(removeCoupon(code))}
+                          className="ml-1 text-dark-400 hover:text-white"
+                        >
+                          <X className="h-3 w-3" />
+                        </button>
+                      </span>
+                    ))}
+                  </div>
+                )}
+              </div>
+
               {/* Address Input */}
               <div className="mb-4">
                 <label className="block text-sm font-medium text-white mb-2">
@@ -210,6 +381,38 @@ const CartPage = () => {
           </div>
         </div>
       )}
+
+      {savedForLater.length > 0 && (
+        <div className="space-y-4">
+          <h2 className="text-xl font-semibold text-white">Saved for Later</h2>
+          {savedForLater.map((item) => (
+            <div key={item.productId} className="card flex items-center space-x-4">
+              <div className="flex-1 min-w-0">
+                <h3 className="text-lg font-semibold text-white truncate">
+                  {item.product?.title}
+                </h3>
+                <p className="text-dark-300 text-sm">
+                  ${(item.product?.price ?? 0).toFixed(2)} &middot; Qty {item.quantity}
+                  {item.gift && ' · Gift'}
+                </p>
+                {item.note && <p className="text-dark-400 text-sm truncate">{item.note}</p>}
+              </div>
+              <button
+                onClick={() => dispatch(moveToCart(item.productId))}
+                className="btn-secondary"
+              >
+                Move to Cart
+              </button>
+              <button
+                onClick={() => dispatch(removeSavedItem(item.productId))}
+                className="text-red-400 hover:text-red-300 transition-colors duration-200"
+              >
+                <Trash2 className="h-4 w-4" />
+              </button>
+            </div>
+          ))}
+        </div>
+      )}
     </div>
   );
 };
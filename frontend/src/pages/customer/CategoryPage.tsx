@@ -0,0 +1,42 @@
+import { useEffect, useState } from 'react';
+import { useParams, useNavigate } from 'react-router-dom';
+import api from '../../utils/api';
+
+// CategoryPage serves the canonical /customer/categories/:slug URLs used in
+// sitemaps, opening the product list filtered to that category
+const CategoryPage = () => {
+  const { slug } = useParams();
+  const navigate = useNavigate();
+  const [notFound, setNotFound] = useState(false);
+
+  useEffect(() => {
+    if (!slug) {
+      return;
+    }
+    api
+      .get(`/categories/by-slug/${encodeURIComponent(slug)}`)
+      .then((response) => {
+        navigate(`/customer/products?category=${encodeURIComponent(response.data.name)}`, { replace: true });
+      })
+      .catch(() => setNotFound(true));
+  }, [slug, navigate]);
+
+  if (notFound) {
+    return (
+      <div className="text-center py-12">
+        <h3 className="text-lg font-semibold text-white mb-2">Category not found</h3>
+        <button onClick={() => navigate('/customer/products')} className="btn-primary">
+          Browse all products
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <div className="flex items-center justify-center py-12">
+      <div className="animate-spin rounded-full h-8 w-8 border-b-2 border-primary-500"></div>
+    </div>
+  );
+};
+
+export default CategoryPage;
@@ -2,6 +2,7 @@ import { Routes, Route } from 'react-router-dom';
 import CustomerLayout from './CustomerLayout';
 import ProductsPage from './ProductsPage';
 import ProductDetailsPage from './ProductDetailsPage';
+import CategoryPage from './CategoryPage';
 import CartPage from './CartPage';
 import OrdersPage from './OrdersPage';
 import OrderDetailsPage from './OrderDetailsPage';
@@ -14,6 +15,7 @@ const CustomerDashboard = () => {
         <Route path="/" element={<ProductsPage />} />
         <Route path="/products" element={<ProductsPage />} />
         <Route path="/products/:id" element={<ProductDetailsPage />} />
+        <Route path="/categories/:slug" element={<CategoryPage />} />
         <Route path="/cart" element={<CartPage />} />
         <Route path="/orders" element={<OrdersPage />} />
         <Route path="/orders/:id" element={<OrderDetailsPage />} />
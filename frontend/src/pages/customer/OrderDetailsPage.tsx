@@ -111,6 +111,12 @@ const OrderDetailsPage = () => {
                         </div>
                       )}
                     </div>
+                    {(item.note || item.gift) && (
+                      <p className="text-dark-300 text-sm mt-2">
+                        {item.gift && <span className="text-primary-400 font-medium mr-2">Gift</span>}
+                        {item.note}
+                      </p>
+                    )}
                   </div>
                   <div className="text-right">
                     <p className="text-xl font-bold text-primary-500 mb-1">
@@ -1,16 +1,56 @@
-import { useEffect } from 'react';
+import { useEffect, useState } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
 import { useDispatch, useSelector } from 'react-redux';
 import { AppDispatch, RootState } from '../../store';
 import { fetchProduct, clearCurrentProduct } from '../../store/slices/productSlice';
 import { addToCart } from '../../store/slices/cartSlice';
-import { ArrowLeft, ShoppingCart } from 'lucide-react';
+import { ArrowLeft, Bell, ShoppingCart } from 'lucide-react';
+import api from '../../utils/api';
+import { Product } from '../../types';
+
+const RecommendationList = ({ title, products }: { title: string; products: Product[] }) => {
+  const navigate = useNavigate();
+
+  if (products.length === 0) {
+    return null;
+  }
+
+  return (
+    <div>
+      <h3 className="text-lg font-semibold text-white mb-4">{title}</h3>
+      <div className="grid grid-cols-2 md:grid-cols-4 gap-4">
+        {products.map((product) => (
+          <button
+            key={product.id}
+            onClick={() => navigate(`/customer/products/${product.slug || product.id}`)}
+            className="card text-left hover:bg-dark-700 transition-colors duration-200"
+          >
+            {product.images && product.images.length > 0 ? (
+              <img
+                src={product.images[0]}
+                alt={product.title}
+                className="h-32 w-full object-cover rounded-md mb-2"
+              />
+            ) : (
+              <div className="h-32 w-full bg-dark-700 rounded-md mb-2" />
+            )}
+            <p className="text-sm text-white truncate">{product.title}</p>
+            <p className="text-sm font-semibold text-primary-500">${product.price.toFixed(2)}</p>
+          </button>
+        ))}
+      </div>
+    </div>
+  );
+};
 
 const ProductDetailsPage = () => {
   const { id } = useParams();
   const navigate = useNavigate();
   const dispatch = useDispatch<AppDispatch>();
   const { currentProduct, loading } = useSelector((state: RootState) => state.products);
+  const [restockSubscribed, setRestockSubscribed] = useState(false);
+  const [boughtTogether, setBoughtTogether] = useState<Product[]>([]);
+  const [related, setRelated] = useState<Product[]>([]);
 
   useEffect(() => {
     if (id) {
@@ -21,12 +61,41 @@ const ProductDetailsPage = () => {
     };
   }, [dispatch, id]);
 
+  // The URL may carry a slug, so recommendations wait for the product's ID
+  const productId = currentProduct?.id;
+
+  useEffect(() => {
+    if (!productId) {
+      return;
+    }
+    Promise.all([
+      api.get(`/products/${productId}/bought-together`),
+      api.get(`/products/${productId}/related`),
+    ])
+      .then(([together, similar]) => {
+        setBoughtTogether(together.data.products);
+        setRelated(similar.data.products);
+      })
+      .catch((error) => console.error('Failed to fetch recommendations:', error));
+  }, [productId]);
+
   const handleAddToCart = () => {
     if (currentProduct) {
       dispatch(addToCart({ productId: currentProduct.id, quantity: 1 }));
     }
   };
 
+  const handleNotifyMe = async () => {
+    if (currentProduct) {
+      try {
+        await api.post(`/products/${currentProduct.id}/restock-subscription`);
+        setRestockSubscribed(true);
+      } catch (error) {
+        console.error('Failed to subscribe to restock:', error);
+      }
+    }
+  };
+
   if (loading) {
     return (
       <div className="flex items-center justify-center py-12">
@@ -112,9 +181,22 @@ const ProductDetailsPage = () => {
               <ShoppingCart className="h-4 w-4 mr-2" />
               {currentProduct.stock === 0 ? 'Out of Stock' : 'Add to Cart'}
             </button>
+            {currentProduct.stock === 0 && (
+              <button
+                onClick={handleNotifyMe}
+                disabled={restockSubscribed}
+                className="btn-secondary flex items-center justify-center flex-1 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                <Bell className="h-4 w-4 mr-2" />
+                {restockSubscribed ? "We'll email you" : 'Notify me when back'}
+              </button>
+            )}
           </div>
         </div>
       </div>
+
+      <RecommendationList title="Frequently bought together" products={boughtTogether} />
+      <RecommendationList title="Related products" products={related} />
     </div>
   );
 };
@@ -136,6 +136,8 @@ const authSlice = createSlice({
         state.error = null;
         localStorage.setItem('token', action.payload.token);
         localStorage.setItem('refreshToken', action.payload.refreshToken);
+        // The server merged the guest cart into the user's cart
+        localStorage.removeItem('cartToken');
       })
       .addCase(login.rejected, (state, action) => {
         state.loading = false;
@@ -155,6 +157,8 @@ const authSlice = createSlice({
         state.error = null;
         localStorage.setItem('token', action.payload.token);
         localStorage.setItem('refreshToken', action.payload.refreshToken);
+        // The server merged the guest cart into the user's cart
+        localStorage.removeItem('cartToken');
       })
       .addCase(signup.rejected, (state, action) => {
         state.loading = false;
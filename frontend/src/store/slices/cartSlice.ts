@@ -1,15 +1,23 @@
 import { createSlice, createAsyncThunk, PayloadAction } from '@reduxjs/toolkit';
-import { CartItem } from '../../types';
+import { CartItem, CartResponse, CheckoutProblem, PriceBreakdown } from '../../types';
 import api from '../../utils/api';
 
 interface CartState {
   items: CartItem[];
+  totals: PriceBreakdown | null;
+  coupons: string[];
+  savedForLater: CartItem[];
+  warnings: CheckoutProblem[];
   loading: boolean;
   error: string | null;
 }
 
 const initialState: CartState = {
   items: [],
+  totals: null,
+  coupons: [],
+  savedForLater: [],
+  warnings: [],
   loading: false,
   error: null,
 };
@@ -19,7 +27,7 @@ export const fetchCart = createAsyncThunk(
   'cart/fetchCart',
   async (_, { rejectWithValue }) => {
     try {
-      const response = await api.get<{ items: CartItem[]; total: number }>('/cart');
+      const response = await api.get<CartResponse>('/cart');
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch cart');
@@ -33,7 +41,7 @@ export const addToCart = createAsyncThunk(
     try {
       await api.post('/cart', item);
       // Fetch updated cart to get product details
-      const response = await api.get<{ items: CartItem[]; total: number }>('/cart');
+      const response = await api.get<CartResponse>('/cart');
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to add to cart');
@@ -47,7 +55,7 @@ export const updateCartItem = createAsyncThunk(
     try {
       await api.put(`/cart/${productId}`, { quantity });
       // Fetch updated cart to get product details
-      const response = await api.get<{ items: CartItem[]; total: number }>('/cart');
+      const response = await api.get<CartResponse>('/cart');
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to update cart item');
@@ -61,7 +69,7 @@ export const removeFromCart = createAsyncThunk(
     try {
       await api.delete(`/cart/${productId}`);
       // Fetch updated cart to get product details
-      const response = await api.get<{ items: CartItem[]; total: number }>('/cart');
+      const response = await api.get<CartResponse>('/cart');
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to remove from cart');
@@ -69,6 +77,100 @@ export const removeFromCart = createAsyncThunk(
   }
 );
 
+export const applyCoupon = createAsyncThunk(
+  'cart/applyCoupon',
+  async (code: string, { rejectWithValue }) => {
+    try {
+      await api.post('/cart/coupons', { code });
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to apply coupon');
+    }
+  }
+);
+
+export const removeCoupon = createAsyncThunk(
+  'cart/removeCoupon',
+  async (code: string, { rejectWithValue }) => {
+    try {
+      await api.delete(`/cart/coupons/${encodeURIComponent(code)}`);
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to remove coupon');
+    }
+  }
+);
+
+export const updateCartLine = createAsyncThunk(
+  'cart/updateCartLine',
+  async (
+    { productId, note, gift }: { productId: string; note?: string; gift?: boolean },
+    { rejectWithValue }
+  ) => {
+    try {
+      await api.put(`/cart/${productId}/details`, { note, gift });
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to update cart item');
+    }
+  }
+);
+
+export const saveForLater = createAsyncThunk(
+  'cart/saveForLater',
+  async (productId: string, { rejectWithValue }) => {
+    try {
+      await api.post(`/cart/${productId}/save-for-later`);
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to save item for later');
+    }
+  }
+);
+
+export const moveToCart = createAsyncThunk(
+  'cart/moveToCart',
+  async (productId: string, { rejectWithValue }) => {
+    try {
+      await api.post(`/cart/saved/${productId}/move-to-cart`);
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to move item to cart');
+    }
+  }
+);
+
+export const removeSavedItem = createAsyncThunk(
+  'cart/removeSavedItem',
+  async (productId: string, { rejectWithValue }) => {
+    try {
+      await api.delete(`/cart/saved/${productId}`);
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to remove saved item');
+    }
+  }
+);
+
+export const acceptPrices = createAsyncThunk(
+  'cart/acceptPrices',
+  async (_, { rejectWithValue }) => {
+    try {
+      await api.post('/cart/accept-prices');
+      const response = await api.get<CartResponse>('/cart');
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue(error.response?.data?.error || 'Failed to accept prices');
+    }
+  }
+);
+
 export const clearCart = createAsyncThunk(
   'cart/clearCart',
   async (_, { rejectWithValue }) => {
@@ -105,6 +207,10 @@ const cartSlice = createSlice({
       .addCase(fetchCart.fulfilled, (state, action) => {
         state.loading = false;
         state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
       })
       .addCase(fetchCart.rejected, (state, action) => {
         state.loading = false;
@@ -118,6 +224,10 @@ const cartSlice = createSlice({
       .addCase(addToCart.fulfilled, (state, action) => {
         state.loading = false;
         state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
       })
       .addCase(addToCart.rejected, (state, action) => {
         state.loading = false;
@@ -131,6 +241,10 @@ const cartSlice = createSlice({
       .addCase(updateCartItem.fulfilled, (state, action) => {
         state.loading = false;
         state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
       })
       .addCase(updateCartItem.rejected, (state, action) => {
         state.loading = false;
@@ -144,11 +258,90 @@ const cartSlice = createSlice({
       .addCase(removeFromCart.fulfilled, (state, action) => {
         state.loading = false;
         state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
       })
       .addCase(removeFromCart.rejected, (state, action) => {
         state.loading = false;
         state.error = action.payload as string;
       })
+      // Coupons
+      .addCase(applyCoupon.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(applyCoupon.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
+      .addCase(removeCoupon.fulfilled, (state, action) => {
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      // Notes and saved for later
+      .addCase(updateCartLine.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(updateCartLine.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
+      .addCase(saveForLater.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(saveForLater.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
+      .addCase(moveToCart.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(moveToCart.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
+      .addCase(removeSavedItem.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(removeSavedItem.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
+      .addCase(acceptPrices.fulfilled, (state, action) => {
+        state.error = null;
+        state.items = action.payload.items || [];
+        state.totals = action.payload.totals;
+        state.coupons = action.payload.coupons || [];
+        state.savedForLater = action.payload.savedForLater || [];
+        state.warnings = action.payload.warnings || [];
+      })
+      .addCase(acceptPrices.rejected, (state, action) => {
+        state.error = action.payload as string;
+      })
       // Clear Cart
       .addCase(clearCart.pending, (state) => {
         state.loading = true;
@@ -157,6 +350,9 @@ const cartSlice = createSlice({
       .addCase(clearCart.fulfilled, (state) => {
         state.loading = false;
         state.items = [];
+        state.totals = null;
+        state.coupons = [];
+        state.warnings = [];
       })
       .addCase(clearCart.rejected, (state, action) => {
         state.loading = false;
@@ -1,11 +1,12 @@
 import { createSlice, createAsyncThunk } from '@reduxjs/toolkit';
-import { Order, CreateOrderRequest, UpdateOrderStatusRequest } from '../../types';
+import { Order, OrdersPage, CreateOrderRequest, CheckoutRequest, CheckoutProblem, UpdateOrderStatusRequest } from '../../types';
 import api from '../../utils/api';
 
 interface OrderState {
   orders: Order[];
   currentOrder: Order | null;
   deliveryAgents: any[];
+  checkoutProblems: CheckoutProblem[];
   loading: boolean;
   error: string | null;
 }
@@ -14,6 +15,7 @@ const initialState: OrderState = {
   orders: [],
   currentOrder: null,
   deliveryAgents: [],
+  checkoutProblems: [],
   loading: false,
   error: null,
 };
@@ -23,8 +25,8 @@ export const fetchOrders = createAsyncThunk(
   'orders/fetchOrders',
   async (_, { rejectWithValue }) => {
     try {
-      const response = await api.get<Order[]>('/orders');
-      return response.data;
+      const response = await api.get<OrdersPage>('/orders', { params: { limit: 100 } });
+      return response.data.orders;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch orders');
     }
@@ -35,8 +37,8 @@ export const fetchAllOrders = createAsyncThunk(
   'orders/fetchAllOrders',
   async (_, { rejectWithValue }) => {
     try {
-      const response = await api.get<Order[]>('/orders/all');
-      return response.data;
+      const response = await api.get<OrdersPage>('/orders/all', { params: { limit: 100 } });
+      return response.data.orders;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch orders');
     }
@@ -67,6 +69,28 @@ export const createOrder = createAsyncThunk(
   }
 );
 
+// checkout places an order from the server-side cart. It is rejected with
+// the problems to review when the cart changed since it was shown.
+// Retrying with the same idempotencyKey never places a second order.
+export const checkout = createAsyncThunk(
+  'orders/checkout',
+  async ({ idempotencyKey, ...request }: CheckoutRequest & { idempotencyKey: string }, { rejectWithValue }) => {
+    try {
+      const response = await api.post<Order>('/checkout', request, {
+        headers: { 'Idempotency-Key': idempotencyKey },
+      });
+      return response.data;
+    } catch (error: any) {
+      return rejectWithValue({
+        error: error.response?.data?.error || 'Failed to place order',
+        problems: (error.response?.data?.problems || []) as CheckoutProblem[],
+        // No answer arrived, so the order may have been placed
+        network: !error.response,
+      });
+    }
+  }
+);
+
 export const updateOrderStatus = createAsyncThunk(
   'orders/updateOrderStatus',
   async ({ id, status }: { id: string; status: UpdateOrderStatusRequest }, { rejectWithValue }) => {
@@ -83,8 +107,8 @@ export const fetchAssignedOrders = createAsyncThunk(
   'orders/fetchAssignedOrders',
   async (_, { rejectWithValue }) => {
     try {
-      const response = await api.get<Order[]>('/delivery/orders');
-      return response.data;
+      const response = await api.get<OrdersPage>('/delivery/orders', { params: { limit: 100 } });
+      return response.data.orders;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch assigned orders');
     }
@@ -119,8 +143,8 @@ export const fetchDeliveryAgents = createAsyncThunk(
   'orders/fetchDeliveryAgents',
   async (_, { rejectWithValue }) => {
     try {
-      const response = await api.get('/users');
-      const deliveryAgents = response.data.filter((user: any) => user.role === 'delivery' && user.isActive);
+      const response = await api.get('/users', { params: { role: 'delivery', limit: 100 } });
+      const deliveryAgents = response.data.users.filter((user: any) => user.isActive);
       return deliveryAgents;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch delivery agents');
@@ -193,6 +217,22 @@ const orderSlice = createSlice({
         state.loading = false;
         state.error = action.payload as string;
       })
+      // Checkout
+      .addCase(checkout.pending, (state) => {
+        state.loading = true;
+        state.error = null;
+      })
+      .addCase(checkout.fulfilled, (state, action) => {
+        state.loading = false;
+        state.checkoutProblems = [];
+        state.orders.unshift(action.payload);
+      })
+      .addCase(checkout.rejected, (state, action) => {
+        const payload = action.payload as { error: string; problems: CheckoutProblem[]; network: boolean };
+        state.loading = false;
+        state.error = payload.error;
+        state.checkoutProblems = payload.problems;
+      })
       // Update Order Status
       .addCase(updateOrderStatus.pending, (state) => {
         state.loading = true;
@@ -69,7 +69,9 @@ export const fetchProduct = createAsyncThunk(
   'products/fetchProduct',
   async (id: string, { rejectWithValue }) => {
     try {
-      const response = await api.get<Product>(`/products/${id}`);
+      // Product pages are addressed by slug, or by ID for older links
+      const path = /^[0-9a-f]{24}$/.test(id) ? `/products/${id}` : `/products/by-slug/${encodeURIComponent(id)}`;
+      const response = await api.get<Product>(path);
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to fetch product');
@@ -91,9 +93,14 @@ export const createProduct = createAsyncThunk(
 
 export const updateProduct = createAsyncThunk(
   'products/updateProduct',
-  async ({ id, productData }: { id: string; productData: UpdateProductRequest }, { rejectWithValue }) => {
+  async (
+    { id, productData, version }: { id: string; productData: UpdateProductRequest; version?: number },
+    { rejectWithValue }
+  ) => {
     try {
-      const response = await api.put<Product>(`/products/${id}`, productData);
+      // Send the version we edited so a concurrent change is rejected, not overwritten
+      const headers = version !== undefined ? { 'If-Match': `"${version}"` } : undefined;
+      const response = await api.put<Product>(`/products/${id}`, productData, { headers });
       return response.data;
     } catch (error: any) {
       return rejectWithValue(error.response?.data?.error || 'Failed to update product');
@@ -11,11 +11,15 @@ export interface User {
 export interface Product {
   id: string;
   title: string;
+  slug?: string;
   description: string;
+  metaTitle?: string;
+  metaDescription?: string;
   price: number;
   stock: number;
   images: string[];
   category: string;
+  version?: number;
   createdAt: string;
   updatedAt: string;
 }
@@ -23,6 +27,12 @@ export interface Product {
 export interface CartItem {
   productId: string;
   quantity: number;
+  unitPrice?: number;
+  currentPrice?: number;
+  priceChanged?: boolean;
+  stockChanged?: boolean;
+  note?: string;
+  gift?: boolean;
   product?: Product;
 }
 
@@ -31,6 +41,7 @@ export interface Order {
   userId: string;
   items: CartItem[];
   total: number;
+  pricing?: PriceBreakdown;
   status: 'pending' | 'shipped' | 'delivered' | 'cancelled';
   address: string;
   assignedTo?: string;
@@ -54,9 +65,63 @@ export interface SignupRequest {
   password: string;
 }
 
+export interface PricedLine {
+  productId: string;
+  title: string;
+  quantity: number;
+  unitPrice: number;
+  compareAtPrice?: number;
+  subtotal: number;
+}
+
+export interface PriceAdjustment {
+  promotionId?: string;
+  code?: string;
+  label: string;
+  amount: number;
+  shipping?: boolean;
+}
+
+export interface PriceBreakdown {
+  lines: PricedLine[];
+  subtotal: number;
+  discounts: PriceAdjustment[];
+  discount: number;
+  tax: number;
+  shipping: number;
+  total: number;
+}
+
+export interface CartResponse {
+  items: CartItem[] | null;
+  itemCount: number;
+  warnings?: CheckoutProblem[];
+  savedForLater?: CartItem[];
+  coupons?: string[];
+  totals: PriceBreakdown;
+  total: number;
+}
+
+// Orders are placed from the server-side cart; see CheckoutRequest
 export interface CreateOrderRequest {
-  items: CartItem[];
   address: string;
+  expectedTotal?: number;
+}
+
+export interface CheckoutRequest {
+  address: string;
+  expectedTotal?: number;
+  expectedPrices?: Record<string, number>;
+}
+
+export interface CheckoutProblem {
+  productId?: string;
+  code: 'invalid_quantity' | 'unavailable' | 'out_of_stock' | 'insufficient_stock' | 'price_changed' | 'total_changed';
+  message: string;
+  quantity?: number;
+  available?: number;
+  expected?: number;
+  current?: number;
 }
 
 export interface UpdateOrderStatusRequest {
@@ -99,5 +164,14 @@ export interface ProductsResponse {
   total: number;
   page: number;
   limit: number;
+  nextCursor: string;
+  prevCursor: string;
   categories: string[];
 }
+
+export interface OrdersPage {
+  orders: Order[];
+  limit: number;
+  nextCursor: string;
+  prevCursor: string;
+}
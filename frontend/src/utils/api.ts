@@ -16,6 +16,11 @@ api.interceptors.request.use(
     if (token) {
       config.headers.Authorization = `Bearer ${token}`;
     }
+    // Guest cart, merged into the user's cart on login
+    const cartToken = localStorage.getItem('cartToken');
+    if (cartToken) {
+      config.headers['X-Cart-Token'] = cartToken;
+    }
     return config;
   },
   (error) => {
@@ -25,7 +30,13 @@ api.interceptors.request.use(
 
 // Response interceptor to handle token refresh
 api.interceptors.response.use(
-  (response) => response,
+  (response) => {
+    const cartToken = response.headers['x-cart-token'];
+    if (cartToken) {
+      localStorage.setItem('cartToken', cartToken);
+    }
+    return response;
+  },
   async (error) => {
     const originalRequest = error.config;
 